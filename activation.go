@@ -0,0 +1,158 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"fmt"
+	"math"
+)
+
+// The activation function of the neurons of a layer. The
+// empty activation is the sigmoid, which is the activation
+// of every network that bp7 trains.
+type Activation string
+
+const (
+	// output = 1 / (1 + e^(-x))
+	SigmoidActivation Activation = "sigmoid"
+	// output = max(0, x)
+	ReLUActivation Activation = "relu"
+	// output = tanh(x)
+	TanhActivation Activation = "tanh"
+	// output_i = e^(x_i) / sum(e^(x_j)), over the neurons of
+	// the layer.
+	SoftmaxActivation Activation = "softmax"
+	// output = x
+	IdentityActivation Activation = "identity"
+)
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Returns the name of the activation, which is "sigmoid"
+// for the empty activation.
+func (a Activation) name() Activation {
+	if a == "" {
+		return SigmoidActivation
+	}
+
+	return a
+}
+
+// Applies the activation on the weighted sums of the
+// neurons of a layer, in place.
+// -Input sums: The weighted sum of each neuron.
+// -Output: The outputs of the neurons, which is sums.
+func (a Activation) apply(sums []float32) []float32 {
+	switch a.name() {
+	case SigmoidActivation:
+		for i := range sums {
+			sums[i] = sigmoid(sums[i])
+		}
+	case ReLUActivation:
+		for i := range sums {
+			if sums[i] < 0 {
+				sums[i] = 0
+			}
+		}
+	case TanhActivation:
+		for i := range sums {
+			sums[i] = float32(math.Tanh(float64(sums[i])))
+		}
+	case SoftmaxActivation:
+		// The maximum is subtracted so that e^x cannot overflow.
+		max := float32(math.Inf(-1))
+		for _, sum := range sums {
+			if sum > max {
+				max = sum
+			}
+		}
+
+		var total float64
+		for i := range sums {
+			sums[i] = float32(math.Exp(float64(sums[i] - max)))
+			total += float64(sums[i])
+		}
+
+		for i := range sums {
+			sums[i] = float32(float64(sums[i]) / total)
+		}
+	}
+
+	return sums
+}
+
+// Calculates the deltas of the neurons of a layer from their
+// outputs and their errors, by multiplying each error with the
+// slope of the activation. The softmax couples the neurons, so
+// its deltas take every output into account.
+// -Input outputs: The outputs of the neurons.
+// -Input errors: The error of each neuron.
+// -Output: The delta of each neuron.
+func (a Activation) deltas(outputs []float32, errors []float32) []float32 {
+	deltas := make([]float32, len(outputs))
+
+	switch a.name() {
+	case SigmoidActivation:
+		for i, output := range outputs {
+			deltas[i] = errors[i] * outputDerivative(output)
+		}
+	case ReLUActivation:
+		for i, output := range outputs {
+			if output > 0 {
+				deltas[i] = errors[i]
+			}
+		}
+	case TanhActivation:
+		for i, output := range outputs {
+			deltas[i] = errors[i] * (1 - output * output)
+		}
+	case SoftmaxActivation:
+		var weighted float32
+		for i, output := range outputs {
+			weighted += errors[i] * output
+		}
+
+		for i, output := range outputs {
+			deltas[i] = output * (errors[i] - weighted)
+		}
+	case IdentityActivation:
+		copy(deltas, errors)
+	}
+
+	return deltas
+}
+
+// Checks that the activation is one that bp7 implements.
+func (a Activation) check() error {
+	switch a.name() {
+	case SigmoidActivation, ReLUActivation, TanhActivation, SoftmaxActivation, IdentityActivation:
+		return nil
+	}
+
+	return fmt.Errorf("bp7: unsupported activation %q", string(a))
+}
+
+// Returns the outputs of the neurons of a layer.
+func layerOutputs(neurons []Neuron) []float32 {
+	outputs := make([]float32, len(neurons))
+
+	for i := range neurons {
+		outputs[i] = neurons[i].Output
+	}
+
+	return outputs
+}
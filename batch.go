@@ -0,0 +1,246 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// How many rows a worker scores before it checks
+// again whether the batch has been cancelled.
+const batchChunkSize = 256
+
+// The error returned when a batch is scored by a network
+// without any hidden or output neurons.
+var ErrUninitializedNetwork = errors.New("bp7: the network is not initialized")
+
+// The configuration of a batch prediction. The rows of
+// the batch are split into chunks and the chunks are
+// scored by a pool of goroutines.
+type BatchConfig struct {
+	// How many goroutines score the batch. A value lower
+	// than one means one goroutine per available CPU.
+	Workers int
+	// An optional context. When the context is cancelled
+	// the remaining rows are not scored and the batch
+	// returns the context error.
+	Context context.Context
+}
+
+// Returns a batch configuration with one worker per
+// available CPU and no cancellation.
+func DefaultBatchConfig() BatchConfig {
+	return BatchConfig{Workers: runtime.NumCPU()}
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Predicts the output categorization of each row of a batch
+// using one goroutine per available CPU.
+// -Input rows: The entries to predict the category.
+// -Output: The predicted category of each row, in the order
+// of the rows.
+func (n *Network) PredictBatch(rows [][]float32) ([]int, error) {
+	return n.PredictBatchWithConfig(rows, DefaultBatchConfig())
+}
+
+// Predicts the output categorization of each row of a batch.
+// -Input rows: The entries to predict the category.
+// -Input config: The worker count and the optional context.
+// -Output: The predicted category of each row, in the order
+// of the rows.
+func (n *Network) PredictBatchWithConfig(rows [][]float32, config BatchConfig) ([]int, error) {
+	predictions := make([]int, len(rows))
+
+	err := n.scoreBatch(rows, config, func(i int, outputs []float32) {
+		predictions[i] = argmax(outputs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return predictions, nil
+}
+
+// Calculates the class probabilities of each row of a batch,
+// like PredictProbabilities, using one goroutine per CPU.
+// -Input rows: The entries to predict the category.
+// -Output: The probability vector of each row, in the
+// order of the rows.
+func (n *Network) PredictProbabilitiesBatch(rows [][]float32) ([][]float32, error) {
+	return n.PredictProbabilitiesBatchWithConfig(rows, DefaultBatchConfig())
+}
+
+// Calculates the class probabilities of each row of a batch,
+// like PredictProbabilities.
+// -Input rows: The entries to predict the category.
+// -Input config: The worker count and the optional context.
+// -Output: The probability vector of each row, in the
+// order of the rows.
+func (n *Network) PredictProbabilitiesBatchWithConfig(rows [][]float32, config BatchConfig) ([][]float32, error) {
+	if n.Calibration != nil {
+		if err := n.Calibration.check(len(n.OutputLayer.Neurons)); err != nil {
+			return nil, err
+		}
+	}
+
+	probabilities := make([][]float32, len(rows))
+
+	// The calibrator has been checked against the outputs, so
+	// it cannot fail on a row.
+	err := n.scoreBatch(rows, config, func(i int, outputs []float32) {
+		probabilities[i], _ = n.probabilities(outputs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return probabilities, nil
+}
+
+// Splits the rows of a batch into chunks and propagates
+// each row through the network. Every row index is handed
+// to exactly one goroutine, so the collect function may
+// write into a preallocated slice without locking.
+// -Input rows: The entries of the batch.
+// -Input config: The worker count and the optional context.
+// -Input collect: Receives the index and the raw output
+// vector of each row.
+func (n *Network) scoreBatch(rows [][]float32, config BatchConfig, collect func(int, []float32)) error {
+	if len(n.HiddenLayer.Neurons) == 0 || len(n.OutputLayer.Neurons) == 0 {
+		return ErrUninitializedNetwork
+	}
+
+	inputCount := n.inputCount()
+
+	for i := 0; i < len(rows); i++ {
+		if len(rows[i]) < inputCount {
+			return fmt.Errorf("bp7: row %d has %d values but the network expects %d inputs", i, len(rows[i]), inputCount)
+		}
+	}
+
+	return scoreRows(rows, config, n.infer, collect)
+}
+
+// Propagates each row of a batch with a scoring function,
+// in chunks which are spread over the workers.
+// -Input rows: The entries of the batch.
+// -Input config: The worker count and the optional context.
+// -Input score: Returns the raw output vector of a row. It
+// must be safe to call it from many goroutines.
+// -Input collect: Receives the index and the raw output
+// vector of each row.
+func scoreRows(rows [][]float32, config BatchConfig, score func([]float32) []float32, collect func(int, []float32)) error {
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	chunks := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for start := range chunks {
+				end := start + batchChunkSize
+				if end > len(rows) {
+					end = len(rows)
+				}
+
+				for i := start; i < end; i++ {
+					collect(i, score(rows[i]))
+				}
+			}
+		}()
+	}
+
+	var err error
+
+dispatch:
+	for start := 0; start < len(rows); start += batchChunkSize {
+		select {
+		case chunks <- start:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break dispatch
+		}
+	}
+
+	close(chunks)
+	wg.Wait()
+
+	return err
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Predicts the output categorization of each row of a batch
+// using one goroutine per available CPU.
+// -Input n: A network.
+// -Input rows: The entries to predict the category.
+// -Output: The predicted category of each row, in the order
+// of the rows.
+func PredictBatch(n *Network, rows [][]float32) ([]int, error) {
+	return n.PredictBatch(rows)
+}
+
+// Predicts the output categorization of each row of a batch.
+// -Input n: A network.
+// -Input rows: The entries to predict the category.
+// -Input config: The worker count and the optional context.
+// -Output: The predicted category of each row, in the order
+// of the rows.
+func PredictBatchWithConfig(n *Network, rows [][]float32, config BatchConfig) ([]int, error) {
+	return n.PredictBatchWithConfig(rows, config)
+}
+
+// Calculates the class probabilities of each row of a batch,
+// like PredictProbabilities, using one goroutine per CPU.
+// -Input n: A network.
+// -Input rows: The entries to predict the category.
+// -Output: The probability vector of each row, in the
+// order of the rows.
+func PredictProbabilitiesBatch(n *Network, rows [][]float32) ([][]float32, error) {
+	return n.PredictProbabilitiesBatch(rows)
+}
+
+// Calculates the class probabilities of each row of a batch,
+// like PredictProbabilities.
+// -Input n: A network.
+// -Input rows: The entries to predict the category.
+// -Input config: The worker count and the optional context.
+// -Output: The probability vector of each row, in the
+// order of the rows.
+func PredictProbabilitiesBatchWithConfig(n *Network, rows [][]float32, config BatchConfig) ([][]float32, error) {
+	return n.PredictProbabilitiesBatchWithConfig(rows, config)
+}
@@ -0,0 +1,523 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+// How the binary model stores each weight.
+type WeightPrecision uint8
+
+const (
+	// Stores the weights exactly, in 4 bytes each.
+	Float32Weights WeightPrecision = 0
+	// Stores the weights as IEEE half precision floats, in 2
+	// bytes each, with about 3 significant decimal digits.
+	Float16Weights WeightPrecision = 1
+	// Stores the weights as bfloat16, the upper half of a
+	// float32, in 2 bytes each. It keeps the range of float32
+	// with about 2 significant decimal digits.
+	BFloat16Weights WeightPrecision = 2
+)
+
+// How the binary model is compressed.
+type ModelCompression uint8
+
+const (
+	NoCompression   ModelCompression = 0
+	GzipCompression ModelCompression = 1
+)
+
+// The version of the binary model format.
+const BinaryModelVersion = 1
+
+// The first bytes of a binary model.
+const binaryModelMagic = "BP7B"
+
+// The size of the binary model header: the magic, the
+// version, the compression and the precision.
+const binaryHeaderSize = 8
+
+// The largest layer size of a binary model, which bounds the
+// sizes of a malformed file before anything is allocated.
+const maxBinaryLayerSize = 1 << 24
+
+// The options of the binary model format.
+type BinaryOptions struct {
+	Precision   WeightPrecision
+	Compression ModelCompression
+}
+
+// Whether the host stores numbers in little-endian order,
+// which allows the float32 weights to be used in place.
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// Returns the options of an exact, uncompressed binary
+// model, which can be loaded without copying the weights.
+func DefaultBinaryOptions() BinaryOptions {
+	return BinaryOptions{Precision: Float32Weights, Compression: NoCompression}
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Saves the network in the compact little-endian binary model
+// format. The layout is an 8 byte header, "BP7B", the version
+// (uint16), the compression and the weight precision (uint8),
+// followed by the payload, which is compressed if requested:
+// the input, hidden and output sizes (uint32), the hidden and
+// the output weights with the bias of each neuron last, the
+// length (uint32) and the csv sections of the layer activations,
+// the metadata and the attached components, and the CRC-32
+// (uint32) of the payload.
+// -Input w: The writer of the model.
+// -Input options: The weight precision and the compression.
+func (n *Network) SaveBinary(w io.Writer, options BinaryOptions) error {
+	if len(n.HiddenLayer.Neurons) == 0 || len(n.OutputLayer.Neurons) == 0 {
+		return ErrUninitializedNetwork
+	}
+
+	if options.Precision > BFloat16Weights {
+		return fmt.Errorf("bp7: unknown weight precision %d", options.Precision)
+	}
+
+	if options.Compression > GzipCompression {
+		return fmt.Errorf("bp7: unknown model compression %d", options.Compression)
+	}
+
+	var payload bytes.Buffer
+
+	sizes := []uint32{
+		uint32(n.inputCount()),
+		uint32(len(n.HiddenLayer.Neurons)),
+		uint32(len(n.OutputLayer.Neurons)),
+	}
+
+	binary.Write(&payload, binary.LittleEndian, sizes)
+
+	for _, neurons := range [][]Neuron{n.HiddenLayer.Neurons, n.OutputLayer.Neurons} {
+		for _, neuron := range neurons {
+			writeWeights(&payload, neuron.Weights, options.Precision)
+		}
+	}
+
+	var components bytes.Buffer
+	activations := section{"activations", n.activationRecords()}
+
+	if err := writeSections(&components, append([]section{activations}, n.sections()[2:]...)); err != nil {
+		return err
+	}
+
+	binary.Write(&payload, binary.LittleEndian, uint32(components.Len()))
+	payload.Write(components.Bytes())
+	binary.Write(&payload, binary.LittleEndian, crc32.ChecksumIEEE(payload.Bytes()))
+
+	header := make([]byte, binaryHeaderSize)
+	copy(header, binaryModelMagic)
+	binary.LittleEndian.PutUint16(header[4:], BinaryModelVersion)
+	header[6] = byte(options.Compression)
+	header[7] = byte(options.Precision)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	if options.Compression == GzipCompression {
+		compressed := gzip.NewWriter(w)
+
+		if _, err := compressed.Write(payload.Bytes()); err != nil {
+			return err
+		}
+
+		return compressed.Close()
+	}
+
+	_, err := w.Write(payload.Bytes())
+
+	return err
+}
+
+// Loads a binary model into the network.
+// -Input r: The reader of the model.
+func (n *Network) LoadBinary(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return n.loadBinary(data, false)
+}
+
+// Loads a binary model from memory into the network. When the
+// model is uncompressed, stores float32 weights and the host
+// is little-endian, the weights of the network point into the
+// data instead of being copied, so the data must not change
+// while the network is in use.
+// -Input data: The bytes of the model.
+func (n *Network) LoadBinaryBytes(data []byte) error {
+	return n.loadBinary(data, true)
+}
+
+// Loads a binary model.
+// -Input data: The bytes of the model.
+// -Input inPlace: Whether the float32 weights may point into
+// the data.
+func (n *Network) loadBinary(data []byte, inPlace bool) error {
+	if len(data) < binaryHeaderSize || string(data[:4]) != binaryModelMagic {
+		return errors.New("bp7: the stream is not a binary bp7 model")
+	}
+
+	version := binary.LittleEndian.Uint16(data[4:])
+	if version < 1 || version > BinaryModelVersion {
+		return fmt.Errorf("bp7: unsupported binary model version %d", version)
+	}
+
+	compression := ModelCompression(data[6])
+	precision := WeightPrecision(data[7])
+	payload := data[binaryHeaderSize:]
+
+	switch compression {
+	case NoCompression:
+	case GzipCompression:
+		decompressed, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+
+		payload, err = ioutil.ReadAll(decompressed)
+		if err != nil {
+			return err
+		}
+
+		inPlace = false
+	default:
+		return fmt.Errorf("bp7: unknown model compression %d", compression)
+	}
+
+	width := 4
+	switch precision {
+	case Float32Weights:
+	case Float16Weights, BFloat16Weights:
+		width = 2
+	default:
+		return fmt.Errorf("bp7: unknown weight precision %d", precision)
+	}
+
+	if len(payload) < 20 {
+		return errors.New("bp7: the binary model is truncated")
+	}
+
+	body := payload[:len(payload) - 4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(payload[len(body):]) {
+		return errors.New("bp7: the binary model checksum does not match, the file is corrupted")
+	}
+
+	inputs := int64(binary.LittleEndian.Uint32(body[0:]))
+	hidden := int64(binary.LittleEndian.Uint32(body[4:]))
+	outputs := int64(binary.LittleEndian.Uint32(body[8:]))
+
+	// The sizes come from the file, so they are checked before
+	// anything is sliced or allocated with them.
+	for _, size := range []int64{inputs, hidden, outputs} {
+		if size > maxBinaryLayerSize {
+			return fmt.Errorf("bp7: the binary model has a layer size %d larger than %d", size, maxBinaryLayerSize)
+		}
+	}
+
+	if hidden < 1 || outputs < 1 {
+		return errors.New("bp7: the binary model has an empty layer")
+	}
+
+	count := hidden * (inputs + 1) + outputs * (hidden + 1)
+	if count > int64(len(body) - 16) / int64(width) {
+		return errors.New("bp7: the binary model is truncated")
+	}
+
+	end := 12 + int(count) * width
+	weights := readWeights(body[12:end], precision, inPlace)
+
+	componentsLength := int64(binary.LittleEndian.Uint32(body[end:]))
+	if componentsLength != int64(len(body) - end - 4) {
+		return errors.New("bp7: the binary model is truncated")
+	}
+
+	sections, err := readSections(bytes.NewReader(body[end + 4:]))
+	if err != nil {
+		return err
+	}
+
+	loaded := Network{}
+	loaded.HiddenLayer.Neurons = splitNeurons(weights[:hidden * (inputs + 1)], int(hidden), int(inputs + 1))
+	loaded.OutputLayer.Neurons = splitNeurons(weights[hidden * (inputs + 1):], int(outputs), int(hidden + 1))
+
+	for _, s := range sections {
+		if s.name == "activations" {
+			if err := loaded.parseActivations(s.records); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		known, err := loaded.parseSection(s)
+		if err != nil {
+			return err
+		}
+
+		if !known {
+			return fmt.Errorf("bp7: unknown model section %q", s.name)
+		}
+	}
+
+	if err := loaded.checkComponents(); err != nil {
+		return err
+	}
+
+	*n = loaded
+
+	return nil
+}
+
+// Returns the activations of the layers as csv records, one
+// record per layer with the layer and the activation name.
+func (n *Network) activationRecords() [][]string {
+	return [][]string{
+		{"hidden", string(n.HiddenLayer.Activation.name())},
+		{"output", string(n.OutputLayer.Activation.name())},
+	}
+}
+
+// Restores the activations of the layers, which the models
+// written before the activations existed do not have.
+// -Input records: The layer and the activation records.
+func (n *Network) parseActivations(records [][]string) error {
+	for _, record := range records {
+		if len(record) != 2 {
+			return fmt.Errorf("bp7: invalid activation record %v", record)
+		}
+
+		activation := Activation(record[1])
+		if err := activation.check(); err != nil {
+			return err
+		}
+
+		switch record[0] {
+		case "hidden":
+			n.HiddenLayer.Activation = activation
+		case "output":
+			n.OutputLayer.Activation = activation
+		default:
+			return fmt.Errorf("bp7: unknown layer %q", record[0])
+		}
+	}
+
+	return nil
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Saves a network in the compact binary model format.
+// -Input n: A network.
+// -Input w: The writer of the model.
+// -Input options: The weight precision and the compression.
+func SaveBinary(n *Network, w io.Writer, options BinaryOptions) error {
+	return n.SaveBinary(w, options)
+}
+
+// Loads a binary model into a network.
+// -Input n: A network.
+// -Input r: The reader of the model.
+func LoadBinary(n *Network, r io.Reader) error {
+	return n.LoadBinary(r)
+}
+
+// Loads a binary model from memory into a network, without
+// copying the weights where possible.
+// -Input n: A network.
+// -Input data: The bytes of the model.
+func LoadBinaryBytes(n *Network, data []byte) error {
+	return n.LoadBinaryBytes(data)
+}
+
+// Writes weights in the given precision.
+func writeWeights(w *bytes.Buffer, weights []float32, precision WeightPrecision) {
+	buffer := make([]byte, 4)
+
+	for _, weight := range weights {
+		switch precision {
+		case Float32Weights:
+			binary.LittleEndian.PutUint32(buffer, math.Float32bits(weight))
+			w.Write(buffer)
+		case Float16Weights:
+			binary.LittleEndian.PutUint16(buffer, float32ToFloat16(weight))
+			w.Write(buffer[:2])
+		case BFloat16Weights:
+			binary.LittleEndian.PutUint16(buffer, float32ToBFloat16(weight))
+			w.Write(buffer[:2])
+		}
+	}
+}
+
+// Reads weights of the given precision. The float32 weights
+// are used in place when it is allowed and possible.
+func readWeights(data []byte, precision WeightPrecision, inPlace bool) []float32 {
+	if precision == Float32Weights {
+		if inPlace && nativeLittleEndian && len(data) > 0 && uintptr(unsafe.Pointer(&data[0])) % 4 == 0 {
+			var weights []float32
+
+			header := (*reflect.SliceHeader)(unsafe.Pointer(&weights))
+			header.Data = uintptr(unsafe.Pointer(&data[0]))
+			header.Len = len(data) / 4
+			header.Cap = len(data) / 4
+
+			return weights
+		}
+
+		weights := make([]float32, len(data) / 4)
+		for i := range weights {
+			weights[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[i * 4:]))
+		}
+
+		return weights
+	}
+
+	weights := make([]float32, len(data) / 2)
+
+	for i := range weights {
+		bits := binary.LittleEndian.Uint16(data[i * 2:])
+
+		if precision == Float16Weights {
+			weights[i] = float16ToFloat32(bits)
+		} else {
+			weights[i] = math.Float32frombits(uint32(bits) << 16)
+		}
+	}
+
+	return weights
+}
+
+// Splits a flat weight vector into neurons. The capacity of
+// each neuron is limited, so that appending to its weights
+// does not overwrite the next neuron.
+func splitNeurons(weights []float32, count int, size int) []Neuron {
+	neurons := make([]Neuron, count)
+
+	for i := 0; i < count; i++ {
+		neurons[i].Weights = weights[i * size:(i + 1) * size:(i + 1) * size]
+	}
+
+	return neurons
+}
+
+// Converts a float32 into an IEEE half precision float,
+// rounding to the nearest even value. The values beyond the
+// half precision range become infinite.
+func float32ToFloat16(value float32) uint16 {
+	bits := math.Float32bits(value)
+	sign := uint16(bits >> 16) & 0x8000
+	exponent := int(bits >> 23 & 0xff)
+	mantissa := bits & 0x7fffff
+
+	switch {
+	case exponent == 0xff:
+		// Infinity, or NaN which keeps a mantissa bit.
+		if mantissa != 0 {
+			return sign | 0x7e00
+		}
+
+		return sign | 0x7c00
+	case exponent - 127 > 15:
+		return sign | 0x7c00
+	case exponent - 127 >= -14:
+		half := uint32(exponent - 127 + 15) << 10 | mantissa >> 13
+
+		// Rounding to the nearest even may carry into the
+		// exponent, which is still the correct result.
+		remainder := mantissa & 0x1fff
+		if remainder > 0x1000 || (remainder == 0x1000 && half & 1 == 1) {
+			half++
+		}
+
+		return sign | uint16(half)
+	case exponent - 127 >= -25:
+		// A subnormal half: the implicit bit becomes explicit.
+		mantissa |= 0x800000
+		shift := uint(-14 - (exponent - 127) + 13)
+		half := mantissa >> shift
+
+		remainder := mantissa & (1 << shift - 1)
+		middle := uint32(1) << (shift - 1)
+		if remainder > middle || (remainder == middle && half & 1 == 1) {
+			half++
+		}
+
+		return sign | uint16(half)
+	}
+
+	return sign
+}
+
+// Converts an IEEE half precision float into a float32.
+func float16ToFloat32(half uint16) float32 {
+	sign := uint32(half & 0x8000) << 16
+	exponent := uint32(half >> 10 & 0x1f)
+	mantissa := uint32(half & 0x3ff)
+
+	switch {
+	case exponent == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mantissa << 13)
+	case exponent == 0:
+		// A subnormal half is mantissa * 2^-24.
+		value := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			value = -value
+		}
+
+		return value
+	}
+
+	return math.Float32frombits(sign | (exponent + 127 - 15) << 23 | mantissa << 13)
+}
+
+// Converts a float32 into a bfloat16, rounding to the
+// nearest even value.
+func float32ToBFloat16(value float32) uint16 {
+	bits := math.Float32bits(value)
+
+	if value != value {
+		return uint16(bits >> 16) | 0x40
+	}
+
+	rounding := uint32(0x7fff) + (bits >> 16 & 1)
+
+	return uint16((bits + rounding) >> 16)
+}
@@ -0,0 +1,698 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// The method a calibrator uses in order to map the
+// outputs of the network to probabilities.
+type CalibrationMethod string
+
+const (
+	// Fits a logistic regression per class on the logit of
+	// the output of the class (Platt, 1999).
+	PlattScaling CalibrationMethod = "platt"
+	// Divides the logits of all classes by a single fitted
+	// temperature and applies the softmax function.
+	TemperatureScaling CalibrationMethod = "temperature"
+	// Fits a non-decreasing step function per class on the
+	// output of the class (pool adjacent violators).
+	IsotonicRegression CalibrationMethod = "isotonic"
+)
+
+// The file name of the calibration when it is extracted
+// together with the layer weights.
+const calibrationFileName = "calibration.csv"
+
+// The smallest distance of a sigmoid output from 0 and 1
+// when it is turned back into a logit.
+const logitEpsilon = 1e-7
+
+// A calibrator maps the sigmoid outputs of the network to
+// calibrated class probabilities. It is fitted on a
+// held-out set which was not used for training.
+type Calibrator struct {
+	Method CalibrationMethod
+	// The Platt scaling parameters of each class. The
+	// probability of the class k is 1 / (1 + e^(A[k] * f + B[k]))
+	// where f is the logit of the output of the class k.
+	A []float32
+	B []float32
+	// The temperature scaling parameter.
+	Temperature float32
+	// The isotonic regression of each class as the points
+	// of a piecewise linear, non-decreasing function.
+	Thresholds [][]float32
+	Values     [][]float32
+}
+
+// A bin of a reliability diagram. The bin collects the
+// entries whose top probability is in [Lower, Upper).
+type ReliabilityBin struct {
+	Lower          float32
+	Upper          float32
+	Count          int
+	MeanConfidence float32
+	Accuracy       float32
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Fits a calibrator on a held-out set and attaches it to the
+// network. From then on the probabilities returned by the
+// network are calibrated. The predicted category of Predict
+// is not affected.
+// -Input heldOut: Labelled rows which were not used for training.
+// -Input method: The calibration method.
+func (n *Network) Calibrate(heldOut [][]float32, method CalibrationMethod) error {
+	calibrator, err := FitCalibrator(n, heldOut, method)
+	if err != nil {
+		return err
+	}
+
+	n.Calibration = calibrator
+
+	return nil
+}
+
+// Maps the raw output vector of the network to calibrated
+// probabilities which sum up to one.
+// -Input outputs: The output vector of the network.
+// -Output: The calibrated probabilities, or an error if the
+// calibrator does not have the parameters of every output.
+func (c *Calibrator) Apply(outputs []float32) ([]float32, error) {
+	if err := c.check(len(outputs)); err != nil {
+		return nil, err
+	}
+
+	probabilities := make([]float32, len(outputs))
+
+	switch c.Method {
+	case PlattScaling:
+		for k := 0; k < len(outputs); k++ {
+			probabilities[k] = plattProbability(logit(outputs[k]), float64(c.A[k]), float64(c.B[k]))
+		}
+	case TemperatureScaling:
+		return temperatureSoftmax(outputs, float64(c.Temperature)), nil
+	case IsotonicRegression:
+		for k := 0; k < len(outputs); k++ {
+			probabilities[k] = interpolate(c.Thresholds[k], c.Values[k], outputs[k])
+		}
+	default:
+		copy(probabilities, outputs)
+	}
+
+	return normalize(probabilities), nil
+}
+
+// Checks that the calibrator has the parameters of each
+// class of a network.
+// -Input classes: How many outputs the network has.
+func (c *Calibrator) check(classes int) error {
+	switch c.Method {
+	case PlattScaling:
+		if len(c.A) != classes || len(c.B) != classes {
+			return fmt.Errorf("bp7: the Platt calibration has %d and %d parameters for %d classes", len(c.A), len(c.B), classes)
+		}
+	case IsotonicRegression:
+		if len(c.Thresholds) != classes || len(c.Values) != classes {
+			return fmt.Errorf("bp7: the isotonic calibration has %d thresholds and %d values for %d classes", len(c.Thresholds), len(c.Values), classes)
+		}
+
+		for k := 0; k < classes; k++ {
+			if len(c.Thresholds[k]) != len(c.Values[k]) {
+				return fmt.Errorf("bp7: the isotonic calibration of class %d has %d thresholds and %d values", k, len(c.Thresholds[k]), len(c.Values[k]))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns how many classes the parameters of the calibrator
+// cover, or -1 for the temperature, which covers any count.
+func (c *Calibrator) classes() int {
+	switch c.Method {
+	case PlattScaling:
+		return len(c.A)
+	case IsotonicRegression:
+		return len(c.Thresholds)
+	}
+
+	return -1
+}
+
+// Converts the calibrator into csv records. The first
+// record holds the method and the rest its parameters.
+func (c *Calibrator) records() [][]string {
+	records := [][]string{{"method", string(c.Method)}}
+
+	switch c.Method {
+	case PlattScaling:
+		records = append(records, append([]string{"a"}, formatFloats(c.A)...))
+		records = append(records, append([]string{"b"}, formatFloats(c.B)...))
+	case TemperatureScaling:
+		records = append(records, []string{"temperature", fmt.Sprintf("%g", c.Temperature)})
+	case IsotonicRegression:
+		for k := 0; k < len(c.Thresholds); k++ {
+			records = append(records, append([]string{"thresholds", strconv.Itoa(k)}, formatFloats(c.Thresholds[k])...))
+			records = append(records, append([]string{"values", strconv.Itoa(k)}, formatFloats(c.Values[k])...))
+		}
+	}
+
+	return records
+}
+
+// Restores the calibrator from its csv records.
+func (c *Calibrator) parseRecords(records [][]string) error {
+	for _, record := range records {
+		if len(record) < 2 {
+			return fmt.Errorf("bp7: invalid calibration record %v", record)
+		}
+
+		var err error
+
+		switch record[0] {
+		case "method":
+			c.Method = CalibrationMethod(record[1])
+		case "a":
+			c.A, err = parseFloats(record[1:])
+		case "b":
+			c.B, err = parseFloats(record[1:])
+		case "temperature":
+			var temperature []float32
+			temperature, err = parseFloats(record[1:2])
+			if err == nil {
+				c.Temperature = temperature[0]
+			}
+		case "thresholds", "values":
+			var values []float32
+			values, err = parseFloats(record[2:])
+			if err != nil {
+				break
+			}
+
+			// The classes are written in order, so the index of
+			// a record is the count of the ones before it.
+			index := strconv.Itoa(len(c.Thresholds))
+			if record[0] == "values" {
+				index = strconv.Itoa(len(c.Values))
+			}
+
+			if record[1] != index {
+				err = fmt.Errorf("bp7: the calibration record %s of class %s is out of order", record[0], record[1])
+			} else if record[0] == "thresholds" {
+				c.Thresholds = append(c.Thresholds, values)
+			} else {
+				c.Values = append(c.Values, values)
+			}
+		default:
+			err = fmt.Errorf("bp7: unknown calibration record %q", record[0])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	switch c.Method {
+	case PlattScaling, TemperatureScaling, IsotonicRegression:
+		return c.check(c.classes())
+	}
+
+	return fmt.Errorf("bp7: unknown calibration method %q", c.Method)
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Fits a calibrator for a network on a held-out set. The
+// last value of each row is the class index.
+// -Input n: A trained network.
+// -Input heldOut: Labelled rows which were not used for training.
+// -Input method: The calibration method.
+// -Output: The fitted calibrator.
+func FitCalibrator(n *Network, heldOut [][]float32, method CalibrationMethod) (*Calibrator, error) {
+	if len(heldOut) == 0 {
+		return nil, errors.New("bp7: the held-out set is empty")
+	}
+
+	outputs := make([][]float32, len(heldOut))
+
+	err := n.scoreBatch(heldOut, DefaultBatchConfig(), func(i int, o []float32) {
+		outputs[i] = o
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	classes := len(n.OutputLayer.Neurons)
+	labels := make([]int, len(heldOut))
+
+	for i := 0; i < len(heldOut); i++ {
+		labels[i] = int(heldOut[i][len(heldOut[i]) - 1])
+
+		if labels[i] < 0 || labels[i] >= classes {
+			return nil, fmt.Errorf("bp7: row %d has class %d but there are only %d classes", i, labels[i], classes)
+		}
+	}
+
+	calibrator := &Calibrator{Method: method}
+
+	switch method {
+	case PlattScaling:
+		calibrator.A = make([]float32, classes)
+		calibrator.B = make([]float32, classes)
+
+		for k := 0; k < classes; k++ {
+			scores, positives := classScores(outputs, labels, k)
+			calibrator.A[k], calibrator.B[k] = fitPlatt(scores, positives)
+		}
+	case TemperatureScaling:
+		calibrator.Temperature = fitTemperature(outputs, labels)
+	case IsotonicRegression:
+		calibrator.Thresholds = make([][]float32, classes)
+		calibrator.Values = make([][]float32, classes)
+
+		for k := 0; k < classes; k++ {
+			scores, positives := classScores(outputs, labels, k)
+			calibrator.Thresholds[k], calibrator.Values[k] = fitIsotonic(scores, positives)
+		}
+	default:
+		return nil, fmt.Errorf("bp7: unknown calibration method %q", method)
+	}
+
+	return calibrator, nil
+}
+
+// Fits a calibrator on a held-out set and attaches it to
+// the network.
+// -Input n: A trained network.
+// -Input heldOut: Labelled rows which were not used for training.
+// -Input method: The calibration method.
+func Calibrate(n *Network, heldOut [][]float32, method CalibrationMethod) error {
+	return n.Calibrate(heldOut, method)
+}
+
+// Groups the entries in equal width bins by their top
+// probability and compares the mean top probability of
+// each bin with the fraction of correct predictions.
+// -Input labels: The actual class index of each entry.
+// -Input probabilities: The probability vector of each entry.
+// -Input bins: How many bins the diagram has.
+// -Output: The bins of the reliability diagram.
+func ReliabilityCurve(labels []int, probabilities [][]float32, bins int) []ReliabilityBin {
+	curve := make([]ReliabilityBin, bins)
+
+	for b := 0; b < bins; b++ {
+		curve[b].Lower = float32(b) / float32(bins)
+		curve[b].Upper = float32(b + 1) / float32(bins)
+	}
+
+	for i := 0; i < len(labels) && i < len(probabilities); i++ {
+		predicted := argmax(probabilities[i])
+		confidence := probabilities[i][predicted]
+
+		b := int(confidence * float32(bins))
+		if b >= bins {
+			b = bins - 1
+		}
+
+		curve[b].Count++
+		curve[b].MeanConfidence += confidence
+
+		if predicted == labels[i] {
+			curve[b].Accuracy++
+		}
+	}
+
+	for b := 0; b < bins; b++ {
+		if curve[b].Count > 0 {
+			curve[b].MeanConfidence /= float32(curve[b].Count)
+			curve[b].Accuracy /= float32(curve[b].Count)
+		}
+	}
+
+	return curve
+}
+
+// Calculates the expected calibration error: the mean gap
+// between confidence and accuracy over the bins of the
+// reliability diagram, weighted by the entries of each bin.
+// -Input labels: The actual class index of each entry.
+// -Input probabilities: The probability vector of each entry.
+// -Input bins: How many bins are used.
+// -Output: The expected calibration error, in [0, 1].
+func ExpectedCalibrationError(labels []int, probabilities [][]float32, bins int) float32 {
+	curve := ReliabilityCurve(labels, probabilities, bins)
+
+	total := 0
+	var sum float64 = 0.0
+
+	for b := 0; b < len(curve); b++ {
+		total += curve[b].Count
+		sum += float64(curve[b].Count) * math.Abs(float64(curve[b].Accuracy - curve[b].MeanConfidence))
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return float32(sum / float64(total))
+}
+
+// Returns the output of a class for each entry and whether
+// each entry belongs to that class.
+func classScores(outputs [][]float32, labels []int, class int) ([]float32, []bool) {
+	scores := make([]float32, len(outputs))
+	positives := make([]bool, len(outputs))
+
+	for i := 0; i < len(outputs); i++ {
+		scores[i] = outputs[i][class]
+		positives[i] = labels[i] == class
+	}
+
+	return scores, positives
+}
+
+// Converts a sigmoid output back to the activation that
+// produced it.
+func logit(output float32) float64 {
+	p := math.Min(math.Max(float64(output), logitEpsilon), 1 - logitEpsilon)
+
+	return math.Log(p / (1 - p))
+}
+
+// The Platt scaling probability of a decision value.
+func plattProbability(f float64, a float64, b float64) float32 {
+	return float32(1 / (1 + math.Exp(a * f + b)))
+}
+
+// Fits the Platt scaling parameters with the Newton method
+// and backtracking line search of Lin, Lin and Weng (2007).
+// The targets are smoothed in order to avoid overfitting on
+// small held-out sets.
+// -Input scores: The sigmoid output of the class for each entry.
+// -Input positives: Whether each entry belongs to the class.
+// -Output: The A and B parameters.
+func fitPlatt(scores []float32, positives []bool) (float32, float32) {
+	const maxIterations = 100
+	const minStep = 1e-10
+	const sigma = 1e-12
+
+	var priorPositive, priorNegative float64
+
+	for i := 0; i < len(positives); i++ {
+		if positives[i] {
+			priorPositive++
+		} else {
+			priorNegative++
+		}
+	}
+
+	hiTarget := (priorPositive + 1) / (priorPositive + 2)
+	loTarget := 1 / (priorNegative + 2)
+
+	f := make([]float64, len(scores))
+	t := make([]float64, len(scores))
+
+	for i := 0; i < len(scores); i++ {
+		f[i] = logit(scores[i])
+
+		if positives[i] {
+			t[i] = hiTarget
+		} else {
+			t[i] = loTarget
+		}
+	}
+
+	objective := func(a float64, b float64) float64 {
+		var value float64 = 0.0
+
+		for i := 0; i < len(f); i++ {
+			fApB := f[i] * a + b
+			if fApB >= 0 {
+				value += t[i] * fApB + math.Log(1 + math.Exp(-fApB))
+			} else {
+				value += (t[i] - 1) * fApB + math.Log(1 + math.Exp(fApB))
+			}
+		}
+
+		return value
+	}
+
+	a := 0.0
+	b := math.Log((priorNegative + 1) / (priorPositive + 1))
+	value := objective(a, b)
+
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		h11, h22, h21, g1, g2 := sigma, sigma, 0.0, 0.0, 0.0
+
+		for i := 0; i < len(f); i++ {
+			fApB := f[i] * a + b
+
+			var p, q float64
+			if fApB >= 0 {
+				p = math.Exp(-fApB) / (1 + math.Exp(-fApB))
+				q = 1 / (1 + math.Exp(-fApB))
+			} else {
+				p = 1 / (1 + math.Exp(fApB))
+				q = math.Exp(fApB) / (1 + math.Exp(fApB))
+			}
+
+			d2 := p * q
+			h11 += f[i] * f[i] * d2
+			h22 += d2
+			h21 += f[i] * d2
+
+			d1 := t[i] - p
+			g1 += f[i] * d1
+			g2 += d1
+		}
+
+		if math.Abs(g1) < 1e-5 && math.Abs(g2) < 1e-5 {
+			break
+		}
+
+		det := h11 * h22 - h21 * h21
+		dA := -(h22 * g1 - h21 * g2) / det
+		dB := -(-h21 * g1 + h11 * g2) / det
+		gd := g1 * dA + g2 * dB
+
+		step := 1.0
+		for step >= minStep {
+			newA := a + step * dA
+			newB := b + step * dB
+			newValue := objective(newA, newB)
+
+			if newValue < value + 0.0001 * step * gd {
+				a, b, value = newA, newB, newValue
+				break
+			}
+
+			step /= 2
+		}
+
+		if step < minStep {
+			break
+		}
+	}
+
+	return float32(a), float32(b)
+}
+
+// Applies the softmax function on the logits of the outputs
+// divided by the temperature.
+func temperatureSoftmax(outputs []float32, temperature float64) []float32 {
+	logits := make([]float64, len(outputs))
+	highest := math.Inf(-1)
+
+	for k := 0; k < len(outputs); k++ {
+		logits[k] = logit(outputs[k]) / temperature
+		highest = math.Max(highest, logits[k])
+	}
+
+	var sum float64 = 0.0
+	for k := 0; k < len(logits); k++ {
+		logits[k] = math.Exp(logits[k] - highest)
+		sum += logits[k]
+	}
+
+	probabilities := make([]float32, len(outputs))
+	for k := 0; k < len(logits); k++ {
+		probabilities[k] = float32(logits[k] / sum)
+	}
+
+	return probabilities
+}
+
+// Fits the temperature which minimizes the log loss of the
+// held-out set with a golden section search over the
+// logarithm of the temperature.
+// -Input outputs: The output vector of each entry.
+// -Input labels: The actual class index of each entry.
+// -Output: The fitted temperature.
+func fitTemperature(outputs [][]float32, labels []int) float32 {
+	const iterations = 100
+
+	loss := func(logTemperature float64) float64 {
+		temperature := math.Exp(logTemperature)
+
+		var value float64 = 0.0
+		for i := 0; i < len(outputs); i++ {
+			p := temperatureSoftmax(outputs[i], temperature)[labels[i]]
+			value -= math.Log(math.Max(float64(p), logLossEpsilon))
+		}
+
+		return value
+	}
+
+	ratio := (math.Sqrt(5) - 1) / 2
+	low, high := math.Log(0.01), math.Log(100)
+
+	x1 := high - ratio * (high - low)
+	x2 := low + ratio * (high - low)
+	f1, f2 := loss(x1), loss(x2)
+
+	for i := 0; i < iterations; i++ {
+		if f1 < f2 {
+			high, x2, f2 = x2, x1, f1
+			x1 = high - ratio * (high - low)
+			f1 = loss(x1)
+		} else {
+			low, x1, f1 = x1, x2, f2
+			x2 = low + ratio * (high - low)
+			f2 = loss(x2)
+		}
+	}
+
+	return float32(math.Exp((low + high) / 2))
+}
+
+// Fits a non-decreasing function from the scores to the
+// fraction of positives with the pool adjacent violators
+// algorithm.
+// -Input scores: The sigmoid output of the class for each entry.
+// -Input positives: Whether each entry belongs to the class.
+// -Output: The points of the fitted piecewise linear function.
+func fitIsotonic(scores []float32, positives []bool) ([]float32, []float32) {
+	type block struct {
+		sum    float64
+		weight float64
+		low    float32
+		high   float32
+	}
+
+	indexes := make([]int, len(scores))
+	for i := 0; i < len(indexes); i++ {
+		indexes[i] = i
+	}
+
+	sort.Slice(indexes, func(a, b int) bool {
+		return scores[indexes[a]] < scores[indexes[b]]
+	})
+
+	blocks := make([]block, 0)
+
+	for start := 0; start < len(indexes); {
+		// The entries with the same score always form a single
+		// block, so that they get the same probability.
+		current := block{low: scores[indexes[start]], high: scores[indexes[start]]}
+
+		end := start
+		for end < len(indexes) && scores[indexes[end]] == current.low {
+			if positives[indexes[end]] {
+				current.sum++
+			}
+
+			current.weight++
+			end++
+		}
+
+		start = end
+
+		// Merges the previous blocks while their mean is not
+		// lower than the mean of the current block.
+		for len(blocks) > 0 {
+			previous := blocks[len(blocks) - 1]
+			if previous.sum / previous.weight < current.sum / current.weight {
+				break
+			}
+
+			current.sum += previous.sum
+			current.weight += previous.weight
+			current.low = previous.low
+			blocks = blocks[:len(blocks) - 1]
+		}
+
+		blocks = append(blocks, current)
+	}
+
+	thresholds := make([]float32, 0)
+	values := make([]float32, 0)
+
+	for _, b := range blocks {
+		value := float32(b.sum / b.weight)
+
+		thresholds = append(thresholds, b.low)
+		values = append(values, value)
+
+		if b.high != b.low {
+			thresholds = append(thresholds, b.high)
+			values = append(values, value)
+		}
+	}
+
+	return thresholds, values
+}
+
+// Evaluates a piecewise linear function at x. Outside of
+// the thresholds the function keeps its first or last value.
+// -Input thresholds: The ascending x values of the points.
+// -Input values: The y values of the points.
+// -Input x: Where to evaluate the function.
+// -Output: The value of the function.
+func interpolate(thresholds []float32, values []float32, x float32) float32 {
+	if len(thresholds) == 0 {
+		return x
+	}
+
+	if x <= thresholds[0] {
+		return values[0]
+	}
+
+	last := len(thresholds) - 1
+	if x >= thresholds[last] {
+		return values[last]
+	}
+
+	upper := sort.Search(len(thresholds), func(i int) bool {
+		return thresholds[i] >= x
+	})
+	lower := upper - 1
+
+	fraction := (x - thresholds[lower]) / (thresholds[upper] - thresholds[lower])
+
+	return values[lower] + (values[upper] - values[lower]) * fraction
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCalibratorParseRecords(t *testing.T) {
+	invalid := map[string][][]string{
+		"platt": {{"method", "platt"}, {"a", "1", "2", "3"}, {"b", "0", "0"}},
+		"isotonic": {
+			{"method", "isotonic"},
+			{"thresholds", "0", "0.1", "0.9"}, {"values", "0", "0.2"},
+		},
+		"order": {
+			{"method", "isotonic"},
+			{"thresholds", "1", "0.5"}, {"values", "1", "0.5"},
+		},
+	}
+
+	for name, records := range invalid {
+		if err := (&Calibrator{}).parseRecords(records); err == nil {
+			t.Errorf("parseRecords() accepted the %s records %v", name, records)
+		}
+	}
+
+	valid := [][]string{{"method", "platt"}, {"a", "1", "2"}, {"b", "0", "0"}}
+	if err := (&Calibrator{}).parseRecords(valid); err != nil {
+		t.Errorf("parseRecords(%v) = %v", valid, err)
+	}
+}
+
+func TestCalibratorApplyMismatch(t *testing.T) {
+	platt := &Calibrator{Method: PlattScaling, A: []float32{1, 1}, B: []float32{0, 0}}
+
+	if _, err := platt.Apply([]float32{0.2, 0.3, 0.5}); err == nil {
+		t.Error("Apply() accepted more outputs than the calibrated classes")
+	}
+
+	n := CreateNetwork(2, 3, 3)
+	n.Calibration = platt
+
+	if _, err := n.PredictProbabilities([]float32{1, 2}); err == nil {
+		t.Error("PredictProbabilities() accepted a calibrator of 2 classes for 3 outputs")
+	}
+
+	if _, err := n.PredictProbabilitiesBatch([][]float32{{1, 2}}); err == nil {
+		t.Error("PredictProbabilitiesBatch() accepted a calibrator of 2 classes for 3 outputs")
+	}
+
+	if _, err := n.PredictWithThreshold([]float32{1, 2}); err == nil {
+		t.Error("PredictWithThreshold() accepted a calibrator of 2 classes for 3 outputs")
+	}
+}
+
+func TestLoadMismatchedCalibration(t *testing.T) {
+	n := CreateNetwork(2, 3, 3)
+	n.Calibration = &Calibrator{Method: PlattScaling, A: []float32{1, 1}, B: []float32{0, 0}}
+
+	var model bytes.Buffer
+	if err := n.Save(&model); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Network{}).Load(&model); err == nil {
+		t.Error("Load() accepted a calibrator of 2 classes for 3 outputs")
+	}
+
+	model.Reset()
+	if err := n.SaveBinary(&model, BinaryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Network{}).LoadBinary(&model); err == nil {
+		t.Error("LoadBinary() accepted a calibrator of 2 classes for 3 outputs")
+	}
+}
@@ -0,0 +1,672 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// How the exported C code stores the layer weights.
+type CWeights string
+
+const (
+	// Stores each weight as a float.
+	CFloatWeights CWeights = "float"
+	// Stores each weight as an int8_t with a float scale per
+	// neuron, which takes a quarter of the memory. The weights
+	// are multiplied with the float inputs and the sum is
+	// scaled back, so only the weights lose precision. The
+	// weights are quantized from the float weights; a
+	// QuantizedNetwork keeps its own with GenerateC.
+	CInt8Weights CWeights = "int8"
+)
+
+// The options of the C export.
+type CExportOptions struct {
+	Weights CWeights
+}
+
+// Returns the options of the C export with float weights.
+func DefaultCExportOptions() CExportOptions {
+	return CExportOptions{Weights: CFloatWeights}
+}
+
+// The keywords of C99, which cannot name the symbols.
+var cKeywords = map[string]bool{
+	"auto": true, "break": true, "case": true, "char": true, "const": true,
+	"continue": true, "default": true, "do": true, "double": true, "else": true,
+	"enum": true, "extern": true, "float": true, "for": true, "goto": true,
+	"if": true, "inline": true, "int": true, "long": true, "register": true,
+	"restrict": true, "return": true, "short": true, "signed": true, "sizeof": true,
+	"static": true, "struct": true, "switch": true, "typedef": true, "union": true,
+	"unsigned": true, "void": true, "volatile": true, "while": true,
+	"_Bool": true, "_Complex": true, "_Imaginary": true,
+}
+
+// The C code of each activation, which applies it in place on
+// the values of a layer. It computes in double precision, like
+// the network.
+var cActivations = map[Activation]string{
+	SigmoidActivation: `static void apply_sigmoid(float *values, int count) {
+    int i;
+    for (i = 0; i < count; i++) {
+        values[i] = (float) (1.0 / (1.0 + exp(-(double) values[i])));
+    }
+}`,
+	ReLUActivation: `static void apply_relu(float *values, int count) {
+    int i;
+    for (i = 0; i < count; i++) {
+        if (values[i] < 0.0f) {
+            values[i] = 0.0f;
+        }
+    }
+}`,
+	TanhActivation: `static void apply_tanh(float *values, int count) {
+    int i;
+    for (i = 0; i < count; i++) {
+        values[i] = (float) tanh((double) values[i]);
+    }
+}`,
+	SoftmaxActivation: `static void apply_softmax(float *values, int count) {
+    int i;
+    float max = values[0];
+    double total = 0.0;
+    for (i = 1; i < count; i++) {
+        if (values[i] > max) {
+            max = values[i];
+        }
+    }
+    for (i = 0; i < count; i++) {
+        values[i] = (float) exp((double) (values[i] - max));
+        total += values[i];
+    }
+    for (i = 0; i < count; i++) {
+        values[i] = (float) (values[i] / total);
+    }
+}`,
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Generates a C99 header and source file which embed the
+// network, for microcontrollers and other targets without Go.
+// The weights are static const arrays, so they stay in the
+// flash memory, and the inference does not allocate memory.
+// The header declares <name>_outputs, which writes the output
+// of each output neuron, and <name>_predict, which returns the
+// index of the largest output, like Network.Predict. The
+// imputer and the scaler are embedded too, except the k-NN
+// imputer. The source only needs math.h and stdint.h.
+// -Input name: The prefix of the files and of the C symbols.
+// -Input options: How the weights are stored.
+// -Output: The header and the source.
+func (n *Network) GenerateC(name string, options CExportOptions) ([]byte, []byte, error) {
+	if len(n.HiddenLayer.Neurons) == 0 || len(n.OutputLayer.Neurons) == 0 {
+		return nil, nil, ErrUninitializedNetwork
+	}
+
+	if options.Weights != CFloatWeights && options.Weights != CInt8Weights {
+		return nil, nil, fmt.Errorf("bp7: unknown C weights %q", options.Weights)
+	}
+
+	if err := checkCExport(name, n.Imputer); err != nil {
+		return nil, nil, err
+	}
+
+	inputs := n.inputCount()
+	layers := []struct {
+		name       string
+		neurons    []Neuron
+		activation Activation
+	}{
+		{"hidden", n.HiddenLayer.Neurons, n.HiddenLayer.Activation.name()},
+		{"output", n.OutputLayer.Neurons, n.OutputLayer.Activation.name()},
+	}
+
+	var source bytes.Buffer
+
+	if err := writeCComponents(&source, name, n.Labels, n.Imputer, n.Scaler); err != nil {
+		return nil, nil, err
+	}
+
+	for _, layer := range layers {
+		if err := layer.activation.check(); err != nil {
+			return nil, nil, err
+		}
+
+		size := len(layer.neurons[0].Weights) - 1
+		biases := make([]float32, len(layer.neurons))
+		scales := make([]float32, len(layer.neurons))
+		rows := make([]string, len(layer.neurons))
+
+		for i, neuron := range layer.neurons {
+			biases[i] = neuron.Weights[size]
+
+			if options.Weights == CInt8Weights {
+				quantized, scale := quantizeSymmetric(neuron.Weights[:size])
+				scales[i] = scale
+				rows[i] = "{" + cInts(quantized) + "}"
+			} else {
+				values, err := cFloats(neuron.Weights[:size])
+				if err != nil {
+					return nil, nil, err
+				}
+
+				rows[i] = "{" + values + "}"
+			}
+		}
+
+		weightType := "float"
+		if options.Weights == CInt8Weights {
+			weightType = "int8_t"
+		}
+
+		fmt.Fprintf(&source, "static const %s %s_weights[%d][%d] = {\n    %s\n};\n\n", weightType, layer.name, len(rows), size, strings.Join(rows, ",\n    "))
+
+		if err := writeCArray(&source, layer.name + "_bias", biases); err != nil {
+			return nil, nil, err
+		}
+
+		if options.Weights == CInt8Weights {
+			if err := writeCArray(&source, layer.name + "_scale", scales); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	writeCActivations(&source, layers[0].activation, layers[1].activation)
+
+	fmt.Fprintf(&source, "void %s_outputs(const float *features, float *outputs) {\n", name)
+	fmt.Fprintf(&source, "    float x[%d];\n    float hidden[%d];\n    int i, j;\n\n", inputs, len(n.HiddenLayer.Neurons))
+
+	writeCInputs(&source, inputs, n.Imputer, n.Scaler)
+
+	input := "x"
+	for _, layer := range layers {
+		output := layer.name
+		if layer.name == "output" {
+			output = "outputs"
+		}
+
+		size := len(layer.neurons[0].Weights) - 1
+
+		fmt.Fprintf(&source, "    for (i = 0; i < %d; i++) {\n", len(layer.neurons))
+
+		if options.Weights == CInt8Weights {
+			fmt.Fprintf(&source, "        float sum = 0.0f;\n        for (j = 0; j < %d; j++) {\n", size)
+			fmt.Fprintf(&source, "            sum += (float) %s_weights[i][j] * %s[j];\n        }\n", layer.name, input)
+			fmt.Fprintf(&source, "        %s[i] = %s_bias[i] + %s_scale[i] * sum;\n    }\n", output, layer.name, layer.name)
+		} else {
+			fmt.Fprintf(&source, "        float sum = %s_bias[i];\n        for (j = 0; j < %d; j++) {\n", layer.name, size)
+			fmt.Fprintf(&source, "            sum += %s_weights[i][j] * %s[j];\n        }\n", layer.name, input)
+			fmt.Fprintf(&source, "        %s[i] = sum;\n    }\n", output)
+		}
+
+		if _, ok := cActivations[layer.activation]; ok {
+			fmt.Fprintf(&source, "    apply_%s(%s, %d);\n", layer.activation, output, len(layer.neurons))
+		}
+
+		if layer.name == "hidden" {
+			fmt.Fprintf(&source, "\n")
+		}
+
+		input = output
+	}
+
+	fmt.Fprintf(&source, "}\n\n")
+
+	writeCPredict(&source, name, len(n.OutputLayer.Neurons))
+
+	return cHeader(name, inputs, len(n.OutputLayer.Neurons), n.Labels), source.Bytes(), nil
+}
+
+// Writes the C header and source file of the network into a
+// directory, as <name>.h and <name>.c.
+// -Input directory: The directory of the files.
+// -Input name: The prefix of the files and of the C symbols.
+// -Input options: How the weights are stored.
+func (n *Network) ExportC(directory string, name string, options CExportOptions) error {
+	header, source, err := n.GenerateC(name, options)
+	if err != nil {
+		return err
+	}
+
+	return writeCFiles(directory, name, header, source)
+}
+
+// Generates a C99 header and source file which embed the
+// quantized network, with the same functions as the ones of
+// Network.GenerateC. The int8 weights, the weight scales, the
+// int32 biases and the input scales and zero points are
+// embedded unchanged, and the inference quantizes the inputs
+// of each layer and accumulates in int32, like Outputs, so
+// the C code predicts what the quantized network predicts.
+// The source needs math.h and stdint.h.
+// -Input name: The prefix of the files and of the C symbols.
+// -Output: The header and the source.
+func (q *QuantizedNetwork) GenerateC(name string) ([]byte, []byte, error) {
+	if err := q.check(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkCExport(name, q.Imputer); err != nil {
+		return nil, nil, err
+	}
+
+	inputs := len(q.HiddenLayer.Weights[0])
+	layers := []struct {
+		name  string
+		layer *QuantizedLayer
+	}{
+		{"hidden", &q.HiddenLayer},
+		{"output", &q.OutputLayer},
+	}
+
+	buffer := inputs
+	if len(q.HiddenLayer.Weights) > buffer {
+		buffer = len(q.HiddenLayer.Weights)
+	}
+
+	var source bytes.Buffer
+
+	if err := writeCComponents(&source, name, q.Labels, q.Imputer, q.Scaler); err != nil {
+		return nil, nil, err
+	}
+
+	for _, l := range layers {
+		if err := l.layer.Activation.name().check(); err != nil {
+			return nil, nil, err
+		}
+
+		rows := make([]string, len(l.layer.Weights))
+		for i, weights := range l.layer.Weights {
+			rows[i] = "{" + cInts(weights) + "}"
+		}
+
+		biases := make([]string, len(l.layer.Bias))
+		for i, bias := range l.layer.Bias {
+			biases[i] = strconv.FormatInt(int64(bias), 10)
+		}
+
+		fmt.Fprintf(&source, "static const int8_t %s_weights[%d][%d] = {\n    %s\n};\n\n", l.name, len(rows), len(l.layer.Weights[0]), strings.Join(rows, ",\n    "))
+		fmt.Fprintf(&source, "static const int32_t %s_bias[%d] = {%s};\n\n", l.name, len(biases), strings.Join(biases, ", "))
+
+		if err := writeCArray(&source, l.name + "_scale", l.layer.Scales); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	fmt.Fprintf(&source, `static int32_t quantize_input(float value, float scale, int32_t zero_point) {
+    float quantized = rintf(value / scale) + (float) zero_point;
+    if (quantized < -128.0f) {
+        return -128 - zero_point;
+    }
+    if (quantized > 127.0f) {
+        return 127 - zero_point;
+    }
+    return (int32_t) quantized - zero_point;
+}
+
+`)
+
+	writeCActivations(&source, q.HiddenLayer.Activation.name(), q.OutputLayer.Activation.name())
+
+	fmt.Fprintf(&source, "void %s_outputs(const float *features, float *outputs) {\n", name)
+	fmt.Fprintf(&source, "    float x[%d];\n    float hidden[%d];\n    int32_t quantized[%d];\n    int i, j;\n\n", inputs, len(q.HiddenLayer.Weights), buffer)
+
+	writeCInputs(&source, inputs, q.Imputer, q.Scaler)
+
+	input := "x"
+	for _, l := range layers {
+		output := l.name
+		if l.name == "output" {
+			output = "outputs"
+		}
+
+		size := len(l.layer.Weights[0])
+		scale, err := cFloats([]float32{l.layer.InputScale})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fmt.Fprintf(&source, "    for (j = 0; j < %d; j++) {\n", size)
+		fmt.Fprintf(&source, "        quantized[j] = quantize_input(%s[j], %s, %d);\n    }\n", input, scale, l.layer.InputZeroPoint)
+		fmt.Fprintf(&source, "    for (i = 0; i < %d; i++) {\n", len(l.layer.Weights))
+		fmt.Fprintf(&source, "        int32_t sum = %s_bias[i];\n        for (j = 0; j < %d; j++) {\n", l.name, size)
+		fmt.Fprintf(&source, "            sum += (int32_t) %s_weights[i][j] * quantized[j];\n        }\n", l.name)
+		fmt.Fprintf(&source, "        %s[i] = (float) sum * %s * %s_scale[i];\n    }\n", output, scale, l.name)
+
+		if _, ok := cActivations[l.layer.Activation.name()]; ok {
+			fmt.Fprintf(&source, "    apply_%s(%s, %d);\n", l.layer.Activation.name(), output, len(l.layer.Weights))
+		}
+
+		if l.name == "hidden" {
+			fmt.Fprintf(&source, "\n")
+		}
+
+		input = output
+	}
+
+	fmt.Fprintf(&source, "}\n\n")
+
+	writeCPredict(&source, name, len(q.OutputLayer.Weights))
+
+	return cHeader(name, inputs, len(q.OutputLayer.Weights), q.Labels), source.Bytes(), nil
+}
+
+// Writes the C header and source file of the quantized
+// network into a directory, as <name>.h and <name>.c.
+// -Input directory: The directory of the files.
+// -Input name: The prefix of the files and of the C symbols.
+func (q *QuantizedNetwork) ExportC(directory string, name string) error {
+	header, source, err := q.GenerateC(name)
+	if err != nil {
+		return err
+	}
+
+	return writeCFiles(directory, name, header, source)
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Generates a C99 header and source file which embed a
+// network.
+// -Input n: A network.
+// -Input name: The prefix of the files and of the C symbols.
+// -Input options: How the weights are stored.
+// -Output: The header and the source.
+func GenerateC(n *Network, name string, options CExportOptions) ([]byte, []byte, error) {
+	return n.GenerateC(name, options)
+}
+
+// Writes the C header and source file of a network into a
+// directory.
+// -Input n: A network.
+// -Input directory: The directory of the files.
+// -Input name: The prefix of the files and of the C symbols.
+// -Input options: How the weights are stored.
+func ExportC(n *Network, directory string, name string, options CExportOptions) error {
+	return n.ExportC(directory, name, options)
+}
+
+// Returns whether a name is a C identifier, [A-Za-z_][A-Za-z0-9_]*,
+// which is not a keyword of C99.
+func isCIdentifier(name string) bool {
+	if name == "" || cKeywords[name] {
+		return false
+	}
+
+	for i, c := range name {
+		letter := c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks that a name can prefix the C symbols and that the
+// imputer can be embedded in C.
+// -Input name: The prefix of the files and of the C symbols.
+// -Input imputer: The imputer of the network, or nil.
+func checkCExport(name string, imputer *Imputer) error {
+	if !isCIdentifier(name) {
+		return fmt.Errorf("bp7: %q is not a valid C identifier", name)
+	}
+
+	if imputer != nil && imputer.Strategy == ImputeKNN {
+		return errors.New("bp7: the k-NN imputer cannot be embedded in generated code")
+	}
+
+	return nil
+}
+
+// Returns the C header of an exported network, which declares
+// the class labels and the <name>_outputs and <name>_predict
+// functions.
+// -Input name: The prefix of the C symbols.
+// -Input inputs: How many input features the network has.
+// -Input classes: How many output classes the network has.
+// -Input labels: The class labels, or nil.
+func cHeader(name string, inputs int, classes int, labels *LabelEncoder) []byte {
+	macro := strings.ToUpper(name)
+
+	var header bytes.Buffer
+
+	fmt.Fprintf(&header, "/* Code generated by bp7; DO NOT EDIT. */\n\n")
+	fmt.Fprintf(&header, "#ifndef %s_H\n#define %s_H\n\n", macro, macro)
+	fmt.Fprintf(&header, "#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+	fmt.Fprintf(&header, "/* The number of input features and of output classes. */\n")
+	fmt.Fprintf(&header, "#define %s_INPUTS %d\n#define %s_CLASSES %d\n\n", macro, inputs, macro, classes)
+
+	if labels != nil {
+		fmt.Fprintf(&header, "/* The label of each class. */\nextern const char *const %s_labels[%d];\n\n", name, len(labels.Labels))
+	}
+
+	fmt.Fprintf(&header, "/* Writes the output of each output neuron into outputs, which\n")
+	fmt.Fprintf(&header, "   has %s_CLASSES values. Missing features are NAN. */\n", macro)
+	fmt.Fprintf(&header, "void %s_outputs(const float *features, float *outputs);\n\n", name)
+	fmt.Fprintf(&header, "/* Returns the index of the class with the largest output. */\n")
+	fmt.Fprintf(&header, "int %s_predict(const float *features);\n\n", name)
+	fmt.Fprintf(&header, "#ifdef __cplusplus\n}\n#endif\n\n#endif\n")
+
+	return header.Bytes()
+}
+
+// Writes the start of the C source: the includes, the class
+// labels and the arrays of the imputer and of the scaler.
+// -Input source: The C source.
+// -Input name: The prefix of the C symbols.
+// -Input labels: The class labels, or nil.
+// -Input imputer: The imputer, or nil.
+// -Input scaler: The scaler, or nil.
+func writeCComponents(source *bytes.Buffer, name string, labels *LabelEncoder, imputer *Imputer, scaler *Scaler) error {
+	fmt.Fprintf(source, "/* Code generated by bp7; DO NOT EDIT. */\n\n")
+	fmt.Fprintf(source, "#include <math.h>\n#include <stdint.h>\n\n#include \"%s.h\"\n\n", name)
+
+	if labels != nil {
+		quoted := make([]string, len(labels.Labels))
+		for i, label := range labels.Labels {
+			quoted[i] = cString(label)
+		}
+
+		fmt.Fprintf(source, "const char *const %s_labels[%d] = {%s};\n\n", name, len(quoted), strings.Join(quoted, ", "))
+	}
+
+	if imputer != nil {
+		if err := writeCArray(source, "imputer_fill", imputer.Fill); err != nil {
+			return err
+		}
+	}
+
+	if scaler != nil && scaler.Method != LogScaling {
+		if err := writeCArray(source, "scaler_offset", scaler.Offset); err != nil {
+			return err
+		}
+
+		if err := writeCArray(source, "scaler_scale", scaler.Scale); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writes the C functions of the activations of the layers,
+// each one once.
+// -Input source: The C source.
+// -Input activations: The activation of each layer.
+func writeCActivations(source *bytes.Buffer, activations ...Activation) {
+	written := make(map[Activation]bool)
+
+	for _, activation := range activations {
+		if function, ok := cActivations[activation]; ok && !written[activation] {
+			fmt.Fprintf(source, "%s\n\n", function)
+			written[activation] = true
+		}
+	}
+}
+
+// Writes the loop which copies the features into x and
+// imputes and scales them.
+// -Input source: The C source.
+// -Input inputs: How many input features the network has.
+// -Input imputer: The imputer, or nil.
+// -Input scaler: The scaler, or nil.
+func writeCInputs(source *bytes.Buffer, inputs int, imputer *Imputer, scaler *Scaler) {
+	fmt.Fprintf(source, "    for (i = 0; i < %d; i++) {\n        x[i] = features[i];\n", inputs)
+
+	if imputer != nil {
+		fmt.Fprintf(source, "        if (i < %d && x[i] != x[i]) {\n            x[i] = imputer_fill[i];\n        }\n", len(imputer.Fill))
+	}
+
+	if scaler != nil {
+		if scaler.Method == LogScaling {
+			fmt.Fprintf(source, "        x[i] = (float) copysign(log1p(fabs((double) x[i])), (double) x[i]);\n")
+		} else {
+			fmt.Fprintf(source, "        if (i < %d) {\n            x[i] = (x[i] - scaler_offset[i]) / scaler_scale[i];\n        }\n", len(scaler.Offset))
+		}
+	}
+
+	fmt.Fprintf(source, "    }\n\n")
+}
+
+// Writes the <name>_predict function, which returns the index
+// of the largest output.
+// -Input source: The C source.
+// -Input name: The prefix of the C symbols.
+// -Input classes: How many output classes the network has.
+func writeCPredict(source *bytes.Buffer, name string, classes int) {
+	fmt.Fprintf(source, "int %s_predict(const float *features) {\n", name)
+	fmt.Fprintf(source, "    float outputs[%d];\n    int i, best = 0;\n\n", classes)
+	fmt.Fprintf(source, "    %s_outputs(features, outputs);\n", name)
+	fmt.Fprintf(source, "    for (i = 1; i < %d; i++) {\n        if (outputs[i] > outputs[best]) {\n            best = i;\n        }\n    }\n\n", classes)
+	fmt.Fprintf(source, "    return best;\n}\n")
+}
+
+// Writes a C header and source file into a directory, as
+// <name>.h and <name>.c.
+func writeCFiles(directory string, name string, header []byte, source []byte) error {
+	if err := ioutil.WriteFile(filepath.Join(directory, name + ".h"), header, 0644); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(directory, name + ".c"), source, 0644)
+}
+
+// Quantizes values symmetrically into int8, with the scale
+// which maps the largest absolute value to 127.
+// -Input values: The values to quantize.
+// -Output: The quantized values and their scale.
+func quantizeSymmetric(values []float32) ([]int8, float32) {
+	var max float32
+	for _, value := range values {
+		if abs := float32(math.Abs(float64(value))); abs > max {
+			max = abs
+		}
+	}
+
+	scale := max / 127
+	if scale == 0 {
+		scale = 1
+	}
+
+	quantized := make([]int8, len(values))
+	for i, value := range values {
+		quantized[i] = int8(math.Round(float64(value / scale)))
+	}
+
+	return quantized, scale
+}
+
+// Writes a float vector as a static const C array.
+func writeCArray(source *bytes.Buffer, name string, values []float32) error {
+	literals, err := cFloats(values)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(source, "static const float %s[%d] = {%s};\n\n", name, len(values), literals)
+
+	return nil
+}
+
+// Formats int8 values as C integer literals.
+func cInts(values []int8) string {
+	literals := make([]string, len(values))
+
+	for i, value := range values {
+		literals[i] = strconv.Itoa(int(value))
+	}
+
+	return strings.Join(literals, ", ")
+}
+
+// Formats floats as C float literals, which convert back into
+// the same values.
+func cFloats(values []float32) (string, error) {
+	literals := make([]string, len(values))
+
+	for i, value := range values {
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			return "", fmt.Errorf("bp7: the value %v cannot be embedded in generated code", value)
+		}
+
+		literal := strconv.FormatFloat(float64(value), 'g', -1, 32)
+		if !strings.ContainsAny(literal, ".e") {
+			literal += ".0"
+		}
+
+		literals[i] = literal + "f"
+	}
+
+	return strings.Join(literals, ", "), nil
+}
+
+// Quotes a string as a C string literal.
+func cString(value string) string {
+	var quoted strings.Builder
+
+	quoted.WriteByte('"')
+
+	for _, b := range []byte(value) {
+		switch {
+		case b == '"' || b == '\\':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(b)
+		case b < 0x20 || b >= 0x7f:
+			fmt.Fprintf(&quoted, "\\%03o", b)
+		default:
+			quoted.WriteByte(b)
+		}
+	}
+
+	quoted.WriteByte('"')
+
+	return quoted.String()
+}
@@ -0,0 +1,239 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Formats rows as a C array initializer, with the missing
+// values as NAN.
+func cRows(rows [][]float32) string {
+	var literal bytes.Buffer
+
+	for _, row := range rows {
+		literal.WriteString("    {")
+
+		for i, value := range row {
+			if i > 0 {
+				literal.WriteString(", ")
+			}
+
+			if isMissing(value) {
+				literal.WriteString("NAN")
+			} else {
+				literal.WriteString(strconv.FormatFloat(float64(value), 'e', -1, 32) + "f")
+			}
+		}
+
+		literal.WriteString("},\n")
+	}
+
+	return literal.String()
+}
+
+// Returns a copy of a network whose weights are rounded to the
+// int8 steps of the C export, so that it computes what the int8
+// C code computes.
+func dequantizedNetwork(n *Network) *Network {
+	rounded := *n
+
+	layers := []*[]Neuron{&rounded.HiddenLayer.Neurons, &rounded.OutputLayer.Neurons}
+	for _, neurons := range layers {
+		copied := make([]Neuron, len(*neurons))
+
+		for i, neuron := range *neurons {
+			size := len(neuron.Weights) - 1
+			quantized, scale := quantizeSymmetric(neuron.Weights[:size])
+
+			copied[i].Weights = make([]float32, size + 1)
+			for j, value := range quantized {
+				copied[i].Weights[j] = float32(value) * scale
+			}
+
+			copied[i].Weights[size] = neuron.Weights[size]
+		}
+
+		*neurons = copied
+	}
+
+	return &rounded
+}
+
+// Compiles the exported model "model" of a directory with a
+// harness which prints the predicted class and the outputs of
+// each row, and returns what it prints.
+func runCHarness(t *testing.T, compiler string, dir string, rows [][]float32) string {
+	harness := fmt.Sprintf(`#include <math.h>
+#include <stdio.h>
+
+#include "model.h"
+
+static const float rows[%d][MODEL_INPUTS] = {
+%s};
+
+int main(void) {
+    float outputs[MODEL_CLASSES];
+    int i, j;
+
+    for (i = 0; i < %d; i++) {
+        printf("%%d", model_predict(rows[i]));
+        model_outputs(rows[i], outputs);
+        for (j = 0; j < MODEL_CLASSES; j++) {
+            printf(" %%.9g", outputs[j]);
+        }
+        printf("\n");
+    }
+
+    return 0;
+}
+`, len(rows), cRows(rows), len(rows))
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.c"), []byte(harness), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	program := filepath.Join(dir, "model")
+
+	compile := exec.Command(compiler, "-std=c99", "-Wall", "-Wextra", "-Werror", "-o", program, "main.c", "model.c", "-lm")
+	compile.Dir = dir
+
+	if output, err := compile.CombinedOutput(); err != nil {
+		t.Fatalf("cc: %v\n%s", err, output)
+	}
+
+	output, err := exec.Command(program).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", program, err, output)
+	}
+
+	return string(output)
+}
+
+func TestGenerateCPredictions(t *testing.T) {
+	compiler, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("there is no C compiler")
+	}
+
+	n, rows := codegenTestNetwork(t)
+
+	for _, weights := range []CWeights{CFloatWeights, CInt8Weights} {
+		t.Run(string(weights), func(t *testing.T) {
+			dir := t.TempDir()
+
+			if err := n.ExportC(dir, "model", CExportOptions{Weights: weights}); err != nil {
+				t.Fatal(err)
+			}
+
+			output := runCHarness(t, compiler, dir, rows)
+
+			reference := n
+			if weights == CInt8Weights {
+				reference = dequantizedNetwork(n)
+			}
+
+			classes, outputs := parsePredictions(t, output, len(rows))
+			checkPredictions(t, reference, rows, classes, outputs)
+		})
+	}
+}
+
+func TestGenerateCQuantized(t *testing.T) {
+	compiler, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("there is no C compiler")
+	}
+
+	n, rows := codegenTestNetwork(t)
+
+	calibrated, err := n.Quantize(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The quantization-aware training observes other ranges than
+	// the calibration, which the C code must keep.
+	if err := n.EnableQuantizationTraining(QuantizationConfig{Granularity: PerLayerQuantization}); err != nil {
+		t.Fatal(err)
+	}
+
+	training := make([][]float32, len(rows))
+	for i, row := range rows {
+		training[i] = append(append([]float32(nil), row...), float32(i % 3))
+	}
+
+	n.Train(training, 0.1, 3, 3)
+
+	aware, err := n.QuantizeTrained()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, q := range map[string]*QuantizedNetwork{"calibrated": calibrated, "aware": aware} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			if err := q.ExportC(dir, "model"); err != nil {
+				t.Fatal(err)
+			}
+
+			classes, outputs := parsePredictions(t, runCHarness(t, compiler, dir, rows), len(rows))
+
+			for i, row := range rows {
+				if want := q.Predict(row); classes[i] != want {
+					t.Errorf("row %d: Predict() = %d, want %d", i, classes[i], want)
+				}
+
+				want := q.Outputs(row)
+				for j := range want {
+					if math.Abs(float64(outputs[i][j] - want[j])) > 1e-6 {
+						t.Errorf("row %d: the outputs %v, want %v", i, outputs[i], want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateCQuantizedUninitialized(t *testing.T) {
+	if _, _, err := (&QuantizedNetwork{}).GenerateC("model"); err != ErrUninitializedNetwork {
+		t.Errorf("GenerateC() = %v, want ErrUninitializedNetwork", err)
+	}
+}
+
+func TestGenerateCNames(t *testing.T) {
+	n := CreateNetwork(2, 2, 2)
+
+	for _, name := range []string{"model", "_m", "net_2", "Int"} {
+		if _, _, err := n.GenerateC(name, DefaultCExportOptions()); err != nil {
+			t.Errorf("GenerateC(%q) = %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", "int", "static", "double", "_Bool", "naïve", "2net", "my-net"} {
+		if _, _, err := n.GenerateC(name, DefaultCExportOptions()); err == nil {
+			t.Errorf("GenerateC(%q) accepted an invalid C identifier", name)
+		}
+	}
+}
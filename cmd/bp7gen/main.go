@@ -0,0 +1,88 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command bp7gen generates a Go source file which embeds a saved
+// bp7 model, with a dependency-free Predict function. It reads the
+// model files of Save, SaveJSON, SaveBinary and SaveONNX. It is
+// meant for go:generate, which provides the package name:
+//
+//	//go:generate go run 7linternational.com/bp7/cmd/bp7gen -model model.bp7 -o model_gen.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	nn "7linternational.com/bp7"
+)
+
+func main() {
+	modelPath := flag.String("model", "", "the path of the saved model")
+	packageName := flag.String("package", os.Getenv("GOPACKAGE"), "the package of the generated file, by default the package of go:generate")
+	output := flag.String("o", "model_gen.go", "the path of the generated file")
+	flag.Parse()
+
+	if *modelPath == "" || *packageName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	network, err := load(*modelPath)
+	if err != nil {
+		fail(err)
+	}
+
+	code, err := network.GenerateGo(*packageName)
+	if err != nil {
+		fail(err)
+	}
+
+	if err := ioutil.WriteFile(*output, code, 0644); err != nil {
+		fail(err)
+	}
+}
+
+// Loads a model in any of the formats of bp7, which is
+// recognized by its first bytes or its extension.
+func load(path string) (*nn.Network, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	network := &nn.Network{}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("BP7B")):
+		err = network.LoadBinaryBytes(data)
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		err = network.LoadJSON(bytes.NewReader(data))
+	case strings.EqualFold(filepath.Ext(path), ".onnx"):
+		err = network.LoadONNX(bytes.NewReader(data))
+	default:
+		err = network.Load(bytes.NewReader(data))
+	}
+
+	return network, err
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "bp7gen:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,275 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/token"
+	"math"
+	"strconv"
+)
+
+// The Go code of each activation, which applies it in place
+// on the values of a layer.
+var goActivations = map[Activation]string{
+	SigmoidActivation: `func applySigmoid(values []float32) {
+	for i := range values {
+		values[i] = float32(1.0 / (1.0 + math.Exp(-1 * float64(values[i]))))
+	}
+}`,
+	ReLUActivation: `func applyReLU(values []float32) {
+	for i := range values {
+		if values[i] < 0 {
+			values[i] = 0
+		}
+	}
+}`,
+	TanhActivation: `func applyTanh(values []float32) {
+	for i := range values {
+		values[i] = float32(math.Tanh(float64(values[i])))
+	}
+}`,
+	SoftmaxActivation: `func applySoftmax(values []float32) {
+	max := float32(math.Inf(-1))
+	for _, value := range values {
+		if value > max {
+			max = value
+		}
+	}
+
+	var total float64
+	for i := range values {
+		values[i] = float32(math.Exp(float64(values[i] - max)))
+		total += float64(values[i])
+	}
+
+	for i := range values {
+		values[i] = float32(float64(values[i]) / total)
+	}
+}`,
+}
+
+// The name of the generated function of each activation.
+var goActivationFunctions = map[Activation]string{
+	SigmoidActivation: "applySigmoid",
+	ReLUActivation:    "applyReLU",
+	TanhActivation:    "applyTanh",
+	SoftmaxActivation: "applySoftmax",
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Generates a gofmt-formatted Go source file which embeds the
+// network, so that a service can predict without bp7 and
+// without loading a model file. The file declares the weights
+// as arrays, the Inputs and Classes constants, an Outputs
+// function which returns the output vector of the network and
+// a Predict function which returns the index of the largest
+// output, like Network.Predict. The imputer and the scaler are
+// embedded too, except the k-NN imputer, which needs the
+// training entries. The class labels, if any, become the
+// Labels array. Only the math package is imported.
+// -Input packageName: The package of the generated file.
+// -Output: The source of the file.
+func (n *Network) GenerateGo(packageName string) ([]byte, error) {
+	if len(n.HiddenLayer.Neurons) == 0 || len(n.OutputLayer.Neurons) == 0 {
+		return nil, ErrUninitializedNetwork
+	}
+
+	if !token.IsIdentifier(packageName) {
+		return nil, fmt.Errorf("bp7: %q is not a valid package name", packageName)
+	}
+
+	if n.Imputer != nil && n.Imputer.Strategy == ImputeKNN {
+		return nil, errors.New("bp7: the k-NN imputer cannot be embedded in generated code")
+	}
+
+	inputs := n.inputCount()
+	layers := []struct {
+		name       string
+		neurons    []Neuron
+		activation Activation
+	}{
+		{"hidden", n.HiddenLayer.Neurons, n.HiddenLayer.Activation.name()},
+		{"output", n.OutputLayer.Neurons, n.OutputLayer.Activation.name()},
+	}
+
+	var code bytes.Buffer
+
+	fmt.Fprintf(&code, "// Code generated by bp7; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&code, "// Package %s embeds a trained bp7 network.\n", packageName)
+	fmt.Fprintf(&code, "package %s\n\n", packageName)
+
+	usesMath := n.Scaler != nil && n.Scaler.Method == LogScaling
+	for _, layer := range layers {
+		if err := layer.activation.check(); err != nil {
+			return nil, err
+		}
+
+		if layer.activation != ReLUActivation && layer.activation != IdentityActivation {
+			usesMath = true
+		}
+	}
+
+	if usesMath {
+		fmt.Fprintf(&code, "import \"math\"\n\n")
+	}
+
+	fmt.Fprintf(&code, "// The number of input features and of output classes.\n")
+	fmt.Fprintf(&code, "const (\n\tInputs = %d\n\tClasses = %d\n)\n\n", inputs, len(n.OutputLayer.Neurons))
+
+	if n.Labels != nil {
+		fmt.Fprintf(&code, "// The label of each class.\nvar Labels = [...]string{")
+		for _, label := range n.Labels.Labels {
+			fmt.Fprintf(&code, "%s, ", strconv.Quote(label))
+		}
+		fmt.Fprintf(&code, "}\n\n")
+	}
+
+	if n.Imputer != nil {
+		if err := writeGoArray(&code, "The fill value of each missing input.", "imputerFill", n.Imputer.Fill); err != nil {
+			return nil, err
+		}
+	}
+
+	if n.Scaler != nil && n.Scaler.Method != LogScaling {
+		if err := writeGoArray(&code, "The offset of each input.", "scalerOffset", n.Scaler.Offset); err != nil {
+			return nil, err
+		}
+
+		if err := writeGoArray(&code, "The scale of each input.", "scalerScale", n.Scaler.Scale); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, layer := range layers {
+		fmt.Fprintf(&code, "// The weights of each %s neuron, with the bias last.\n", layer.name)
+		fmt.Fprintf(&code, "var %sWeights = [%d][%d]float32{\n", layer.name, len(layer.neurons), len(layer.neurons[0].Weights))
+
+		for _, neuron := range layer.neurons {
+			values, err := goFloats(neuron.Weights)
+			if err != nil {
+				return nil, err
+			}
+
+			fmt.Fprintf(&code, "{%s},\n", values)
+		}
+
+		fmt.Fprintf(&code, "}\n\n")
+	}
+
+	fmt.Fprintf(&code, "// Predict returns the index of the class with the largest output.\n")
+	fmt.Fprintf(&code, "func Predict(features []float32) int {\n")
+	fmt.Fprintf(&code, "outputs := Outputs(features)\nbest := 0\n")
+	fmt.Fprintf(&code, "for i := range outputs {\nif outputs[i] > outputs[best] {\nbest = i\n}\n}\n\nreturn best\n}\n\n")
+
+	fmt.Fprintf(&code, "// Outputs returns the output of each output neuron. The features\n")
+	fmt.Fprintf(&code, "// beyond Inputs are ignored and the missing ones are zero.\n")
+	fmt.Fprintf(&code, "func Outputs(features []float32) [Classes]float32 {\n")
+	fmt.Fprintf(&code, "var x [Inputs]float32\ncopy(x[:], features)\n\n")
+
+	if n.Imputer != nil {
+		fmt.Fprintf(&code, "for i := range imputerFill {\nif x[i] != x[i] {\nx[i] = imputerFill[i]\n}\n}\n\n")
+	}
+
+	if n.Scaler != nil {
+		if n.Scaler.Method == LogScaling {
+			fmt.Fprintf(&code, "for i := range x {\nvalue := float64(x[i])\nx[i] = float32(math.Copysign(math.Log1p(math.Abs(value)), value))\n}\n\n")
+		} else {
+			fmt.Fprintf(&code, "for i := range scalerOffset {\nx[i] = (x[i] - scalerOffset[i]) / scalerScale[i]\n}\n\n")
+		}
+	}
+
+	input := "x"
+	for _, layer := range layers {
+		output := layer.name
+		if layer.name == "output" {
+			output = "outputs"
+		}
+
+		fmt.Fprintf(&code, "var %s [len(%sWeights)]float32\n", output, layer.name)
+		fmt.Fprintf(&code, "for i, weights := range %sWeights {\n", layer.name)
+		fmt.Fprintf(&code, "sum := weights[len(weights) - 1]\nfor j := range %s {\nsum += weights[j] * %s[j]\n}\n\n", input, input)
+		fmt.Fprintf(&code, "%s[i] = sum\n}\n\n", output)
+
+		if function, ok := goActivationFunctions[layer.activation]; ok {
+			fmt.Fprintf(&code, "%s(%s[:])\n\n", function, output)
+		}
+
+		input = output
+	}
+
+	fmt.Fprintf(&code, "return outputs\n}\n")
+
+	written := make(map[Activation]bool)
+	for _, layer := range layers {
+		if function, ok := goActivations[layer.activation]; ok && !written[layer.activation] {
+			fmt.Fprintf(&code, "\n%s\n", function)
+			written[layer.activation] = true
+		}
+	}
+
+	return format.Source(code.Bytes())
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Generates a gofmt-formatted Go source file which embeds a
+// network with a dependency-free Predict function.
+// -Input n: A network.
+// -Input packageName: The package of the generated file.
+// -Output: The source of the file.
+func GenerateGo(n *Network, packageName string) ([]byte, error) {
+	return n.GenerateGo(packageName)
+}
+
+// Writes a float vector as a documented array of the inputs.
+func writeGoArray(code *bytes.Buffer, comment string, name string, values []float32) error {
+	literals, err := goFloats(values)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(code, "// %s\nvar %s = [...]float32{%s}\n\n", comment, name, literals)
+
+	return nil
+}
+
+// Formats floats as Go literals, which convert back into the
+// same float32 values.
+func goFloats(values []float32) (string, error) {
+	var literals bytes.Buffer
+
+	for i, value := range values {
+		if math.IsNaN(float64(value)) || math.IsInf(float64(value), 0) {
+			return "", fmt.Errorf("bp7: the value %v cannot be embedded in generated code", value)
+		}
+
+		if i > 0 {
+			literals.WriteString(", ")
+		}
+
+		literals.WriteString(strconv.FormatFloat(float64(value), 'g', -1, 32))
+	}
+
+	return literals.String(), nil
+}
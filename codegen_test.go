@@ -0,0 +1,255 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// A small dataset with a categorical column and missing values.
+const codegenTestData = `width,height,color,class
+1.5,?,red,a
+2.25,7,green,b
+?,3.5,blue,c
+4,1,red,b
+0.5,9.25,blue,a
+3,2,green,c
+`
+
+// Returns the rows of the test dataset and a network with an
+// encoding, an imputer, a scaler and class labels attached.
+func codegenTestNetwork(t *testing.T) (*Network, [][]float32) {
+	options := DefaultDatasetOptions()
+	options.Header = true
+	options.MissingTokens = []string{"?"}
+	options.CategoricalColumns = []string{"color"}
+
+	dataset, err := ReadDataset(strings.NewReader(codegenTestData), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoding := &CategoricalEncoding{Columns: []ColumnEncoder{{"color", &OneHotEncoder{}}}}
+	if err := encoding.Fit(dataset); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := encoding.Apply(dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imputer, err := FitImputer(encoded.Features, ImputerConfig{Strategy: ImputeMean})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scaler, err := FitScaler(encoded.Transform(imputer).Features, StandardScaling)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := CreateNetwork(len(encoded.FeatureNames), 4, dataset.ClassCount())
+	n.HiddenLayer.Activation = TanhActivation
+	n.OutputLayer.Activation = SoftmaxActivation
+	n.Encoding = encoding
+	n.Imputer = imputer
+	n.Scaler = scaler
+	n.Labels = FitLabelEncoder(dataset.ClassLabels)
+
+	return &n, encoded.Features
+}
+
+// Formats rows as a Go literal, with the missing values as NaN.
+func goRows(rows [][]float32) string {
+	var literal bytes.Buffer
+
+	literal.WriteString("[][]float32{\n")
+
+	for _, row := range rows {
+		literal.WriteString("{")
+
+		for i, value := range row {
+			if i > 0 {
+				literal.WriteString(", ")
+			}
+
+			if isMissing(value) {
+				literal.WriteString("float32(math.NaN())")
+			} else {
+				literal.WriteString(strconv.FormatFloat(float64(value), 'g', -1, 32))
+			}
+		}
+
+		literal.WriteString("},\n")
+	}
+
+	literal.WriteString("}")
+
+	return literal.String()
+}
+
+// Parses the lines of a program which prints the predicted
+// class and the outputs of each row.
+func parsePredictions(t *testing.T, output string, rows int) ([]int, [][]float32) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != rows {
+		t.Fatalf("the program printed %d lines for %d rows:\n%s", len(lines), rows, output)
+	}
+
+	classes := make([]int, rows)
+	outputs := make([][]float32, rows)
+
+	for i, line := range lines {
+		fields := strings.Fields(line)
+
+		class, err := strconv.Atoi(fields[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		classes[i] = class
+
+		if outputs[i], err = parseFloats(fields[1:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return classes, outputs
+}
+
+// Checks the predictions of a generated program against the
+// network.
+func checkPredictions(t *testing.T, n *Network, rows [][]float32, classes []int, outputs [][]float32) {
+	for i, row := range rows {
+		if want := n.Predict(row); classes[i] != want {
+			t.Errorf("row %d: Predict() = %d, want %d", i, classes[i], want)
+		}
+
+		probabilities := normalize(outputs[i])
+
+		want, err := n.PredictProbabilities(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(probabilities) != len(want) {
+			t.Fatalf("row %d: %d outputs, want %d", i, len(probabilities), len(want))
+		}
+
+		for j := range want {
+			if math.Abs(float64(probabilities[j] - want[j])) > 1e-5 {
+				t.Errorf("row %d: the probabilities %v, want %v", i, probabilities, want)
+				break
+			}
+		}
+	}
+}
+
+func TestGenerateGoPredictions(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go tool is not available")
+	}
+
+	full, rows := codegenTestNetwork(t)
+
+	plain := CreateNetwork(len(rows[0]), 3, 3)
+	scaled := full.Scaler.Transform(full.Imputer.Transform(rows[0]))
+
+	cases := []struct {
+		name string
+		n    *Network
+		rows [][]float32
+	}{
+		{"components", full, rows},
+		{"plain", &plain, [][]float32{scaled, {0, 0, 0, 0, 0}, {1, -1, 2, -2, 0.5}}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, err := c.n.GenerateGo("model")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+
+			files := map[string]string{
+				"go.mod":             "module generated\n\ngo 1.15\n",
+				"model/model_gen.go": string(code),
+				"main.go": fmt.Sprintf(`package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"generated/model"
+)
+
+var _ = math.NaN
+
+var rows = %s
+
+func main() {
+	for _, row := range rows {
+		fmt.Print(model.Predict(row))
+
+		for _, output := range model.Outputs(row) {
+			fmt.Print(" ", strconv.FormatFloat(float64(output), 'g', -1, 32))
+		}
+
+		fmt.Println()
+	}
+}
+`, goRows(c.rows)),
+			}
+
+			for name, content := range files {
+				path := filepath.Join(dir, name)
+
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			command := exec.Command(goTool, "run", ".")
+			command.Dir = dir
+			command.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+
+			output, err := command.CombinedOutput()
+			if err != nil {
+				t.Fatalf("go run: %v\n%s", err, output)
+			}
+
+			classes, outputs := parsePredictions(t, string(output), len(c.rows))
+			checkPredictions(t, c.n, c.rows, classes, outputs)
+		})
+	}
+}
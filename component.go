@@ -0,0 +1,423 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A fitted component which is attached to a network, like
+// a calibrator, and is persisted together with the layer
+// weights as csv records.
+type component interface {
+	records() [][]string
+	parseRecords(records [][]string) error
+}
+
+// The file of the extracted layer activations.
+const activationsFileName = "activations.csv"
+
+// Writes the layer activations and the fitted components which
+// are attached to the network next to the layer weights. The
+// activations are always written, like the layers, while only
+// the attached components are written, so that the files of
+// the working directory with the same names are never removed.
+func (n *Network) extractComponents() {
+	if err := writeRecords(activationsFileName, n.activationRecords()); err != nil {
+		panic("Fail to write " + activationsFileName)
+	}
+
+	extractComponent(calibrationFileName, n.Calibration != nil, n.Calibration)
+	extractComponent(thresholdFileName, n.Threshold != nil, n.Threshold)
+	extractComponent(imputerFileName, n.Imputer != nil, n.Imputer)
+	extractComponent(scalerFileName, n.Scaler != nil, n.Scaler)
+	extractComponent(encodingFileName, n.Encoding != nil, n.Encoding)
+	extractComponent(labelsFileName, n.Labels != nil, n.Labels)
+}
+
+// Imports the layer activations and the fitted components
+// which were extracted into a directory by Extract. A component
+// is attached only if its file exists in the directory, and the
+// other components of the network are kept. The activations of
+// a directory extracted before they existed are kept as well.
+// The layers have to be imported first, since the components
+// are checked against them.
+// -Input directory: The directory of the component files.
+func (n *Network) ImportComponents(directory string) error {
+	loaded := *n
+
+	activationsPath := filepath.Join(directory, activationsFileName)
+	records, err := readRecords(activationsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("bp7: %s: %v", activationsPath, err)
+	}
+
+	if err == nil {
+		if err := loaded.parseActivations(records); err != nil {
+			return fmt.Errorf("bp7: %s: %v", activationsPath, err)
+		}
+	}
+
+	calibrator := &Calibrator{}
+	found, err := importComponent(filepath.Join(directory, calibrationFileName), calibrator)
+	if err != nil {
+		return err
+	}
+
+	if found {
+		loaded.Calibration = calibrator
+	}
+
+	threshold := &DecisionThreshold{}
+	if found, err = importComponent(filepath.Join(directory, thresholdFileName), threshold); err != nil {
+		return err
+	}
+
+	if found {
+		loaded.Threshold = threshold
+	}
+
+	imputer := &Imputer{}
+	if found, err = importComponent(filepath.Join(directory, imputerFileName), imputer); err != nil {
+		return err
+	}
+
+	if found {
+		loaded.Imputer = imputer
+	}
+
+	scaler := &Scaler{}
+	if found, err = importComponent(filepath.Join(directory, scalerFileName), scaler); err != nil {
+		return err
+	}
+
+	if found {
+		loaded.Scaler = scaler
+	}
+
+	encoding := &CategoricalEncoding{}
+	if found, err = importComponent(filepath.Join(directory, encodingFileName), encoding); err != nil {
+		return err
+	}
+
+	if found {
+		loaded.Encoding = encoding
+	}
+
+	labels := &LabelEncoder{}
+	if found, err = importComponent(filepath.Join(directory, labelsFileName), labels); err != nil {
+		return err
+	}
+
+	if found {
+		loaded.Labels = labels
+	}
+
+	if err := loaded.checkComponents(); err != nil {
+		return err
+	}
+
+	*n = loaded
+
+	return nil
+}
+
+// Imports the fitted components which were extracted into a
+// directory by Extract.
+// -Input n: A network.
+// -Input directory: The directory of the component files.
+func ImportComponents(n *Network, directory string) error {
+	return n.ImportComponents(directory)
+}
+
+// Writes a component into a file in the working directory,
+// if the network has it.
+// -Input fileName: The file of the component.
+// -Input attached: Whether the network has the component.
+// -Input c: The component.
+func extractComponent(fileName string, attached bool, c component) {
+	if !attached {
+		return
+	}
+
+	if err := writeRecords(fileName, c.records()); err != nil {
+		panic("Fail to write " + fileName)
+	}
+}
+
+// Reads a component from a file, if the file exists.
+// -Input filePath: The file of the component.
+// -Input c: The component to fill in.
+// -Output: Whether the component was read.
+func importComponent(filePath string, c component) (bool, error) {
+	records, err := readRecords(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("bp7: %s: %v", filePath, err)
+	}
+
+	if err := c.parseRecords(records); err != nil {
+		return false, fmt.Errorf("bp7: %s: %v", filePath, err)
+	}
+
+	return true, nil
+}
+
+// A named group of csv records inside a single stream, like
+// the hidden layer weights or the scaler of a pipeline.
+type section struct {
+	name    string
+	records [][]string
+}
+
+// Writes sections into a single csv stream. Each section
+// starts with a "section,<name>" record. A record of a section
+// whose first field is "section", like a metadata key, gets a
+// backslash before it, so that it is not read as a header.
+// -Input w: The writer of the stream.
+// -Input sections: The sections to write.
+func writeSections(w io.Writer, sections []section) error {
+	writer := csv.NewWriter(w)
+
+	for _, s := range sections {
+		if err := writer.Write([]string{"section", s.name}); err != nil {
+			return err
+		}
+
+		for _, record := range s.records {
+			if len(record) > 0 && isSectionMarker(record[0]) {
+				record = append([]string{`\` + record[0]}, record[1:]...)
+			}
+
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// Reads the sections of a csv stream written by writeSections.
+// -Input r: The reader of the stream.
+// -Output: The sections, in the order of the stream.
+func readSections(r io.Reader) ([]section, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	sections := make([]section, 0)
+
+	for _, record := range records {
+		if record[0] == "section" && len(record) == 2 {
+			sections = append(sections, section{name: record[1]})
+			continue
+		}
+
+		if len(sections) == 0 {
+			return nil, fmt.Errorf("bp7: record %v is outside of a section", record)
+		}
+
+		if strings.HasPrefix(record[0], `\`) && isSectionMarker(record[0][1:]) {
+			record[0] = record[0][1:]
+		}
+
+		last := &sections[len(sections) - 1]
+		last.records = append(last.records, record)
+	}
+
+	return sections, nil
+}
+
+// Returns whether a field is the marker of a section header,
+// with any backslashes of its escaping before it.
+func isSectionMarker(field string) bool {
+	return strings.TrimLeft(field, `\`) == "section"
+}
+
+// Converts the weights of a layer into csv records, one
+// record per neuron, like the extracted layer files.
+func neuronRecords(neurons []Neuron) [][]string {
+	records := make([][]string, len(neurons))
+
+	for i := 0; i < len(neurons); i++ {
+		records[i] = formatFloats(neurons[i].Weights)
+	}
+
+	return records
+}
+
+// Restores the neurons of a layer from their csv records.
+func parseNeurons(records [][]string) ([]Neuron, error) {
+	neurons := make([]Neuron, len(records))
+
+	for i := 0; i < len(records); i++ {
+		weights, err := parseFloats(records[i])
+		if err != nil {
+			return nil, err
+		}
+
+		neurons[i].Weights = weights
+	}
+
+	return neurons, nil
+}
+
+// Checks that the attached components match the layers of
+// the network, so that a malformed model fails when it is
+// loaded instead of when it predicts.
+func (n *Network) checkComponents() error {
+	if n.Calibration != nil {
+		if err := n.Calibration.check(len(n.OutputLayer.Neurons)); err != nil {
+			return err
+		}
+	}
+
+	if n.Imputer != nil {
+		if err := n.Imputer.check(); err != nil {
+			return err
+		}
+	}
+
+	if n.Scaler != nil && len(n.Scaler.Offset) != len(n.Scaler.Scale) {
+		return errors.New("bp7: the scaler offsets and scales differ in length")
+	}
+
+	if n.Encoding != nil {
+		if err := n.Encoding.check(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the sections of the network weights followed by
+// the sections of the attached components.
+func (n *Network) sections() []section {
+	sections := []section{
+		{"hidden", neuronRecords(n.HiddenLayer.Neurons)},
+		{"output", neuronRecords(n.OutputLayer.Neurons)},
+	}
+
+	if n.Calibration != nil {
+		sections = append(sections, section{"calibration", n.Calibration.records()})
+	}
+
+	if n.Threshold != nil {
+		sections = append(sections, section{"threshold", n.Threshold.records()})
+	}
+
+	if n.Imputer != nil {
+		sections = append(sections, section{"imputer", n.Imputer.records()})
+	}
+
+	if n.Scaler != nil {
+		sections = append(sections, section{"scaler", n.Scaler.records()})
+	}
+
+	if n.Encoding != nil {
+		sections = append(sections, section{"encoding", n.Encoding.records()})
+	}
+
+	if n.Labels != nil {
+		sections = append(sections, section{"labels", n.Labels.records()})
+	}
+
+	if len(n.Metadata) > 0 {
+		keys := make([]string, 0, len(n.Metadata))
+		for key := range n.Metadata {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		metadata := section{name: "metadata"}
+		for _, key := range keys {
+			metadata.records = append(metadata.records, []string{key, n.Metadata[key]})
+		}
+
+		sections = append(sections, metadata)
+	}
+
+	return sections
+}
+
+// Restores a section of the network weights or of an
+// attached component.
+// -Input s: The section.
+// -Output: Whether the section belongs to the network.
+func (n *Network) parseSection(s section) (bool, error) {
+	var err error
+
+	switch s.name {
+	case "hidden":
+		n.HiddenLayer.Neurons, err = parseNeurons(s.records)
+	case "output":
+		n.OutputLayer.Neurons, err = parseNeurons(s.records)
+	case "calibration":
+		n.Calibration = &Calibrator{}
+		err = n.Calibration.parseRecords(s.records)
+	case "threshold":
+		n.Threshold = &DecisionThreshold{}
+		err = n.Threshold.parseRecords(s.records)
+	case "imputer":
+		n.Imputer = &Imputer{}
+		err = n.Imputer.parseRecords(s.records)
+	case "scaler":
+		n.Scaler = &Scaler{}
+		err = n.Scaler.parseRecords(s.records)
+	case "encoding":
+		n.Encoding = &CategoricalEncoding{}
+		err = n.Encoding.parseRecords(s.records)
+	case "labels":
+		n.Labels = &LabelEncoder{}
+		err = n.Labels.parseRecords(s.records)
+	case "metadata":
+		n.Metadata = make(map[string]string)
+
+		for _, record := range s.records {
+			if len(record) != 2 {
+				return true, fmt.Errorf("bp7: invalid metadata record %v", record)
+			}
+
+			n.Metadata[record[0]] = record[1]
+		}
+	default:
+		return false, nil
+	}
+
+	if err != nil {
+		return true, fmt.Errorf("bp7: section %q: %v", s.name, err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+// Runs a function inside a temporary working directory, since
+// Extract writes its files into the working directory.
+func inTempDir(t *testing.T, f func(dir string)) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Chdir(wd)
+
+	f(dir)
+}
+
+func TestExtractActivations(t *testing.T) {
+	n := CreateNetwork(3, 4, 2)
+	n.HiddenLayer.Activation = ReLUActivation
+	n.OutputLayer.Activation = SoftmaxActivation
+
+	inTempDir(t, func(dir string) {
+		n.Extract()
+
+		imported := &Network{}
+		imported.Import("hidden_layer.csv", "output_layer.csv")
+
+		if err := imported.ImportComponents(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		if imported.HiddenLayer.Activation != ReLUActivation || imported.OutputLayer.Activation != SoftmaxActivation {
+			t.Errorf("activations = %q, %q, want relu, softmax", imported.HiddenLayer.Activation, imported.OutputLayer.Activation)
+		}
+
+		row := []float32{0.5, -1, 2}
+		if got, want := imported.infer(row), n.infer(row); !reflect.DeepEqual(got, want) {
+			t.Errorf("infer() = %v, want %v", got, want)
+		}
+
+		if err := os.Remove(activationsFileName); err != nil {
+			t.Fatal(err)
+		}
+
+		legacy := &Network{}
+		legacy.Import("hidden_layer.csv", "output_layer.csv")
+
+		if err := legacy.ImportComponents(dir); err != nil {
+			t.Fatal(err)
+		}
+
+		if legacy.HiddenLayer.Activation.name() != SigmoidActivation {
+			t.Errorf("the activation without a file = %q, want sigmoid", legacy.HiddenLayer.Activation)
+		}
+
+		if err := writeRecords(activationsFileName, [][]string{{"hidden", "swish"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := legacy.ImportComponents(dir); err == nil {
+			t.Error("ImportComponents() accepted an unknown activation")
+		}
+	})
+}
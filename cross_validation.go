@@ -0,0 +1,365 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// How the entries of a dataset are divided into folds.
+type FoldMethod string
+
+const (
+	// Shuffles the entries and divides them into k folds.
+	KFold FoldMethod = "kfold"
+	// Divides every class into k folds, so that every fold
+	// keeps the class ratios of the whole dataset.
+	StratifiedKFold FoldMethod = "stratified"
+	// Holds out one entry at a time, so there are as many
+	// folds as entries and k is ignored.
+	LeaveOneOut FoldMethod = "loo"
+)
+
+// Builds a fresh, untrained network for a fold. If the
+// returned network is not initialized, it is initialized
+// from the dataset and the training configuration.
+type NetworkFactory func() *Network
+
+// The configuration of the training of a network.
+type TrainConfig struct {
+	HiddenNeurons int
+	LearningRate  float32
+	Epochs        int
+}
+
+// The configuration of a cross-validation.
+type CrossValidationConfig struct {
+	Method FoldMethod
+	// How many times the folds are built again with a new
+	// shuffle. A value lower than one means once.
+	Repeats int
+	// The seed of the shuffles, so that a run can be repeated.
+	Seed int64
+	// How many folds are trained in parallel. A value lower
+	// than one means one fold per available CPU.
+	Workers int
+}
+
+// The mean and the sample standard deviation of a metric
+// over the folds.
+type MetricSummary struct {
+	Mean   float32
+	StdDev float32
+}
+
+// The result of a cross-validation.
+type CrossValidationResult struct {
+	// The report of each held-out fold, in order. The folds
+	// of every repeat follow the folds of the previous one.
+	Folds []ClassificationReport
+	// The report over the held-out predictions of all the
+	// folds together. With leave-one-out it is the only
+	// meaningful report.
+	Pooled ClassificationReport
+
+	Accuracy            MetricSummary
+	BalancedAccuracy    MetricSummary
+	MacroF1             MetricSummary
+	MatthewsCorrelation MetricSummary
+	LogLoss             MetricSummary
+	// Only set when the dataset has exactly two classes.
+	ROCAUC MetricSummary
+	PRAUC  MetricSummary
+}
+
+// Returns a stratified k-fold configuration, run once on
+// one goroutine per available CPU.
+func DefaultCrossValidationConfig() CrossValidationConfig {
+	return CrossValidationConfig{Method: StratifiedKFold, Repeats: 1, Workers: runtime.NumCPU()}
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Renders the aggregated metrics as a human readable list.
+func (r CrossValidationResult) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Folds: %d\n", len(r.Folds))
+	fmt.Fprintf(&b, "Accuracy: %.4f ± %.4f\n", r.Accuracy.Mean, r.Accuracy.StdDev)
+	fmt.Fprintf(&b, "Balanced accuracy: %.4f ± %.4f\n", r.BalancedAccuracy.Mean, r.BalancedAccuracy.StdDev)
+	fmt.Fprintf(&b, "Macro F1: %.4f ± %.4f\n", r.MacroF1.Mean, r.MacroF1.StdDev)
+	fmt.Fprintf(&b, "Matthews correlation: %.4f ± %.4f\n", r.MatthewsCorrelation.Mean, r.MatthewsCorrelation.StdDev)
+	fmt.Fprintf(&b, "Log loss: %.4f ± %.4f", r.LogLoss.Mean, r.LogLoss.StdDev)
+
+	if r.Pooled.Binary {
+		fmt.Fprintf(&b, "\nROC-AUC: %.4f ± %.4f", r.ROCAUC.Mean, r.ROCAUC.StdDev)
+		fmt.Fprintf(&b, "\nPR-AUC: %.4f ± %.4f", r.PRAUC.Mean, r.PRAUC.StdDev)
+	}
+
+	return b.String()
+}
+
+// Divides the entries of the dataset into folds.
+// -Input k: The fold count.
+// -Input method: How the entries are divided.
+// -Input random: The source of the shuffles.
+// -Output: The entry indexes of each fold.
+func (d *Dataset) folds(k int, method FoldMethod, random *rand.Rand) ([][]int, error) {
+	if method == LeaveOneOut {
+		folds := make([][]int, d.Len())
+		for i := 0; i < d.Len(); i++ {
+			folds[i] = []int{i}
+		}
+
+		return folds, nil
+	}
+
+	if k < 2 || k > d.Len() {
+		return nil, fmt.Errorf("bp7: cannot divide %d entries into %d folds", d.Len(), k)
+	}
+
+	ratios := make([]float64, k)
+	for i := 0; i < k; i++ {
+		ratios[i] = 1
+	}
+
+	switch method {
+	case KFold:
+		return divide(random.Perm(d.Len()), ratios), nil
+	case StratifiedKFold:
+		classes := make([][]int, d.ClassCount())
+		for i := 0; i < d.Len(); i++ {
+			classes[d.Targets[i]] = append(classes[d.Targets[i]], i)
+		}
+
+		folds := make([][]int, k)
+		offset := 0
+
+		for _, class := range classes {
+			random.Shuffle(len(class), func(a, b int) {
+				class[a], class[b] = class[b], class[a]
+			})
+
+			// The first folds of a class get the remaining
+			// entries, so the folds are rotated in order to
+			// spread the remainders of the classes.
+			parts := divide(class, ratios)
+			for i := 0; i < k; i++ {
+				fold := (i + offset) % k
+				folds[fold] = append(folds[fold], parts[i]...)
+			}
+
+			offset += len(class) % k
+		}
+
+		return folds, nil
+	}
+
+	return nil, fmt.Errorf("bp7: unknown fold method %q", method)
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Runs a stratified k-fold cross-validation: for every fold
+// a fresh network is trained on the other folds and it is
+// evaluated on the held-out fold.
+// -Input factory: Builds the network of each fold.
+// -Input d: The dataset.
+// -Input k: The fold count.
+// -Input train: How each network is trained.
+// -Output: The per fold and the aggregated metrics.
+func CrossValidate(factory NetworkFactory, d *Dataset, k int, train TrainConfig) (CrossValidationResult, error) {
+	return CrossValidateWithConfig(factory, d, k, train, DefaultCrossValidationConfig())
+}
+
+// Runs a cross-validation: for every fold a fresh network is
+// trained on the other folds and it is evaluated on the
+// held-out fold. The folds are trained in parallel.
+// -Input factory: Builds the network of each fold.
+// -Input d: The dataset.
+// -Input k: The fold count.
+// -Input train: How each network is trained.
+// -Input config: The fold method, the repeats and the workers.
+// -Output: The per fold and the aggregated metrics.
+func CrossValidateWithConfig(factory NetworkFactory, d *Dataset, k int, train TrainConfig, config CrossValidationConfig) (CrossValidationResult, error) {
+	result := CrossValidationResult{}
+
+	if d.Len() == 0 {
+		return result, errors.New("bp7: the dataset is empty")
+	}
+
+	repeats := config.Repeats
+	if repeats < 1 {
+		repeats = 1
+	}
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	random := rand.New(rand.NewSource(config.Seed))
+	folds := make([][]int, 0)
+
+	for r := 0; r < repeats; r++ {
+		repeat, err := d.folds(k, config.Method, random)
+		if err != nil {
+			return result, err
+		}
+
+		folds = append(folds, repeat...)
+	}
+
+	result.Folds = make([]ClassificationReport, len(folds))
+	probabilities := make([][][]float32, len(folds))
+	errs := make([]error, len(folds))
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+
+	for f := range folds {
+		wg.Add(1)
+		slots <- struct{}{}
+
+		go func(f int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			probabilities[f], result.Folds[f], errs[f] = validateFold(factory, d, folds[f], train)
+		}(f)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return result, err
+		}
+	}
+
+	labels := make([]int, 0, d.Len() * repeats)
+	pooled := make([][]float32, 0, d.Len() * repeats)
+
+	for f, fold := range folds {
+		for i, index := range fold {
+			labels = append(labels, d.Targets[index])
+			pooled = append(pooled, probabilities[f][i])
+		}
+	}
+
+	var err error
+
+	result.Pooled, err = EvaluatePredictions(labels, pooled)
+	if err != nil {
+		return result, err
+	}
+
+	result.Accuracy = summarize(result.Folds, func(r ClassificationReport) float32 { return r.Accuracy })
+	result.BalancedAccuracy = summarize(result.Folds, func(r ClassificationReport) float32 { return r.BalancedAccuracy })
+	result.MacroF1 = summarize(result.Folds, func(r ClassificationReport) float32 { return r.MacroF1 })
+	result.MatthewsCorrelation = summarize(result.Folds, func(r ClassificationReport) float32 { return r.MatthewsCorrelation })
+	result.LogLoss = summarize(result.Folds, func(r ClassificationReport) float32 { return r.LogLoss })
+
+	if result.Pooled.Binary {
+		result.ROCAUC = summarize(result.Folds, func(r ClassificationReport) float32 { return r.ROCAUC })
+		result.PRAUC = summarize(result.Folds, func(r ClassificationReport) float32 { return r.PRAUC })
+	}
+
+	return result, nil
+}
+
+// Trains a fresh network on every entry except the held-out
+// ones and evaluates it on the held-out entries.
+// -Input factory: Builds the network.
+// -Input d: The dataset.
+// -Input heldOut: The indexes of the held-out entries.
+// -Input train: How the network is trained.
+// -Output: The probabilities of the held-out entries and
+// their report.
+func validateFold(factory NetworkFactory, d *Dataset, heldOut []int, train TrainConfig) ([][]float32, ClassificationReport, error) {
+	excluded := make(map[int]bool, len(heldOut))
+	for _, index := range heldOut {
+		excluded[index] = true
+	}
+
+	kept := make([]int, 0, d.Len() - len(heldOut))
+	for i := 0; i < d.Len(); i++ {
+		if !excluded[i] {
+			kept = append(kept, i)
+		}
+	}
+
+	network := factory()
+
+	if len(network.HiddenLayer.Neurons) == 0 || len(network.OutputLayer.Neurons) == 0 {
+		network.Init(len(d.FeatureNames), train.HiddenNeurons, d.ClassCount())
+	}
+
+	// The folds train in parallel, so they do not print their
+	// epochs, and a failed training is the error of the fold.
+	rows := d.Subset(kept).Rows()
+
+	err := network.trainLoop(CreateSliceSource(rows, len(rows)), train.LearningRate, train.Epochs, d.ClassCount(), trainingLoop{quiet: true})
+	if err != nil {
+		return nil, ClassificationReport{}, err
+	}
+
+	test := d.Subset(heldOut)
+
+	probabilities, err := network.PredictProbabilitiesBatchWithConfig(test.Rows(), BatchConfig{Workers: 1})
+	if err != nil {
+		return nil, ClassificationReport{}, err
+	}
+
+	report, err := EvaluatePredictions(test.Targets, probabilities)
+
+	return probabilities, report, err
+}
+
+// Calculates the mean and the sample standard deviation of
+// a metric over the fold reports.
+func summarize(reports []ClassificationReport, metric func(ClassificationReport) float32) MetricSummary {
+	var sum float64 = 0.0
+	for _, report := range reports {
+		sum += float64(metric(report))
+	}
+
+	mean := sum / float64(len(reports))
+
+	if len(reports) < 2 {
+		return MetricSummary{Mean: float32(mean)}
+	}
+
+	var squares float64 = 0.0
+	for _, report := range reports {
+		deviation := float64(metric(report)) - mean
+		squares += deviation * deviation
+	}
+
+	return MetricSummary{
+		Mean:   float32(mean),
+		StdDev: float32(math.Sqrt(squares / float64(len(reports) - 1))),
+	}
+}
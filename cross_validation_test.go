@@ -0,0 +1,93 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// A small numeric dataset with three classes.
+const crossValidationTestData = `x,y,class
+0.1,0.2,a
+0.2,0.1,a
+0.15,0.25,a
+0.9,0.8,b
+0.8,0.9,b
+0.85,0.95,b
+0.1,0.9,c
+0.2,0.8,c
+0.15,0.85,c
+`
+
+func crossValidationTestDataset(t *testing.T) *Dataset {
+	options := DefaultDatasetOptions()
+	options.Header = true
+
+	d, err := ReadDataset(strings.NewReader(crossValidationTestData), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+func TestCrossValidateQuiet(t *testing.T) {
+	d := crossValidationTestDataset(t)
+
+	stdout := os.Stdout
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Stdout = writer
+
+	result, err := CrossValidate(func() *Network { return &Network{} }, d, 3, TrainConfig{HiddenNeurons: 4, LearningRate: 0.3, Epochs: 5})
+
+	os.Stdout = stdout
+	writer.Close()
+
+	output, _ := ioutil.ReadAll(reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Folds) != 3 {
+		t.Errorf("the cross-validation has %d folds, want 3", len(result.Folds))
+	}
+
+	if len(output) > 0 {
+		t.Errorf("the folds printed:\n%s", output)
+	}
+}
+
+func TestCrossValidateTrainingError(t *testing.T) {
+	d := crossValidationTestDataset(t)
+
+	// The network has fewer outputs than the dataset has classes.
+	factory := func() *Network {
+		n := CreateNetwork(2, 4, 2)
+		return &n
+	}
+
+	if _, err := CrossValidate(factory, d, 3, TrainConfig{LearningRate: 0.3, Epochs: 1}); err == nil {
+		t.Error("CrossValidate() accepted a network with 2 outputs for 3 classes")
+	}
+}
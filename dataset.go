@@ -0,0 +1,471 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The options of the dataset loader. Use DefaultDatasetOptions
+// in order to get the defaults and change only what differs.
+type DatasetOptions struct {
+	// The field delimiter, ',' by default.
+	Delimiter rune
+	// Whether the first record holds the column names. Without
+	// a header the columns are named column0, column1, etc.
+	Header bool
+	// The lines starting with this character are skipped.
+	// Zero means that there are no comments.
+	Comment rune
+	// The name of the target column. When it is empty the
+	// target column is chosen by TargetIndex.
+	TargetColumn string
+	// The index of the target column. A negative index counts
+	// from the end, so -1 is the last column.
+	TargetIndex int
+	// The names of the columns which are neither features nor
+	// the target, like an ID column.
+	IgnoredColumns []string
+	// The field values which mean that a feature is missing,
+	// like "?". Missing features are loaded as NaN.
+	MissingTokens []string
+	// The names of the columns which hold categorical string
+	// values. They are kept apart from the numeric features
+	// until they are encoded.
+	CategoricalColumns []string
+	// The optional class-label vocabulary, like the ClassLabels
+	// of the training dataset. When it is set the targets are
+	// indexes into it, so that a training and a test file get
+	// the same class indexes, and a label which is not in it
+	// is an error. Otherwise the vocabulary is built from the
+	// labels of the file.
+	ClassLabels []string
+}
+
+// A preprocessing step which maps a feature vector to a
+// new one, like an imputer. A transformer is fitted on
+// the training features and then applied unchanged on
+// every later entry.
+type Transformer interface {
+	Transform(features []float32) []float32
+}
+
+// A labelled dataset. The features and the targets are kept
+// apart, and the targets are class indexes into ClassLabels.
+type Dataset struct {
+	FeatureNames []string
+	TargetName   string
+	Features     [][]float32
+	Targets      []int
+	// The class-label vocabulary. The label of the class i
+	// is ClassLabels[i].
+	ClassLabels []string
+	// The names and the values of the categorical columns
+	// which are not encoded yet. See CategoricalEncoding.
+	CategoricalNames []string
+	Categorical      [][]string
+	// The names of all the columns of the loaded file, in the
+	// order of its records.
+	Columns []string
+}
+
+// Returns the default options: comma delimited, without a
+// header and comments, the target in the last column.
+func DefaultDatasetOptions() DatasetOptions {
+	return DatasetOptions{Delimiter: ',', TargetIndex: -1}
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Returns how many entries the dataset has.
+func (d *Dataset) Len() int {
+	return len(d.Features)
+}
+
+// Returns how many classes the dataset has.
+func (d *Dataset) ClassCount() int {
+	return len(d.ClassLabels)
+}
+
+// Returns the position of a feature, or -1 if the dataset
+// does not have a feature with this name.
+// -Input name: The feature name.
+func (d *Dataset) FeatureIndex(name string) int {
+	for i := 0; i < len(d.FeatureNames); i++ {
+		if d.FeatureNames[i] == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Converts the dataset into the rows expected by Train and
+// Evaluate: the features followed by the class index.
+// -Output: The rows of the dataset.
+func (d *Dataset) Rows() [][]float32 {
+	rows := make([][]float32, len(d.Features))
+
+	for i := 0; i < len(d.Features); i++ {
+		row := make([]float32, len(d.Features[i]) + 1)
+		copy(row, d.Features[i])
+		row[len(row) - 1] = float32(d.Targets[i])
+
+		rows[i] = row
+	}
+
+	return rows
+}
+
+// Returns a dataset with the given entries, in the given
+// order. The entries are shared with the original dataset.
+// -Input indexes: The positions of the entries to keep.
+// -Output: The new dataset.
+func (d *Dataset) Subset(indexes []int) *Dataset {
+	subset := &Dataset{
+		FeatureNames:     d.FeatureNames,
+		TargetName:       d.TargetName,
+		Features:         make([][]float32, len(indexes)),
+		Targets:          make([]int, len(indexes)),
+		ClassLabels:      d.ClassLabels,
+		CategoricalNames: d.CategoricalNames,
+		Columns:          d.Columns,
+	}
+
+	for i, index := range indexes {
+		subset.Features[i] = d.Features[index]
+		subset.Targets[i] = d.Targets[index]
+
+		if len(d.Categorical) > 0 {
+			subset.Categorical = append(subset.Categorical, d.Categorical[index])
+		}
+	}
+
+	return subset
+}
+
+// Drops the entries which have at least one missing value.
+// -Output: A new dataset with the complete entries.
+func (d *Dataset) DropMissing() *Dataset {
+	indexes := make([]int, 0)
+
+	for i := 0; i < len(d.Features); i++ {
+		complete := true
+
+		for _, value := range d.Features[i] {
+			if isMissing(value) {
+				complete = false
+				break
+			}
+		}
+
+		if complete {
+			indexes = append(indexes, i)
+		}
+	}
+
+	return d.Subset(indexes)
+}
+
+// Applies a fitted transformer on the features of every
+// entry.
+// -Input t: The transformer.
+// -Output: A new dataset with the transformed features.
+func (d *Dataset) Transform(t Transformer) *Dataset {
+	transformed := &Dataset{
+		FeatureNames:     d.FeatureNames,
+		TargetName:       d.TargetName,
+		Features:         make([][]float32, len(d.Features)),
+		Targets:          d.Targets,
+		ClassLabels:      d.ClassLabels,
+		CategoricalNames: d.CategoricalNames,
+		Categorical:      d.Categorical,
+		Columns:          d.Columns,
+	}
+
+	for i := 0; i < len(d.Features); i++ {
+		transformed.Features[i] = t.Transform(d.Features[i])
+	}
+
+	return transformed
+}
+
+// Maps the targets onto another class-label vocabulary, like
+// the one of the training dataset, so that both datasets have
+// the same class indexes.
+// -Input classLabels: The new vocabulary. It must contain every
+// label of the dataset.
+func (d *Dataset) RemapClasses(classLabels []string) error {
+	targets := make([]int, len(d.Targets))
+
+	for i, class := range d.Targets {
+		targets[i] = indexOf(classLabels, d.ClassLabels[class])
+
+		if targets[i] < 0 {
+			return fmt.Errorf("bp7: row %d has the class %q which is not in the class labels", i, d.ClassLabels[class])
+		}
+	}
+
+	d.Targets = targets
+	d.ClassLabels = append([]string(nil), classLabels...)
+
+	return nil
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Loads a dataset from a delimited text file.
+// -Input filePath: The path of the file.
+// -Input options: The loader options.
+// -Output: The dataset.
+func LoadDataset(filePath string, options DatasetOptions) (*Dataset, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return ReadDataset(file, options)
+}
+
+// Reads a dataset from delimited text.
+// -Input r: The reader of the text.
+// -Input options: The loader options.
+// -Output: The dataset.
+func ReadDataset(r io.Reader, options DatasetOptions) (*Dataset, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = options.Comment
+
+	if options.Delimiter != 0 {
+		reader.Comma = options.Delimiter
+	}
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("bp7: the dataset is empty")
+	}
+
+	columns := make([]string, len(records[0]))
+
+	if options.Header {
+		for i := 0; i < len(columns); i++ {
+			columns[i] = strings.TrimSpace(records[0][i])
+		}
+
+		records = records[1:]
+	} else {
+		for i := 0; i < len(columns); i++ {
+			columns[i] = fmt.Sprintf("column%d", i)
+		}
+	}
+
+	return buildDataset(columns, records, options)
+}
+
+// Builds a dataset from the fields of its records, which is
+// shared by the loaders of every format.
+// -Input columns: The column names.
+// -Input records: The fields of every record.
+// -Input options: The loader options.
+// -Output: The dataset.
+func buildDataset(columns []string, records [][]string, options DatasetOptions) (*Dataset, error) {
+	target, err := targetColumn(columns, options)
+	if err != nil {
+		return nil, err
+	}
+
+	ignored := make(map[int]bool)
+
+	for _, name := range options.IgnoredColumns {
+		index := indexOf(columns, name)
+		if index < 0 {
+			return nil, fmt.Errorf("bp7: there is no column %q to ignore", name)
+		}
+
+		ignored[index] = true
+	}
+
+	dataset := &Dataset{TargetName: columns[target], Columns: columns}
+
+	categorical := make(map[int]bool)
+
+	for _, name := range options.CategoricalColumns {
+		index := indexOf(columns, name)
+		if index < 0 {
+			return nil, fmt.Errorf("bp7: there is no categorical column %q", name)
+		}
+
+		categorical[index] = true
+	}
+
+	features := make([]int, 0)
+	categories := make([]int, 0)
+
+	for i := 0; i < len(columns); i++ {
+		if i == target || ignored[i] {
+			continue
+		}
+
+		if categorical[i] {
+			categories = append(categories, i)
+			dataset.CategoricalNames = append(dataset.CategoricalNames, columns[i])
+		} else {
+			features = append(features, i)
+			dataset.FeatureNames = append(dataset.FeatureNames, columns[i])
+		}
+	}
+
+	labels := make([]string, len(records))
+
+	for r, record := range records {
+		if len(record) != len(columns) {
+			return nil, fmt.Errorf("bp7: row %d has %d fields, expected %d", r, len(record), len(columns))
+		}
+
+		entry := make([]float32, len(features))
+
+		for i, column := range features {
+			field := strings.TrimSpace(record[column])
+
+			if indexOf(options.MissingTokens, field) >= 0 {
+				entry[i] = float32(math.NaN())
+				continue
+			}
+
+			value, err := strconv.ParseFloat(field, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bp7: row %d, column %q: %v", r, columns[column], err)
+			}
+
+			entry[i] = float32(value)
+		}
+
+		dataset.Features = append(dataset.Features, entry)
+		labels[r] = strings.TrimSpace(record[target])
+
+		if len(categories) > 0 {
+			values := make([]string, len(categories))
+			for i, column := range categories {
+				values[i] = strings.TrimSpace(record[column])
+			}
+
+			dataset.Categorical = append(dataset.Categorical, values)
+		}
+	}
+
+	dataset.ClassLabels = labelVocabulary(labels)
+	dataset.Targets = make([]int, len(labels))
+
+	for i := 0; i < len(labels); i++ {
+		dataset.Targets[i] = indexOf(dataset.ClassLabels, labels[i])
+	}
+
+	if len(options.ClassLabels) > 0 {
+		if err := dataset.RemapClasses(options.ClassLabels); err != nil {
+			return nil, err
+		}
+	}
+
+	return dataset, nil
+}
+
+// Resolves the position of the target column from the options.
+func targetColumn(columns []string, options DatasetOptions) (int, error) {
+	if options.TargetColumn != "" {
+		index := indexOf(columns, options.TargetColumn)
+		if index < 0 {
+			return 0, fmt.Errorf("bp7: there is no target column %q", options.TargetColumn)
+		}
+
+		return index, nil
+	}
+
+	index := options.TargetIndex
+	if index < 0 {
+		index += len(columns)
+	}
+
+	if index < 0 || index >= len(columns) {
+		return 0, fmt.Errorf("bp7: the target index %d is out of %d columns", options.TargetIndex, len(columns))
+	}
+
+	return index, nil
+}
+
+// Builds the sorted vocabulary of the class labels. If every
+// label is a number the labels are sorted numerically, so
+// that the labels 0 and 1 keep the class indexes 0 and 1.
+// -Input labels: The label of each entry.
+// -Output: The distinct labels.
+func labelVocabulary(labels []string) []string {
+	seen := make(map[string]bool)
+	vocabulary := make([]string, 0)
+
+	numeric := true
+
+	for _, label := range labels {
+		if seen[label] {
+			continue
+		}
+
+		seen[label] = true
+		vocabulary = append(vocabulary, label)
+
+		if _, err := strconv.ParseFloat(label, 64); err != nil {
+			numeric = false
+		}
+	}
+
+	sort.Slice(vocabulary, func(a, b int) bool {
+		if numeric {
+			x, _ := strconv.ParseFloat(vocabulary[a], 64)
+			y, _ := strconv.ParseFloat(vocabulary[b], 64)
+
+			return x < y
+		}
+
+		return vocabulary[a] < vocabulary[b]
+	})
+
+	return vocabulary
+}
+
+// Returns the position of a value, or -1 if it is missing.
+func indexOf(values []string, value string) int {
+	for i := 0; i < len(values); i++ {
+		if values[i] == value {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,406 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+)
+
+// A source of training rows which yields them lazily in
+// mini-batches, so that the whole data set does not have
+// to fit in memory. Like in the training set, the last
+// value of each row is the class index.
+type DataSource interface {
+	// Returns the next mini-batch of rows. At the end of the
+	// data it returns io.EOF.
+	Next() ([][]float32, error)
+	// Rewinds the source to its first row, so that the next
+	// epoch can start.
+	Reset() error
+}
+
+// A data source which reads a numeric csv file
+// sequentially, like the files of CreateDataset. It is
+// created by CreateCSVSource.
+type CSVSource struct {
+	// How many rows each mini-batch has.
+	size   int
+	file   *os.File
+	reader *csv.Reader
+	line   int
+}
+
+// A data source over rows which are already in memory.
+type SliceSource struct {
+	Rows      [][]float32
+	BatchSize int
+
+	position int
+}
+
+// A data source which approximately shuffles the rows of
+// another source. It keeps a buffer of rows and yields a
+// random row of the buffer each time, which it replaces
+// with the next row of the source. The larger the buffer,
+// the closer the order is to a full shuffle. It is created
+// by CreateShuffleSource.
+type ShuffleSource struct {
+	source     DataSource
+	bufferSize int
+	// How many rows each mini-batch has.
+	size int
+
+	random  *rand.Rand
+	buffer  [][]float32
+	pending [][]float32
+	done    bool
+}
+
+// How the shared training loop of TrainSource runs.
+type trainingLoop struct {
+	// Whether the error of each epoch is not printed, like in
+	// the folds of a cross-validation, which train in parallel.
+	quiet bool
+	// Returns the loss weight of the row at an index of the
+	// epoch, like the sample weights of TrainWeighted. Without
+	// it every row weighs one.
+	rowWeight func(index int) float32
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Reads the next mini-batch of rows of the file.
+// -Output: The rows, or io.EOF at the end of the file.
+func (s *CSVSource) Next() ([][]float32, error) {
+	batch := make([][]float32, 0, batchSize(s.size))
+
+	for len(batch) < batchSize(s.size) {
+		record, err := s.reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.line++
+
+		row := make([]float32, len(record))
+
+		for i := 0; i < len(record); i++ {
+			value, err := strconv.ParseFloat(record[i], 32)
+			if err != nil {
+				return nil, fmt.Errorf("bp7: line %d: %v", s.line, err)
+			}
+
+			row[i] = float32(value)
+		}
+
+		batch = append(batch, row)
+	}
+
+	if len(batch) == 0 {
+		return nil, io.EOF
+	}
+
+	return batch, nil
+}
+
+// Rewinds the file to its first row.
+func (s *CSVSource) Reset() error {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s.reader = csv.NewReader(s.file)
+	s.reader.ReuseRecord = true
+	s.line = 0
+
+	return nil
+}
+
+// Closes the file of the source.
+func (s *CSVSource) Close() error {
+	return s.file.Close()
+}
+
+// Returns the next mini-batch of rows.
+// -Output: The rows, or io.EOF after the last row.
+func (s *SliceSource) Next() ([][]float32, error) {
+	if s.position >= len(s.Rows) {
+		return nil, io.EOF
+	}
+
+	end := s.position + batchSize(s.BatchSize)
+	if end > len(s.Rows) {
+		end = len(s.Rows)
+	}
+
+	batch := s.Rows[s.position:end]
+	s.position = end
+
+	return batch, nil
+}
+
+// Rewinds the source to its first row.
+func (s *SliceSource) Reset() error {
+	s.position = 0
+
+	return nil
+}
+
+// Returns the next mini-batch of shuffled rows.
+// -Output: The rows, or io.EOF when both the source and
+// the buffer are exhausted.
+func (s *ShuffleSource) Next() ([][]float32, error) {
+	batch := make([][]float32, 0, batchSize(s.size))
+
+	for len(batch) < batchSize(s.size) {
+		if err := s.fill(); err != nil {
+			return nil, err
+		}
+
+		if len(s.buffer) == 0 {
+			break
+		}
+
+		i := s.random.Intn(len(s.buffer))
+		batch = append(batch, s.buffer[i])
+
+		last := len(s.buffer) - 1
+		s.buffer[i] = s.buffer[last]
+		s.buffer = s.buffer[:last]
+	}
+
+	if len(batch) == 0 {
+		return nil, io.EOF
+	}
+
+	return batch, nil
+}
+
+// Tops the buffer up from the source.
+func (s *ShuffleSource) fill() error {
+	for len(s.buffer) < batchSize(s.bufferSize) && !s.done {
+		if len(s.pending) == 0 {
+			batch, err := s.source.Next()
+			if err == io.EOF {
+				s.done = true
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+
+			s.pending = batch
+		}
+
+		s.buffer = append(s.buffer, s.pending[0])
+		s.pending = s.pending[1:]
+	}
+
+	return nil
+}
+
+// Rewinds the wrapped source. The shuffle continues from
+// the same random state, so every epoch has a new order.
+func (s *ShuffleSource) Reset() error {
+	s.buffer = s.buffer[:0]
+	s.pending = nil
+	s.done = false
+
+	return s.source.Reset()
+}
+
+// Trains a network with the rows of a data source. Every
+// epoch reads the source from its first row to its end.
+// Train and TrainWeighted run the same loop over the rows of
+// a SliceSource.
+// -Input source: The source of the training rows.
+// -Input learningRate: The weight learning adaptation.
+// -Input epochs: How many iterations does the training have.
+// -Input outputCount: How many classification categories exist
+// for the given training set (this should match the output neurons).
+func (n *Network) TrainSource(source DataSource, learningRate float32, epochs int, outputCount int) error {
+	return n.trainLoop(source, learningRate, epochs, outputCount, trainingLoop{})
+}
+
+// Runs the epochs of a training over the rows of a data
+// source. Every training path goes through this loop.
+// -Input source: The source of the training rows.
+// -Input learningRate: The weight learning adaptation.
+// -Input epochs: How many iterations does the training have.
+// -Input outputCount: How many classification categories exist.
+// -Input loop: How the loop runs.
+func (n *Network) trainLoop(source DataSource, learningRate float32, epochs int, outputCount int, loop trainingLoop) error {
+	if len(n.HiddenLayer.Neurons) == 0 || len(n.OutputLayer.Neurons) == 0 {
+		return ErrUninitializedNetwork
+	}
+
+	if outputCount != len(n.OutputLayer.Neurons) {
+		return fmt.Errorf("bp7: the training has %d classes but the network has %d outputs", outputCount, len(n.OutputLayer.Neurons))
+	}
+
+	samples := 0
+
+	for i := 0; i < epochs; i++ {
+		if err := source.Reset(); err != nil {
+			return err
+		}
+
+		var sumError float32 = 0.0
+		samples = 0
+
+		for {
+			batch, err := source.Next()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+
+			for j, row := range batch {
+				rate := learningRate
+				if loop.rowWeight != nil {
+					rate *= loop.rowWeight(samples + j)
+				}
+
+				rowError, err := n.trainRow(row, rate, outputCount)
+				if err != nil {
+					return err
+				}
+
+				sumError += rowError
+			}
+
+			samples += len(batch)
+		}
+
+		if !loop.quiet {
+			fmt.Printf("+Epoch: %d, Learning rate: %.2f, Error: %.2f", i, learningRate, sumError)
+			fmt.Println()
+		}
+	}
+
+	n.recordTraining(samples, learningRate, epochs)
+
+	return nil
+}
+
+// Trains the network with one row, like an iteration of Train.
+// -Input row: The training row, ending with its class index.
+// -Input learningRate: The weight learning adaptation.
+// -Input outputCount: How many classification categories exist.
+// -Output: The squared error of the row.
+func (n *Network) trainRow(row []float32, learningRate float32, outputCount int) (float32, error) {
+	row, outputs := n.trainingForward(n.preprocess(row))
+
+	class := int(row[len(row) - 1])
+	if class < 0 || class >= outputCount {
+		return 0, fmt.Errorf("bp7: class %d is out of %d classes", class, outputCount)
+	}
+
+	expected := make([]float32, outputCount)
+	expected[class] = 1
+
+	var error float32 = 0.0
+	for k := 0; k < len(expected); k++ {
+		error += float32(math.Pow(float64(expected[k] - outputs[k]), 2))
+	}
+
+	n.backPropagate(expected)
+	n.updateWeights(row, learningRate * n.classWeight(class))
+
+	return error, nil
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Opens a numeric csv file as a data source.
+// -Input filePath: The path of the file.
+// -Input batchSize: How many rows each mini-batch has.
+// -Output: The source. It must be closed after the training.
+func CreateCSVSource(filePath string, batchSize int) (*CSVSource, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &CSVSource{size: batchSize, file: file}
+
+	if err := source.Reset(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// Returns a batch or a buffer size, which is at least one.
+func batchSize(size int) int {
+	if size < 1 {
+		return 1
+	}
+
+	return size
+}
+
+// Creates a data source over rows which are already in memory.
+// -Input rows: The training rows.
+// -Input batchSize: How many rows each mini-batch has.
+// -Output: The source.
+func CreateSliceSource(rows [][]float32, batchSize int) *SliceSource {
+	return &SliceSource{Rows: rows, BatchSize: batchSize}
+}
+
+// Wraps a data source with a shuffle buffer.
+// -Input source: The wrapped source.
+// -Input bufferSize: How many rows the buffer holds.
+// -Input batchSize: How many rows each mini-batch has.
+// -Input seed: The seed of the shuffle.
+// -Output: The shuffling source.
+func CreateShuffleSource(source DataSource, bufferSize int, batchSize int, seed int64) *ShuffleSource {
+	return &ShuffleSource{
+		source:     source,
+		bufferSize: bufferSize,
+		size:       batchSize,
+		random:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Trains a network with the rows of a data source.
+// -Input n: A network.
+// -Input source: The source of the training rows.
+// -Input learningRate: The weight learning adaptation.
+// -Input epochs: How many iterations does the training have.
+// -Input outputCount: How many classification categories exist
+// for the given training set (this should match the output neurons).
+func TrainSource(n *Network, source DataSource, learningRate float32, epochs int, outputCount int) error {
+	return n.TrainSource(source, learningRate, epochs, outputCount)
+}
@@ -0,0 +1,845 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// How a one-hot encoder treats a category which it did
+// not see during fitting.
+type UnknownCategory string
+
+const (
+	// Encodes an unknown category as all zeros.
+	IgnoreUnknown UnknownCategory = "ignore"
+	// Fails on an unknown category.
+	ErrorOnUnknown UnknownCategory = "error"
+	// Encodes an unknown category into an extra column.
+	ExtraColumnForUnknown UnknownCategory = "extra"
+)
+
+// The file names of the categorical encoding and of the
+// class labels when they are extracted together with the
+// layer weights.
+const (
+	encodingFileName = "encoding.csv"
+	labelsFileName   = "labels.csv"
+)
+
+// The default smoothing of the target encoder: how many
+// entries a category needs in order to weigh as much as
+// the prior.
+const defaultTargetSmoothing = 10
+
+// An encoder turns the string values of a categorical
+// column into numeric features. It is fitted on the values
+// of the training entries and, for the target encoder, on
+// their class indexes.
+type Encoder interface {
+	// Fits the encoder on the values of a column.
+	// -Input values: The value of each entry.
+	// -Input targets: The class index of each entry.
+	Fit(values []string, targets []int) error
+	// Encodes a value into Width() features.
+	Encode(value string) ([]float32, error)
+	// Returns how many features the encoder produces.
+	Width() int
+	// Returns the names of the produced features.
+	FeatureNames(column string) []string
+
+	component
+}
+
+// Encodes a category as a vector with a one at the index
+// of the category and zeros everywhere else.
+type OneHotEncoder struct {
+	Categories []string
+	Unknown    UnknownCategory
+}
+
+// Encodes a category as its index. If Categories is set
+// before fitting, its order is kept, so that the indexes
+// follow the natural order of the categories. An unknown
+// category is encoded as -1.
+type OrdinalEncoder struct {
+	Categories []string
+}
+
+// Encodes a category as the fraction of the training
+// entries which have it. An unknown category is encoded
+// as zero.
+type FrequencyEncoder struct {
+	Categories  []string
+	Frequencies []float32
+}
+
+// Encodes a category as the smoothed fraction of its
+// training entries which belong to each class. A binary
+// target produces one feature, the fraction of class 1.
+// An unknown category is encoded as the prior.
+type TargetEncoder struct {
+	// How many entries a category needs in order to weigh as
+	// much as the prior. Zero means 10.
+	Smoothing  float32
+	Prior      []float32
+	Categories []string
+	Means      [][]float32
+}
+
+// Encodes a category into a fixed count of features by
+// hashing it. It needs no fitting and handles any unknown
+// category, at the cost of possible collisions.
+type HashingEncoder struct {
+	Features int
+}
+
+// A categorical column and the encoder of its values.
+type ColumnEncoder struct {
+	Column  string
+	Encoder Encoder
+}
+
+// The encoders of the categorical columns of a dataset.
+// The encoded features are appended after the numeric
+// features, in the order of the columns.
+type CategoricalEncoding struct {
+	Columns []ColumnEncoder
+}
+
+// Maps the string class labels to the class indexes that
+// Train expects and back.
+type LabelEncoder struct {
+	Labels []string
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Fits the one-hot encoder on the sorted distinct values.
+func (e *OneHotEncoder) Fit(values []string, targets []int) error {
+	e.Categories = distinctValues(values)
+
+	if e.Unknown == "" {
+		e.Unknown = IgnoreUnknown
+	}
+
+	return nil
+}
+
+// Encodes a value as a one-hot vector.
+func (e *OneHotEncoder) Encode(value string) ([]float32, error) {
+	encoded := make([]float32, e.Width())
+
+	index := indexOf(e.Categories, value)
+
+	switch {
+	case index >= 0:
+		encoded[index] = 1
+	case e.Unknown == ErrorOnUnknown:
+		return nil, fmt.Errorf("bp7: unknown category %q", value)
+	case e.Unknown == ExtraColumnForUnknown:
+		encoded[len(encoded) - 1] = 1
+	}
+
+	return encoded, nil
+}
+
+// Returns the category count, plus one for the extra column.
+func (e *OneHotEncoder) Width() int {
+	if e.Unknown == ExtraColumnForUnknown {
+		return len(e.Categories) + 1
+	}
+
+	return len(e.Categories)
+}
+
+// Returns column=category for each category.
+func (e *OneHotEncoder) FeatureNames(column string) []string {
+	names := make([]string, 0, e.Width())
+
+	for _, category := range e.Categories {
+		names = append(names, column + "=" + category)
+	}
+
+	if e.Unknown == ExtraColumnForUnknown {
+		names = append(names, column + "=<unknown>")
+	}
+
+	return names
+}
+
+func (e *OneHotEncoder) records() [][]string {
+	return [][]string{
+		{"unknown", string(e.Unknown)},
+		append([]string{"categories"}, e.Categories...),
+	}
+}
+
+func (e *OneHotEncoder) parseRecords(records [][]string) error {
+	for _, record := range records {
+		switch record[0] {
+		case "unknown":
+			e.Unknown = UnknownCategory(record[1])
+		case "categories":
+			e.Categories = record[1:]
+		default:
+			return fmt.Errorf("bp7: unknown one-hot encoder record %q", record[0])
+		}
+	}
+
+	return nil
+}
+
+// Fits the ordinal encoder. Preset categories are kept,
+// otherwise the sorted distinct values are used.
+func (e *OrdinalEncoder) Fit(values []string, targets []int) error {
+	if len(e.Categories) == 0 {
+		e.Categories = distinctValues(values)
+	}
+
+	return nil
+}
+
+// Encodes a value as its category index.
+func (e *OrdinalEncoder) Encode(value string) ([]float32, error) {
+	return []float32{float32(indexOf(e.Categories, value))}, nil
+}
+
+// Returns one.
+func (e *OrdinalEncoder) Width() int {
+	return 1
+}
+
+// Returns the column name.
+func (e *OrdinalEncoder) FeatureNames(column string) []string {
+	return []string{column}
+}
+
+func (e *OrdinalEncoder) records() [][]string {
+	return [][]string{append([]string{"categories"}, e.Categories...)}
+}
+
+func (e *OrdinalEncoder) parseRecords(records [][]string) error {
+	for _, record := range records {
+		if record[0] != "categories" {
+			return fmt.Errorf("bp7: unknown ordinal encoder record %q", record[0])
+		}
+
+		e.Categories = record[1:]
+	}
+
+	return nil
+}
+
+// Fits the frequency of each distinct value.
+func (e *FrequencyEncoder) Fit(values []string, targets []int) error {
+	if len(values) == 0 {
+		return errors.New("bp7: there are no values to fit the encoder on")
+	}
+
+	e.Categories = distinctValues(values)
+	e.Frequencies = make([]float32, len(e.Categories))
+
+	for _, value := range values {
+		e.Frequencies[indexOf(e.Categories, value)]++
+	}
+
+	for i := 0; i < len(e.Frequencies); i++ {
+		e.Frequencies[i] /= float32(len(values))
+	}
+
+	return nil
+}
+
+// Encodes a value as its training frequency.
+func (e *FrequencyEncoder) Encode(value string) ([]float32, error) {
+	index := indexOf(e.Categories, value)
+	if index < 0 {
+		return []float32{0}, nil
+	}
+
+	return []float32{e.Frequencies[index]}, nil
+}
+
+// Returns one.
+func (e *FrequencyEncoder) Width() int {
+	return 1
+}
+
+// Returns column_frequency.
+func (e *FrequencyEncoder) FeatureNames(column string) []string {
+	return []string{column + "_frequency"}
+}
+
+func (e *FrequencyEncoder) records() [][]string {
+	return [][]string{
+		append([]string{"categories"}, e.Categories...),
+		append([]string{"frequencies"}, formatFloats(e.Frequencies)...),
+	}
+}
+
+func (e *FrequencyEncoder) parseRecords(records [][]string) error {
+	for _, record := range records {
+		var err error
+
+		switch record[0] {
+		case "categories":
+			e.Categories = record[1:]
+		case "frequencies":
+			e.Frequencies, err = parseFloats(record[1:])
+		default:
+			err = fmt.Errorf("bp7: unknown frequency encoder record %q", record[0])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fits the smoothed class fractions of each distinct value.
+func (e *TargetEncoder) Fit(values []string, targets []int) error {
+	if len(values) == 0 || len(values) != len(targets) {
+		return errors.New("bp7: the target encoder needs one class index per value")
+	}
+
+	if e.Smoothing == 0 {
+		e.Smoothing = defaultTargetSmoothing
+	}
+
+	classes := 0
+	for _, target := range targets {
+		if target + 1 > classes {
+			classes = target + 1
+		}
+	}
+
+	e.Categories = distinctValues(values)
+
+	counts := make([][]float32, len(e.Categories))
+	totals := make([]float32, len(e.Categories))
+	prior := make([]float32, classes)
+
+	for i := 0; i < len(counts); i++ {
+		counts[i] = make([]float32, classes)
+	}
+
+	for i, value := range values {
+		index := indexOf(e.Categories, value)
+
+		counts[index][targets[i]]++
+		totals[index]++
+		prior[targets[i]] += 1 / float32(len(values))
+	}
+
+	// A binary target only keeps the fraction of class 1,
+	// since the fraction of class 0 is its complement.
+	from := 0
+	if classes == 2 {
+		from = 1
+	}
+
+	e.Prior = prior[from:]
+	e.Means = make([][]float32, len(e.Categories))
+
+	for i := 0; i < len(e.Categories); i++ {
+		e.Means[i] = make([]float32, classes - from)
+
+		for k := from; k < classes; k++ {
+			e.Means[i][k - from] = (counts[i][k] + e.Smoothing * prior[k]) / (totals[i] + e.Smoothing)
+		}
+	}
+
+	return nil
+}
+
+// Encodes a value as its smoothed class fractions.
+func (e *TargetEncoder) Encode(value string) ([]float32, error) {
+	encoded := make([]float32, len(e.Prior))
+
+	index := indexOf(e.Categories, value)
+	if index < 0 {
+		copy(encoded, e.Prior)
+	} else {
+		copy(encoded, e.Means[index])
+	}
+
+	return encoded, nil
+}
+
+// Returns one per class, or one for a binary target.
+func (e *TargetEncoder) Width() int {
+	return len(e.Prior)
+}
+
+// Returns column_target or column_target<class>.
+func (e *TargetEncoder) FeatureNames(column string) []string {
+	if len(e.Prior) == 1 {
+		return []string{column + "_target"}
+	}
+
+	names := make([]string, len(e.Prior))
+	for k := 0; k < len(names); k++ {
+		names[k] = column + "_target" + strconv.Itoa(k)
+	}
+
+	return names
+}
+
+func (e *TargetEncoder) records() [][]string {
+	records := [][]string{
+		{"smoothing", fmt.Sprintf("%g", e.Smoothing)},
+		append([]string{"prior"}, formatFloats(e.Prior)...),
+	}
+
+	for i, category := range e.Categories {
+		records = append(records, append([]string{"category", category}, formatFloats(e.Means[i])...))
+	}
+
+	return records
+}
+
+func (e *TargetEncoder) parseRecords(records [][]string) error {
+	for _, record := range records {
+		var err error
+
+		switch record[0] {
+		case "smoothing":
+			var smoothing []float32
+			smoothing, err = parseFloats(record[1:2])
+			if err == nil {
+				e.Smoothing = smoothing[0]
+			}
+		case "prior":
+			e.Prior, err = parseFloats(record[1:])
+		case "category":
+			var means []float32
+			means, err = parseFloats(record[2:])
+			e.Categories = append(e.Categories, record[1])
+			e.Means = append(e.Means, means)
+		default:
+			err = fmt.Errorf("bp7: unknown target encoder record %q", record[0])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The hashing encoder has nothing to fit. It only checks
+// that it has at least one feature.
+func (e *HashingEncoder) Fit(values []string, targets []int) error {
+	if e.Features < 1 {
+		return errors.New("bp7: the hashing encoder needs at least one feature")
+	}
+
+	return nil
+}
+
+// Encodes a value as a one at the index of its FNV-1a hash.
+func (e *HashingEncoder) Encode(value string) ([]float32, error) {
+	encoded := make([]float32, e.Features)
+
+	hash := fnv.New32a()
+	hash.Write([]byte(value))
+
+	encoded[hash.Sum32() % uint32(e.Features)] = 1
+
+	return encoded, nil
+}
+
+// Returns the feature count.
+func (e *HashingEncoder) Width() int {
+	return e.Features
+}
+
+// Returns column#0, column#1, etc.
+func (e *HashingEncoder) FeatureNames(column string) []string {
+	names := make([]string, e.Features)
+	for i := 0; i < len(names); i++ {
+		names[i] = column + "#" + strconv.Itoa(i)
+	}
+
+	return names
+}
+
+func (e *HashingEncoder) records() [][]string {
+	return [][]string{{"features", strconv.Itoa(e.Features)}}
+}
+
+func (e *HashingEncoder) parseRecords(records [][]string) error {
+	for _, record := range records {
+		if record[0] != "features" {
+			return fmt.Errorf("bp7: unknown hashing encoder record %q", record[0])
+		}
+
+		features, err := strconv.Atoi(record[1])
+		if err != nil {
+			return err
+		}
+
+		e.Features = features
+	}
+
+	return nil
+}
+
+// Fits the encoder of each column on the categorical values
+// and the targets of a training dataset.
+// -Input d: The training dataset.
+func (c *CategoricalEncoding) Fit(d *Dataset) error {
+	for _, column := range c.Columns {
+		index := indexOf(d.CategoricalNames, column.Column)
+		if index < 0 {
+			return fmt.Errorf("bp7: there is no categorical column %q", column.Column)
+		}
+
+		values := make([]string, len(d.Categorical))
+		for i := 0; i < len(values); i++ {
+			values[i] = d.Categorical[i][index]
+		}
+
+		if err := column.Encoder.Fit(values, d.Targets); err != nil {
+			return fmt.Errorf("bp7: column %q: %v", column.Column, err)
+		}
+	}
+
+	return nil
+}
+
+// Encodes the categorical values of an entry.
+// -Input values: The value of each encoded column, in the
+// order of the columns.
+// -Output: The encoded features.
+func (c *CategoricalEncoding) Encode(values []string) ([]float32, error) {
+	if len(values) != len(c.Columns) {
+		return nil, fmt.Errorf("bp7: %d categorical values for %d columns", len(values), len(c.Columns))
+	}
+
+	encoded := make([]float32, 0, c.Width())
+
+	for i, column := range c.Columns {
+		features, err := column.Encoder.Encode(values[i])
+		if err != nil {
+			return nil, fmt.Errorf("bp7: column %q: %v", column.Column, err)
+		}
+
+		encoded = append(encoded, features...)
+	}
+
+	return encoded, nil
+}
+
+// Returns how many features the encoding produces.
+func (c *CategoricalEncoding) Width() int {
+	width := 0
+
+	for _, column := range c.Columns {
+		width += column.Encoder.Width()
+	}
+
+	return width
+}
+
+// Encodes the categorical columns of a dataset and appends
+// the encoded features after the numeric ones. The encoded
+// columns are removed from the categorical columns.
+// -Input d: The dataset to encode.
+// -Output: A new dataset with the encoded features.
+func (c *CategoricalEncoding) Apply(d *Dataset) (*Dataset, error) {
+	positions := make([]int, len(c.Columns))
+
+	for i, column := range c.Columns {
+		positions[i] = indexOf(d.CategoricalNames, column.Column)
+		if positions[i] < 0 {
+			return nil, fmt.Errorf("bp7: there is no categorical column %q", column.Column)
+		}
+	}
+
+	encoded := &Dataset{
+		FeatureNames: append([]string(nil), d.FeatureNames...),
+		TargetName:   d.TargetName,
+		Features:     make([][]float32, len(d.Features)),
+		Targets:      d.Targets,
+		ClassLabels:  d.ClassLabels,
+		Columns:      d.Columns,
+	}
+
+	for _, column := range c.Columns {
+		encoded.FeatureNames = append(encoded.FeatureNames, column.Encoder.FeatureNames(column.Column)...)
+	}
+
+	kept := make([]int, 0)
+
+	for i, name := range d.CategoricalNames {
+		if indexOf(columnNames(c.Columns), name) < 0 {
+			kept = append(kept, i)
+			encoded.CategoricalNames = append(encoded.CategoricalNames, name)
+		}
+	}
+
+	for r := 0; r < len(d.Features); r++ {
+		values := make([]string, len(positions))
+		for i, position := range positions {
+			values[i] = d.Categorical[r][position]
+		}
+
+		features, err := c.Encode(values)
+		if err != nil {
+			return nil, fmt.Errorf("bp7: row %d: %v", r, err)
+		}
+
+		encoded.Features[r] = append(append([]float32(nil), d.Features[r]...), features...)
+
+		if len(kept) > 0 {
+			remaining := make([]string, len(kept))
+			for i, position := range kept {
+				remaining[i] = d.Categorical[r][position]
+			}
+
+			encoded.Categorical = append(encoded.Categorical, remaining)
+		}
+	}
+
+	return encoded, nil
+}
+
+// Converts the encoding into csv records. Each column
+// starts with a column record naming its encoder.
+func (c *CategoricalEncoding) records() [][]string {
+	records := make([][]string, 0)
+
+	for _, column := range c.Columns {
+		records = append(records, []string{"column", column.Column, encoderKind(column.Encoder)})
+		records = append(records, column.Encoder.records()...)
+	}
+
+	return records
+}
+
+// Checks that the fitted values of each encoder match its
+// categories.
+func (c *CategoricalEncoding) check() error {
+	for _, column := range c.Columns {
+		var err error
+
+		switch e := column.Encoder.(type) {
+		case nil:
+			err = errors.New("has no encoder")
+		case *FrequencyEncoder:
+			if len(e.Frequencies) != len(e.Categories) {
+				err = fmt.Errorf("has %d frequencies for %d categories", len(e.Frequencies), len(e.Categories))
+			}
+		case *TargetEncoder:
+			if len(e.Means) != len(e.Categories) {
+				err = fmt.Errorf("has %d means for %d categories", len(e.Means), len(e.Categories))
+			}
+		case *HashingEncoder:
+			if e.Features < 1 {
+				err = errors.New("needs at least one feature")
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("bp7: the encoder of the column %q %v", column.Column, err)
+		}
+	}
+
+	return nil
+}
+
+// Restores the encoding from its csv records.
+func (c *CategoricalEncoding) parseRecords(records [][]string) error {
+	c.Columns = nil
+
+	for start := 0; start < len(records); {
+		record := records[start]
+		if len(record) != 3 || record[0] != "column" {
+			return fmt.Errorf("bp7: invalid encoding record %v", record)
+		}
+
+		encoder, err := createEncoder(record[2])
+		if err != nil {
+			return err
+		}
+
+		end := start + 1
+		for end < len(records) && records[end][0] != "column" {
+			if len(records[end]) < 2 {
+				return fmt.Errorf("bp7: invalid encoding record %v", records[end])
+			}
+
+			end++
+		}
+
+		if err := encoder.parseRecords(records[start + 1:end]); err != nil {
+			return err
+		}
+
+		c.Columns = append(c.Columns, ColumnEncoder{Column: record[1], Encoder: encoder})
+
+		start = end
+	}
+
+	return nil
+}
+
+// Returns the class index of a label.
+// -Input label: The class label.
+// -Output: The class index.
+func (l *LabelEncoder) Encode(label string) (int, error) {
+	index := indexOf(l.Labels, label)
+	if index < 0 {
+		return 0, fmt.Errorf("bp7: unknown class label %q", label)
+	}
+
+	return index, nil
+}
+
+// Returns the label of a class index.
+// -Input index: The class index.
+// -Output: The class label.
+func (l *LabelEncoder) Decode(index int) string {
+	if index < 0 || index >= len(l.Labels) {
+		return strconv.Itoa(index)
+	}
+
+	return l.Labels[index]
+}
+
+func (l *LabelEncoder) records() [][]string {
+	return [][]string{append([]string{"labels"}, l.Labels...)}
+}
+
+func (l *LabelEncoder) parseRecords(records [][]string) error {
+	for _, record := range records {
+		if record[0] != "labels" {
+			return fmt.Errorf("bp7: unknown label record %q", record[0])
+		}
+
+		l.Labels = record[1:]
+	}
+
+	return nil
+}
+
+// Given an input row, it predicts the label of the output
+// categorization. Without class labels the class index is
+// returned as text.
+// -Input row: An entry to predict the category.
+// -Output: The predicted class label.
+func (n *Network) PredictLabel(row []float32) string {
+	labels := n.Labels
+	if labels == nil {
+		labels = &LabelEncoder{}
+	}
+
+	return labels.Decode(argmax(n.infer(row)))
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Fits a label encoder on the sorted distinct labels. Numeric
+// labels are sorted numerically, like in LoadDataset.
+// -Input labels: The class label of each entry.
+// -Output: The label encoder.
+func FitLabelEncoder(labels []string) *LabelEncoder {
+	return &LabelEncoder{Labels: labelVocabulary(labels)}
+}
+
+// Given an input row, it predicts the label of the output
+// categorization.
+// -Input n: A network.
+// -Input row: An entry to predict the category.
+// -Output: The predicted class label.
+func PredictLabel(n *Network, row []float32) string {
+	return n.PredictLabel(row)
+}
+
+// Returns the name under which an encoder is persisted.
+func encoderKind(e Encoder) string {
+	switch e.(type) {
+	case *OneHotEncoder:
+		return "onehot"
+	case *OrdinalEncoder:
+		return "ordinal"
+	case *FrequencyEncoder:
+		return "frequency"
+	case *TargetEncoder:
+		return "target"
+	case *HashingEncoder:
+		return "hashing"
+	}
+
+	return ""
+}
+
+// Creates an empty encoder from its persisted name.
+func createEncoder(kind string) (Encoder, error) {
+	switch kind {
+	case "onehot":
+		return &OneHotEncoder{}, nil
+	case "ordinal":
+		return &OrdinalEncoder{}, nil
+	case "frequency":
+		return &FrequencyEncoder{}, nil
+	case "target":
+		return &TargetEncoder{}, nil
+	case "hashing":
+		return &HashingEncoder{}, nil
+	}
+
+	return nil, fmt.Errorf("bp7: unknown encoder %q", kind)
+}
+
+// Returns the sorted distinct values.
+func distinctValues(values []string) []string {
+	seen := make(map[string]bool)
+	distinct := make([]string, 0)
+
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			distinct = append(distinct, value)
+		}
+	}
+
+	sort.Strings(distinct)
+
+	return distinct
+}
+
+// Returns the column names of the column encoders.
+func columnNames(columns []ColumnEncoder) []string {
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Column
+	}
+
+	return names
+}
@@ -0,0 +1,398 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The smallest probability used by the log loss, so that
+// a confident wrong prediction does not make it infinite.
+const logLossEpsilon = 1e-15
+
+// The evaluation report of a classification network over
+// a labelled dataset. The confusion matrix is indexed as
+// [actual class][predicted class] and the per class slices
+// are indexed by the class index.
+type ClassificationReport struct {
+	Classes         int
+	Samples         int
+	ConfusionMatrix [][]int
+	Support         []int
+	Precision       []float32
+	Recall          []float32
+	F1              []float32
+
+	Accuracy          float32
+	MacroPrecision    float32
+	MacroRecall       float32
+	MacroF1           float32
+	WeightedPrecision float32
+	WeightedRecall    float32
+	WeightedF1        float32
+	BalancedAccuracy  float32
+	// The multiclass Matthews correlation coefficient, in [-1, 1].
+	MatthewsCorrelation float32
+	// The mean negative log likelihood of the actual class.
+	LogLoss float32
+
+	// True when there are exactly two classes. Only then the
+	// ROC-AUC and the PR-AUC are calculated, using the
+	// probability of class 1 as the score of the positive class.
+	Binary bool
+	ROCAUC float32
+	PRAUC  float32
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Evaluates the network over a labelled dataset. The last
+// value of each row is the class index, like in the
+// training set.
+// -Input dataSet: The rows to evaluate.
+// -Output: The classification report.
+func (n *Network) Evaluate(dataSet [][]float32) (ClassificationReport, error) {
+	probabilities, err := n.PredictProbabilitiesBatch(dataSet)
+	if err != nil {
+		return ClassificationReport{}, err
+	}
+
+	labels := make([]int, len(dataSet))
+
+	for i := 0; i < len(dataSet); i++ {
+		labels[i] = int(dataSet[i][len(dataSet[i]) - 1])
+	}
+
+	return EvaluatePredictions(labels, probabilities)
+}
+
+// Renders the report as a human readable table followed
+// by the aggregated metrics.
+func (r ClassificationReport) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Samples: %d, Classes: %d\n", r.Samples, r.Classes)
+	b.WriteString("Confusion matrix (rows: actual, columns: predicted):\n")
+
+	for i := 0; i < r.Classes; i++ {
+		for j := 0; j < r.Classes; j++ {
+			fmt.Fprintf(&b, "%8d", r.ConfusionMatrix[i][j])
+		}
+
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "%-8s %10s %10s %10s %10s\n", "Class", "Precision", "Recall", "F1", "Support")
+
+	for i := 0; i < r.Classes; i++ {
+		fmt.Fprintf(&b, "%-8d %10.4f %10.4f %10.4f %10d\n", i, r.Precision[i], r.Recall[i], r.F1[i], r.Support[i])
+	}
+
+	fmt.Fprintf(&b, "%-8s %10.4f %10.4f %10.4f\n", "Macro", r.MacroPrecision, r.MacroRecall, r.MacroF1)
+	fmt.Fprintf(&b, "%-8s %10.4f %10.4f %10.4f\n", "Weighted", r.WeightedPrecision, r.WeightedRecall, r.WeightedF1)
+	fmt.Fprintf(&b, "Accuracy: %.4f\n", r.Accuracy)
+	fmt.Fprintf(&b, "Balanced accuracy: %.4f\n", r.BalancedAccuracy)
+	fmt.Fprintf(&b, "Matthews correlation: %.4f\n", r.MatthewsCorrelation)
+	fmt.Fprintf(&b, "Log loss: %.4f", r.LogLoss)
+
+	if r.Binary {
+		fmt.Fprintf(&b, "\nROC-AUC: %.4f\nPR-AUC: %.4f", r.ROCAUC, r.PRAUC)
+	}
+
+	return b.String()
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Evaluates a network over a labelled dataset. The last
+// value of each row is the class index, like in the
+// training set.
+// -Input n: A network.
+// -Input dataSet: The rows to evaluate.
+// -Output: The classification report.
+func Evaluate(n *Network, dataSet [][]float32) (ClassificationReport, error) {
+	return n.Evaluate(dataSet)
+}
+
+// Builds a classification report from already calculated
+// probabilities. The predicted class of an entry is the
+// index of its highest probability.
+// -Input labels: The actual class index of each entry.
+// -Input probabilities: The probability vector of each
+// entry. Every vector has one value per class.
+// -Output: The classification report.
+func EvaluatePredictions(labels []int, probabilities [][]float32) (ClassificationReport, error) {
+	report := ClassificationReport{}
+
+	if len(labels) == 0 {
+		return report, errors.New("bp7: there are no entries to evaluate")
+	}
+
+	if len(labels) != len(probabilities) {
+		return report, fmt.Errorf("bp7: %d labels but %d probability vectors", len(labels), len(probabilities))
+	}
+
+	classes := len(probabilities[0])
+
+	confusion := make([][]int, classes)
+	for i := 0; i < classes; i++ {
+		confusion[i] = make([]int, classes)
+	}
+
+	var logLoss float64 = 0.0
+
+	for i := 0; i < len(labels); i++ {
+		if len(probabilities[i]) != classes {
+			return report, fmt.Errorf("bp7: entry %d has %d probabilities, expected %d", i, len(probabilities[i]), classes)
+		}
+
+		if labels[i] < 0 || labels[i] >= classes {
+			return report, fmt.Errorf("bp7: entry %d has class %d but there are only %d classes", i, labels[i], classes)
+		}
+
+		confusion[labels[i]][argmax(probabilities[i])]++
+
+		p := math.Max(float64(probabilities[i][labels[i]]), logLossEpsilon)
+		logLoss -= math.Log(p)
+	}
+
+	report.Classes = classes
+	report.Samples = len(labels)
+	report.ConfusionMatrix = confusion
+	report.LogLoss = float32(logLoss / float64(len(labels)))
+
+	fillClassMetrics(&report)
+
+	if classes == 2 {
+		scores := make([]float32, len(labels))
+		positives := make([]bool, len(labels))
+
+		for i := 0; i < len(labels); i++ {
+			scores[i] = probabilities[i][1]
+			positives[i] = labels[i] == 1
+		}
+
+		report.Binary = true
+		report.ROCAUC = rocAUC(scores, positives)
+		report.PRAUC = prAUC(scores, positives)
+	}
+
+	return report, nil
+}
+
+// Calculates the per class and the aggregated metrics of
+// a report from its confusion matrix.
+// -Input report: A report with its confusion matrix set.
+func fillClassMetrics(report *ClassificationReport) {
+	classes := report.Classes
+	confusion := report.ConfusionMatrix
+	total := float64(report.Samples)
+
+	report.Support = make([]int, classes)
+	report.Precision = make([]float32, classes)
+	report.Recall = make([]float32, classes)
+	report.F1 = make([]float32, classes)
+
+	predicted := make([]int, classes)
+	correct := 0
+
+	for i := 0; i < classes; i++ {
+		for j := 0; j < classes; j++ {
+			report.Support[i] += confusion[i][j]
+			predicted[j] += confusion[i][j]
+		}
+
+		correct += confusion[i][i]
+	}
+
+	// The macro averages only count the classes that appear
+	// either in the actual or in the predicted classes.
+	present := 0
+	supported := 0
+
+	var macroPrecision, macroRecall, macroF1, balanced float64
+	var weightedPrecision, weightedRecall, weightedF1 float64
+
+	for i := 0; i < classes; i++ {
+		var precision, recall, f1 float64
+
+		if predicted[i] > 0 {
+			precision = float64(confusion[i][i]) / float64(predicted[i])
+		}
+
+		if report.Support[i] > 0 {
+			recall = float64(confusion[i][i]) / float64(report.Support[i])
+			balanced += recall
+			supported++
+		}
+
+		if precision + recall > 0 {
+			f1 = 2 * precision * recall / (precision + recall)
+		}
+
+		report.Precision[i] = float32(precision)
+		report.Recall[i] = float32(recall)
+		report.F1[i] = float32(f1)
+
+		if predicted[i] > 0 || report.Support[i] > 0 {
+			macroPrecision += precision
+			macroRecall += recall
+			macroF1 += f1
+			present++
+		}
+
+		weight := float64(report.Support[i]) / total
+		weightedPrecision += weight * precision
+		weightedRecall += weight * recall
+		weightedF1 += weight * f1
+	}
+
+	report.Accuracy = float32(float64(correct) / total)
+	report.MacroPrecision = float32(macroPrecision / float64(present))
+	report.MacroRecall = float32(macroRecall / float64(present))
+	report.MacroF1 = float32(macroF1 / float64(present))
+	report.WeightedPrecision = float32(weightedPrecision)
+	report.WeightedRecall = float32(weightedRecall)
+	report.WeightedF1 = float32(weightedF1)
+	report.BalancedAccuracy = float32(balanced / float64(supported))
+
+	// The multiclass generalization of the Matthews correlation
+	// coefficient (Gorodkin, 2004).
+	var truePredicted, predictedSquares, actualSquares float64
+
+	for i := 0; i < classes; i++ {
+		truePredicted += float64(predicted[i]) * float64(report.Support[i])
+		predictedSquares += float64(predicted[i]) * float64(predicted[i])
+		actualSquares += float64(report.Support[i]) * float64(report.Support[i])
+	}
+
+	denominator := math.Sqrt((total * total - predictedSquares) * (total * total - actualSquares))
+	if denominator > 0 {
+		report.MatthewsCorrelation = float32((float64(correct) * total - truePredicted) / denominator)
+	}
+}
+
+// Calculates the area under the ROC curve as the probability
+// that a random positive entry is scored higher than a random
+// negative one. Tied scores count as half.
+// -Input scores: The score of the positive class of each entry.
+// -Input positives: Whether each entry belongs to the positive class.
+// -Output: The ROC-AUC, or zero if one of the classes is missing.
+func rocAUC(scores []float32, positives []bool) float32 {
+	indexes := sortedByScore(scores)
+
+	// Each group of tied scores gets the average rank of the
+	// group. The ranks start from one at the lowest score.
+	var positiveRanks float64 = 0.0
+	positiveCount := 0
+
+	for start := len(indexes) - 1; start >= 0; {
+		end := start
+		for end > 0 && scores[indexes[end - 1]] == scores[indexes[start]] {
+			end--
+		}
+
+		// The positions are descending, so the lowest score
+		// has the rank one.
+		rank := float64(len(indexes) - start + len(indexes) - end) / 2
+
+		for k := end; k <= start; k++ {
+			if positives[indexes[k]] {
+				positiveRanks += rank
+				positiveCount++
+			}
+		}
+
+		start = end - 1
+	}
+
+	negativeCount := len(scores) - positiveCount
+	if positiveCount == 0 || negativeCount == 0 {
+		return 0
+	}
+
+	u := positiveRanks - float64(positiveCount * (positiveCount + 1)) / 2
+
+	return float32(u / float64(positiveCount * negativeCount))
+}
+
+// Calculates the area under the precision-recall curve as
+// the average precision: the precision at each distinct
+// threshold weighted by the recall gained at it.
+// -Input scores: The score of the positive class of each entry.
+// -Input positives: Whether each entry belongs to the positive class.
+// -Output: The PR-AUC, or zero if there are no positive entries.
+func prAUC(scores []float32, positives []bool) float32 {
+	indexes := sortedByScore(scores)
+
+	positiveCount := 0
+	for i := 0; i < len(positives); i++ {
+		if positives[i] {
+			positiveCount++
+		}
+	}
+
+	if positiveCount == 0 {
+		return 0
+	}
+
+	var average, previousRecall float64
+	truePositives := 0
+
+	for start := 0; start < len(indexes); {
+		end := start
+		for end < len(indexes) && scores[indexes[end]] == scores[indexes[start]] {
+			if positives[indexes[end]] {
+				truePositives++
+			}
+
+			end++
+		}
+
+		precision := float64(truePositives) / float64(end)
+		recall := float64(truePositives) / float64(positiveCount)
+
+		average += (recall - previousRecall) * precision
+		previousRecall = recall
+
+		start = end
+	}
+
+	return float32(average)
+}
+
+// Returns the entry indexes ordered by descending score.
+// -Input scores: The score of each entry.
+// -Output: The ordered indexes.
+func sortedByScore(scores []float32) []int {
+	indexes := make([]int, len(scores))
+	for i := 0; i < len(indexes); i++ {
+		indexes[i] = i
+	}
+
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return scores[indexes[a]] > scores[indexes[b]]
+	})
+
+	return indexes
+}
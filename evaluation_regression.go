@@ -0,0 +1,316 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// How many bins the residual histogram of a regression
+// report has.
+const residualHistogramBins = 10
+
+// The evaluation report of a model with continuous targets.
+// It is the regression counterpart of the classification
+// report.
+type RegressionReport struct {
+	Samples int
+	// How many predictors the model uses. It is needed for
+	// the adjusted R².
+	Predictors int
+
+	MAE  float32
+	MSE  float32
+	RMSE float32
+	R2   float32
+	// NaN when there are not more samples than predictors + 1.
+	AdjustedR2 float32
+	// The mean absolute percentage error as a fraction, i.e.
+	// 0.05 means 5%. The entries with a zero target are left
+	// out. NaN when every target is zero.
+	MAPE                float32
+	MedianAbsoluteError float32
+
+	// The summary of the residuals (target - prediction).
+	Residuals ResidualSummary
+}
+
+// The distribution of the residuals of a regression model.
+// The quantiles are linearly interpolated.
+type ResidualSummary struct {
+	Mean      float32
+	StdDev    float32
+	Min       float32
+	Q1        float32
+	Median    float32
+	Q3        float32
+	Max       float32
+	Histogram Histogram
+}
+
+// A histogram of equal width bins. The bin i counts the
+// values in [Edges[i], Edges[i+1]); the last bin also
+// includes its upper edge.
+type Histogram struct {
+	Edges  []float32
+	Counts []int
+}
+
+// Renders the report as human readable text.
+func (r RegressionReport) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Samples: %d, Predictors: %d\n", r.Samples, r.Predictors)
+	fmt.Fprintf(&b, "MAE: %.4f\n", r.MAE)
+	fmt.Fprintf(&b, "MSE: %.4f\n", r.MSE)
+	fmt.Fprintf(&b, "RMSE: %.4f\n", r.RMSE)
+	fmt.Fprintf(&b, "R²: %.4f\n", r.R2)
+	fmt.Fprintf(&b, "Adjusted R²: %.4f\n", r.AdjustedR2)
+	fmt.Fprintf(&b, "MAPE: %.2f%%\n", r.MAPE * 100)
+	fmt.Fprintf(&b, "Median absolute error: %.4f\n", r.MedianAbsoluteError)
+	b.WriteString(r.Residuals.String())
+
+	return b.String()
+}
+
+// Renders the residual summary as human readable text.
+func (s ResidualSummary) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Residuals: mean %.4f, std %.4f\n", s.Mean, s.StdDev)
+	fmt.Fprintf(&b, "%10s %10s %10s %10s %10s\n", "Min", "Q1", "Median", "Q3", "Max")
+	fmt.Fprintf(&b, "%10.4f %10.4f %10.4f %10.4f %10.4f\n", s.Min, s.Q1, s.Median, s.Q3, s.Max)
+	b.WriteString("Histogram:")
+
+	for i := 0; i < len(s.Histogram.Counts); i++ {
+		fmt.Fprintf(&b, "\n[%9.4f, %9.4f) %d", s.Histogram.Edges[i], s.Histogram.Edges[i + 1], s.Histogram.Counts[i])
+	}
+
+	return b.String()
+}
+
+// Evaluates the predictions of a model with continuous targets.
+// -Input targets: The actual value of each entry.
+// -Input predictions: The predicted value of each entry.
+// -Input predictors: How many input features the model uses.
+// -Output: The regression report.
+func EvaluateRegression(targets []float32, predictions []float32, predictors int) (RegressionReport, error) {
+	report := RegressionReport{}
+
+	if len(targets) == 0 {
+		return report, errors.New("bp7: there are no entries to evaluate")
+	}
+
+	if len(targets) != len(predictions) {
+		return report, fmt.Errorf("bp7: %d targets but %d predictions", len(targets), len(predictions))
+	}
+
+	count := float64(len(targets))
+
+	residuals := make([]float32, len(targets))
+	absolute := make([]float32, len(targets))
+
+	var absoluteSum, squaredSum, targetSum, percentageSum float64
+	percentageCount := 0
+
+	for i := 0; i < len(targets); i++ {
+		residual := float64(targets[i]) - float64(predictions[i])
+
+		residuals[i] = float32(residual)
+		absolute[i] = float32(math.Abs(residual))
+
+		absoluteSum += math.Abs(residual)
+		squaredSum += residual * residual
+		targetSum += float64(targets[i])
+
+		if targets[i] != 0 {
+			percentageSum += math.Abs(residual / float64(targets[i]))
+			percentageCount++
+		}
+	}
+
+	targetMean := targetSum / count
+
+	var totalSquares float64 = 0.0
+	for i := 0; i < len(targets); i++ {
+		deviation := float64(targets[i]) - targetMean
+		totalSquares += deviation * deviation
+	}
+
+	// A constant target has no variance to explain, so a
+	// perfect model scores one and any other model zero.
+	r2 := 0.0
+	if totalSquares > 0 {
+		r2 = 1 - squaredSum / totalSquares
+	} else if squaredSum == 0 {
+		r2 = 1
+	}
+
+	adjustedR2 := math.NaN()
+	if count - float64(predictors) - 1 > 0 {
+		adjustedR2 = 1 - (1 - r2) * (count - 1) / (count - float64(predictors) - 1)
+	}
+
+	mape := math.NaN()
+	if percentageCount > 0 {
+		mape = percentageSum / float64(percentageCount)
+	}
+
+	report.Samples = len(targets)
+	report.Predictors = predictors
+	report.MAE = float32(absoluteSum / count)
+	report.MSE = float32(squaredSum / count)
+	report.RMSE = float32(math.Sqrt(squaredSum / count))
+	report.R2 = float32(r2)
+	report.AdjustedR2 = float32(adjustedR2)
+	report.MAPE = float32(mape)
+	report.MedianAbsoluteError = quantile(sortedCopy(absolute), 0.5)
+	report.Residuals = SummarizeResiduals(residuals)
+
+	return report, nil
+}
+
+// Summarizes a residual vector with its moments, its
+// quantiles and a histogram.
+// -Input residuals: The residual of each entry.
+// -Output: The residual summary.
+func SummarizeResiduals(residuals []float32) ResidualSummary {
+	summary := ResidualSummary{}
+
+	if len(residuals) == 0 {
+		return summary
+	}
+
+	var sum float64 = 0.0
+	for i := 0; i < len(residuals); i++ {
+		sum += float64(residuals[i])
+	}
+
+	mean := sum / float64(len(residuals))
+
+	var variance float64 = 0.0
+	for i := 0; i < len(residuals); i++ {
+		deviation := float64(residuals[i]) - mean
+		variance += deviation * deviation
+	}
+
+	sorted := sortedCopy(residuals)
+
+	summary.Mean = float32(mean)
+	summary.StdDev = float32(math.Sqrt(variance / float64(len(residuals))))
+	summary.Min = sorted[0]
+	summary.Q1 = quantile(sorted, 0.25)
+	summary.Median = quantile(sorted, 0.5)
+	summary.Q3 = quantile(sorted, 0.75)
+	summary.Max = sorted[len(sorted) - 1]
+	summary.Histogram = CreateHistogram(residuals, residualHistogramBins)
+
+	return summary
+}
+
+// Counts the values in equal width bins between the
+// minimum and the maximum value. The NaN and the infinite
+// values are skipped.
+// -Input values: The values to count.
+// -Input bins: How many bins the histogram has.
+// -Output: The histogram.
+func CreateHistogram(values []float32, bins int) Histogram {
+	histogram := Histogram{}
+
+	finite := make([]float32, 0, len(values))
+	for _, value := range values {
+		if !math.IsNaN(float64(value)) && !math.IsInf(float64(value), 0) {
+			finite = append(finite, value)
+		}
+	}
+
+	values = finite
+
+	if len(values) == 0 || bins < 1 {
+		return histogram
+	}
+
+	low := values[0]
+	high := values[0]
+
+	for i := 1; i < len(values); i++ {
+		if values[i] < low {
+			low = values[i]
+		}
+
+		if values[i] > high {
+			high = values[i]
+		}
+	}
+
+	width := (high - low) / float32(bins)
+
+	histogram.Edges = make([]float32, bins + 1)
+	histogram.Counts = make([]int, bins)
+
+	for i := 0; i <= bins; i++ {
+		histogram.Edges[i] = low + width * float32(i)
+	}
+
+	histogram.Edges[bins] = high
+
+	for i := 0; i < len(values); i++ {
+		bin := bins - 1
+
+		if high > low {
+			// The bin is computed in float64, so that a range
+			// wider than the largest float32 does not overflow.
+			bin = int((float64(values[i]) - float64(low)) / (float64(high) - float64(low)) * float64(bins))
+			if bin >= bins {
+				bin = bins - 1
+			}
+		}
+
+		histogram.Counts[bin]++
+	}
+
+	return histogram
+}
+
+// Returns an ascending copy of a vector.
+func sortedCopy(values []float32) []float32 {
+	sorted := make([]float32, len(values))
+	copy(sorted, values)
+
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a] < sorted[b]
+	})
+
+	return sorted
+}
+
+// Calculates a quantile of an ascending vector with
+// linear interpolation between the closest ranks.
+// -Input sorted: An ascending, non empty vector.
+// -Input q: The quantile, in [0, 1].
+// -Output: The quantile value.
+func quantile(sorted []float32, q float64) float32 {
+	position := q * float64(len(sorted) - 1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+
+	fraction := float32(position - float64(lower))
+
+	return sorted[lower] + (sorted[upper] - sorted[lower]) * fraction
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCreateHistogramNonFinite(t *testing.T) {
+	nan := float32(math.NaN())
+	inf := float32(math.Inf(1))
+
+	histogram := CreateHistogram([]float32{nan, 1, 2, nan, 3, -inf, 4, inf}, 4)
+
+	if want := []float32{1, 1.75, 2.5, 3.25, 4}; !reflect.DeepEqual(histogram.Edges, want) {
+		t.Errorf("the edges %v, want %v", histogram.Edges, want)
+	}
+
+	if want := []int{1, 1, 1, 1}; !reflect.DeepEqual(histogram.Counts, want) {
+		t.Errorf("the counts %v, want %v", histogram.Counts, want)
+	}
+
+	if empty := CreateHistogram([]float32{nan, inf}, 4); empty.Counts != nil {
+		t.Errorf("the histogram of non-finite values has the counts %v", empty.Counts)
+	}
+
+	wide := CreateHistogram([]float32{-math.MaxFloat32, 0, math.MaxFloat32}, 2)
+	if want := []int{1, 2}; !reflect.DeepEqual(wide.Counts, want) {
+		t.Errorf("the counts of the widest range %v, want %v", wide.Counts, want)
+	}
+}
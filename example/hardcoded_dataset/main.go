@@ -16,6 +16,7 @@ package main
 
 import(
 	"fmt"
+	"log"
 	nn "7linternational.com/bp7"
 )
 
@@ -46,27 +47,10 @@ func main() {
 	fmt.Print("Network after training propagation: ")
 	fmt.Println(network)
 
-	var score int = 0
-
-	for i := 0; i < len(dataSet); i++ {
-		row := dataSet[i]
-		prediction := network.Predict(row)
-
-		fmt.Print("Row: ")
-		fmt.Println(row)
-
-		fmt.Printf(">>Expected: %d, Predicted: %d", int(row[len(row) - 1]), prediction)
-		fmt.Println()
-
-		if prediction == int(row[len(row) - 1]) {
-			score++
-		}
+	report, err := network.Evaluate(dataSet)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	percentage := (float32(score) / float32(len(dataSet))) * 100
-
-	fmt.Printf("Correct prediction: %d out of %d", score, len(dataSet))
-	fmt.Println()
-	fmt.Printf("Accuracy: %.2f%%", percentage)
-	fmt.Println()
+	fmt.Println(report)
 }
\ No newline at end of file
@@ -16,6 +16,7 @@ package main
 
 import(
 	"fmt"
+	"log"
 	nn "7linternational.com/bp7"
 )
 
@@ -36,32 +37,15 @@ func main() {
 	fmt.Print("Network after training: ")
 	fmt.Println(network)
 
-	var score int = 0
-
 	//testDataSet := nn.CreateDataset("breast-cancer-wisconsin-test.csv")
 	testDataSet := nn.CreateDataset("normalized-breast-cancer-wisconsin-test.csv")
 
-	for i := 0; i < len(testDataSet); i++ {
-		row := testDataSet[i]
-		prediction := network.Predict(row)
-
-		fmt.Print("Row: ")
-		fmt.Println(row)
-
-		fmt.Printf(">>Expected: %d, Predicted: %d", int(row[len(row) - 1]), prediction)
-		fmt.Println()
-
-		if prediction == int(row[len(row) - 1]) {
-			score++
-		}
+	report, err := network.Evaluate(testDataSet)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	percentage := (float32(score) / float32(len(testDataSet))) * 100
-
-	fmt.Printf("Correct prediction: %d out of %d", score, len(testDataSet))
-	fmt.Println()
-	fmt.Printf("Accuracy: %.2f%%", percentage)
-	fmt.Println()
+	fmt.Println(report)
 
 	network.Extract()
 }
\ No newline at end of file
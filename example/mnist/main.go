@@ -0,0 +1,60 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import(
+	"fmt"
+	"log"
+	nn "7linternational.com/bp7"
+)
+
+// The MNIST files are not shipped with the library. Place a
+// local copy of them, gzipped or not, next to this file.
+const (
+	trainImages = "train-images-idx3-ubyte.gz"
+	trainLabels = "train-labels-idx1-ubyte.gz"
+	testImages  = "t10k-images-idx3-ubyte.gz"
+	testLabels  = "t10k-labels-idx1-ubyte.gz"
+)
+
+func main() {
+	// The 10 digits, so that both files have the same classes.
+	options := nn.IDXOptions{ClassCount: 10}
+
+	dataSet, err := nn.LoadIDXWithOptions(trainImages, trainLabels, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	testDataSet, err := nn.LoadIDXWithOptions(testImages, testLabels, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 28x28 pixels and the 10 digits.
+	network := nn.Network{}
+	network.Init(len(dataSet.FeatureNames), 64, dataSet.ClassCount())
+
+	network.Train(dataSet.Rows(), 0.1, 5, dataSet.ClassCount())
+
+	report, err := network.Evaluate(testDataSet.Rows())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(report)
+
+	network.Extract()
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import(
+	"fmt"
+	"log"
+	nn "7linternational.com/bp7"
+)
+
+func main() {
+	// The raw dataset, in its original units.
+	dataSet, err := nn.LoadDataset("../imported_dataset/breast-cancer-wisconsin.csv", nn.DefaultDatasetOptions())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scaler, err := nn.FitScaler(dataSet.Features, nn.MinMaxScaling)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	network := nn.Network{}
+	network.Init(9, 18, 2)
+
+	// The scaler is applied on every row, during the training
+	// and the prediction, and it is extracted with the weights.
+	network.Scaler = scaler
+
+	network.Train(dataSet.Rows(), 0.2, 1000, 2)
+
+	// The test file gets the class indexes of the training file,
+	// even if one of its classes has no entries.
+	testOptions := nn.DefaultDatasetOptions()
+	testOptions.ClassLabels = dataSet.ClassLabels
+
+	testDataSet, err := nn.LoadDataset("../imported_dataset/breast-cancer-wisconsin-test.csv", testOptions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := network.Evaluate(testDataSet.Rows())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(report)
+
+	network.Extract()
+}
@@ -0,0 +1,771 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The missing value of the ARFF format.
+const arffMissing = "?"
+
+// An attribute declared in the header of an ARFF file.
+type arffAttribute struct {
+	name string
+	// The kind of the attribute: numeric, nominal or string.
+	kind string
+	// The declared values of a nominal attribute.
+	values []string
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Returns the names of the columns which the writers emit:
+// the features, the categorical columns and the target.
+func (d *Dataset) writtenColumns() []string {
+	columns := append([]string(nil), d.FeatureNames...)
+	columns = append(columns, d.CategoricalNames...)
+
+	return append(columns, d.TargetName)
+}
+
+// Returns the fields of an entry in the order of the
+// written columns.
+// -Input i: The entry index.
+// -Input missing: The field of a missing feature.
+func (d *Dataset) fields(i int, missing string) []string {
+	fields := make([]string, 0, len(d.FeatureNames) + len(d.CategoricalNames) + 1)
+
+	for _, value := range d.Features[i] {
+		if isMissing(value) {
+			fields = append(fields, missing)
+		} else {
+			fields = append(fields, strconv.FormatFloat(float64(value), 'g', -1, 32))
+		}
+	}
+
+	if len(d.Categorical) > 0 {
+		fields = append(fields, d.Categorical[i]...)
+	}
+
+	return append(fields, d.ClassLabels[d.Targets[i]])
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Reads a dataset from tab separated text. It is ReadDataset
+// with a tab delimiter.
+// -Input r: The reader of the text.
+// -Input options: The loader options.
+// -Output: The dataset.
+func ReadTSV(r io.Reader, options DatasetOptions) (*Dataset, error) {
+	options.Delimiter = '\t'
+
+	return ReadDataset(r, options)
+}
+
+// Writes a dataset as delimited text: the features, the
+// categorical columns and the target label, which is the
+// last column.
+// -Input w: The writer of the text.
+// -Input d: The dataset.
+// -Input options: The delimiter, whether a header is written
+// and, as the first missing token, the field of the missing
+// features.
+func WriteDataset(w io.Writer, d *Dataset, options DatasetOptions) error {
+	writer := csv.NewWriter(w)
+
+	if options.Delimiter != 0 {
+		writer.Comma = options.Delimiter
+	}
+
+	missing := ""
+	if len(options.MissingTokens) > 0 {
+		missing = options.MissingTokens[0]
+	}
+
+	if options.Header {
+		if err := writer.Write(d.writtenColumns()); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < d.Len(); i++ {
+		if err := writer.Write(d.fields(i, missing)); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// Writes a dataset as tab separated text.
+// -Input w: The writer of the text.
+// -Input d: The dataset.
+// -Input options: Whether a header is written and the field
+// of the missing features.
+func WriteTSV(w io.Writer, d *Dataset, options DatasetOptions) error {
+	options.Delimiter = '\t'
+
+	return WriteDataset(w, d, options)
+}
+
+// Reads a dataset in the sparse LIBSVM (SVMlight) format,
+// where every line is a label followed by index:value pairs.
+// The indexes start from one and the absent features are
+// zero. The features are named feature1, feature2, etc.
+// -Input r: The reader of the text.
+// -Input featureCount: How many features there are. Zero
+// means the highest index of the file, so a test file
+// should pass the feature count of its training file.
+// -Output: The dataset.
+func ReadLIBSVM(r io.Reader, featureCount int) (*Dataset, error) {
+	type pair struct {
+		index int
+		value float32
+	}
+
+	reader := bufio.NewReader(r)
+
+	labels := make([]string, 0)
+	entries := make([][]pair, 0)
+	highest := 0
+
+	for line := 1; ; line++ {
+		text, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		if comment := strings.IndexByte(text, '#'); comment >= 0 {
+			text = text[:comment]
+		}
+
+		fields := strings.Fields(text)
+
+		if len(fields) > 0 {
+			entry := make([]pair, 0, len(fields) - 1)
+
+			for _, field := range fields[1:] {
+				separator := strings.IndexByte(field, ':')
+				if separator < 0 {
+					return nil, fmt.Errorf("bp7: line %d: invalid pair %q", line, field)
+				}
+
+				// The query ids of ranking data are not features.
+				if field[:separator] == "qid" {
+					continue
+				}
+
+				index, err := strconv.Atoi(field[:separator])
+				if err != nil || index < 1 {
+					return nil, fmt.Errorf("bp7: line %d: invalid index %q", line, field[:separator])
+				}
+
+				if featureCount > 0 && index > featureCount {
+					return nil, fmt.Errorf("bp7: line %d: index %d is out of %d features", line, index, featureCount)
+				}
+
+				value, err := strconv.ParseFloat(field[separator + 1:], 32)
+				if err != nil {
+					return nil, fmt.Errorf("bp7: line %d: %v", line, err)
+				}
+
+				if index > highest {
+					highest = index
+				}
+
+				entry = append(entry, pair{index - 1, float32(value)})
+			}
+
+			labels = append(labels, fields[0])
+			entries = append(entries, entry)
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("bp7: the dataset is empty")
+	}
+
+	if featureCount <= 0 {
+		featureCount = highest
+	}
+
+	dataset := &Dataset{
+		FeatureNames: make([]string, featureCount),
+		TargetName:   "label",
+		Features:     make([][]float32, len(entries)),
+		ClassLabels:  labelVocabulary(labels),
+		Targets:      make([]int, len(labels)),
+	}
+
+	for i := 0; i < featureCount; i++ {
+		dataset.FeatureNames[i] = fmt.Sprintf("feature%d", i + 1)
+	}
+
+	dataset.Columns = append(append([]string(nil), dataset.FeatureNames...), dataset.TargetName)
+
+	for i, entry := range entries {
+		dataset.Features[i] = make([]float32, featureCount)
+
+		for _, p := range entry {
+			dataset.Features[i][p.index] = p.value
+		}
+
+		dataset.Targets[i] = indexOf(dataset.ClassLabels, labels[i])
+	}
+
+	return dataset, nil
+}
+
+// Writes a dataset in the sparse LIBSVM (SVMlight) format.
+// Only the non-zero features are written. The format has
+// neither missing values nor categorical columns.
+// -Input w: The writer of the text.
+// -Input d: The dataset.
+func WriteLIBSVM(w io.Writer, d *Dataset) error {
+	if len(d.CategoricalNames) > 0 {
+		return errors.New("bp7: the LIBSVM format cannot hold categorical columns")
+	}
+
+	writer := bufio.NewWriter(w)
+
+	for i := 0; i < d.Len(); i++ {
+		writer.WriteString(d.ClassLabels[d.Targets[i]])
+
+		for j, value := range d.Features[i] {
+			if isMissing(value) {
+				return fmt.Errorf("bp7: entry %d has missing values", i)
+			}
+
+			if value != 0 {
+				fmt.Fprintf(writer, " %d:%s", j + 1, strconv.FormatFloat(float64(value), 'g', -1, 32))
+			}
+		}
+
+		writer.WriteString("\n")
+	}
+
+	return writer.Flush()
+}
+
+// Reads a dataset in the Weka ARFF format. The numeric
+// attributes become features, while the nominal, string and
+// date attributes become categorical columns. When the target
+// is a nominal attribute, its class indexes follow the order
+// of its declared values, even the ones which never appear.
+// Both the dense and the sparse data rows are supported.
+// -Input r: The reader of the text.
+// -Input options: The target and the ignored columns. The
+// delimiter and the header options do not apply.
+// -Output: The dataset.
+func ReadARFF(r io.Reader, options DatasetOptions) (*Dataset, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64 * 1024), math.MaxInt32)
+
+	attributes := make([]arffAttribute, 0)
+	records := make([][]string, 0)
+	data := false
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || text[0] == '%' {
+			continue
+		}
+
+		if data {
+			record, err := parseARFFRow(text, attributes)
+			if err != nil {
+				return nil, fmt.Errorf("bp7: line %d: %v", line, err)
+			}
+
+			records = append(records, record)
+			continue
+		}
+
+		keyword := strings.ToLower(strings.Fields(text)[0])
+
+		switch keyword {
+		case "@relation":
+		case "@attribute":
+			attribute, err := parseARFFAttribute(strings.TrimSpace(text[len(keyword):]))
+			if err != nil {
+				return nil, fmt.Errorf("bp7: line %d: %v", line, err)
+			}
+
+			attributes = append(attributes, attribute)
+		case "@data":
+			data = true
+		default:
+			return nil, fmt.Errorf("bp7: line %d: unknown declaration %q", line, keyword)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("bp7: the dataset is empty")
+	}
+
+	columns := make([]string, len(attributes))
+	for i, attribute := range attributes {
+		columns[i] = attribute.name
+	}
+
+	target, err := targetColumn(columns, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// The declared values of a nominal target come first, and
+	// the class labels of the options are applied on them.
+	classLabels := options.ClassLabels
+	options.ClassLabels = nil
+
+	options.MissingTokens = append(append([]string(nil), options.MissingTokens...), arffMissing)
+	options.CategoricalColumns = append([]string(nil), options.CategoricalColumns...)
+
+	for i, attribute := range attributes {
+		if i != target && attribute.kind != "numeric" && indexOf(options.IgnoredColumns, attribute.name) < 0 {
+			options.CategoricalColumns = append(options.CategoricalColumns, attribute.name)
+		}
+	}
+
+	dataset, err := buildDataset(columns, records, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if declared := attributes[target].values; attributes[target].kind == "nominal" {
+		for i, class := range dataset.Targets {
+			dataset.Targets[i] = indexOf(declared, dataset.ClassLabels[class])
+
+			if dataset.Targets[i] < 0 {
+				return nil, fmt.Errorf("bp7: row %d has the undeclared class %q", i, dataset.ClassLabels[class])
+			}
+		}
+
+		dataset.ClassLabels = declared
+	}
+
+	if len(classLabels) > 0 {
+		if err := dataset.RemapClasses(classLabels); err != nil {
+			return nil, err
+		}
+	}
+
+	return dataset, nil
+}
+
+// Parses the name and the type of an ARFF attribute.
+func parseARFFAttribute(text string) (arffAttribute, error) {
+	attribute := arffAttribute{}
+
+	var rest string
+
+	if text != "" && (text[0] == '\'' || text[0] == '"') {
+		end := strings.IndexByte(text[1:], text[0])
+		if end < 0 {
+			return attribute, fmt.Errorf("unterminated attribute name %q", text)
+		}
+
+		attribute.name = text[1:end + 1]
+		rest = text[end + 2:]
+	} else {
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			return attribute, errors.New("the attribute has no name")
+		}
+
+		attribute.name = fields[0]
+		rest = text[len(fields[0]):]
+	}
+
+	rest = strings.TrimSpace(rest)
+
+	if strings.HasPrefix(rest, "{") {
+		end := strings.LastIndexByte(rest, '}')
+		if end < 0 {
+			return attribute, fmt.Errorf("unterminated nominal values %q", rest)
+		}
+
+		values, err := splitARFF(rest[1:end])
+		if err != nil {
+			return attribute, err
+		}
+
+		attribute.kind = "nominal"
+		attribute.values = values
+
+		return attribute, nil
+	}
+
+	kind := strings.ToLower(strings.Fields(rest + " ")[0])
+
+	switch kind {
+	case "numeric", "real", "integer":
+		attribute.kind = "numeric"
+	case "string", "date":
+		attribute.kind = "string"
+	default:
+		return attribute, fmt.Errorf("unsupported attribute type %q", kind)
+	}
+
+	return attribute, nil
+}
+
+// Parses a dense or a sparse ARFF data row into one field
+// per attribute. The absent values of a sparse row are zero,
+// which is the first declared value of a nominal attribute.
+func parseARFFRow(text string, attributes []arffAttribute) ([]string, error) {
+	if !strings.HasPrefix(text, "{") {
+		record, err := splitARFF(text)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(record) != len(attributes) {
+			return nil, fmt.Errorf("%d values but %d attributes", len(record), len(attributes))
+		}
+
+		return record, nil
+	}
+
+	record := make([]string, len(attributes))
+
+	for i, attribute := range attributes {
+		switch attribute.kind {
+		case "numeric":
+			record[i] = "0"
+		case "nominal":
+			record[i] = attribute.values[0]
+		}
+	}
+
+	end := strings.LastIndexByte(text, '}')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated sparse row %q", text)
+	}
+
+	pairs, err := splitARFF(text[1:end])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range pairs {
+		fields := strings.SplitN(strings.TrimSpace(p), " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid sparse value %q", p)
+		}
+
+		index, err := strconv.Atoi(fields[0])
+		if err != nil || index < 0 || index >= len(attributes) {
+			return nil, fmt.Errorf("invalid sparse index %q", fields[0])
+		}
+
+		values, err := splitARFF(fields[1])
+		if err != nil {
+			return nil, err
+		}
+
+		record[index] = values[0]
+	}
+
+	return record, nil
+}
+
+// Splits comma separated ARFF values. A value may be quoted
+// with single or double quotes and a quoted value may escape
+// a character with a backslash.
+func splitARFF(text string) ([]string, error) {
+	values := make([]string, 0)
+
+	var value strings.Builder
+	var quote byte
+	quoted := false
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case quote != 0 && c == '\\' && i + 1 < len(text):
+			i++
+			value.WriteByte(text[i])
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			value.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			quoted = true
+		case c == ',':
+			values = append(values, arffValue(value.String(), quoted))
+			value.Reset()
+			quoted = false
+		default:
+			value.WriteByte(c)
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", text)
+	}
+
+	return append(values, arffValue(value.String(), quoted)), nil
+}
+
+// Trims an unquoted ARFF value.
+func arffValue(value string, quoted bool) string {
+	if quoted {
+		return value
+	}
+
+	return strings.TrimSpace(value)
+}
+
+// Writes a dataset in the Weka ARFF format. The features are
+// numeric attributes, while the categorical columns and the
+// target are nominal attributes.
+// -Input w: The writer of the text.
+// -Input d: The dataset.
+// -Input relation: The name of the relation.
+func WriteARFF(w io.Writer, d *Dataset, relation string) error {
+	writer := bufio.NewWriter(w)
+
+	fmt.Fprintf(writer, "@relation %s\n\n", quoteARFF(relation))
+
+	for _, name := range d.FeatureNames {
+		fmt.Fprintf(writer, "@attribute %s numeric\n", quoteARFF(name))
+	}
+
+	for i, name := range d.CategoricalNames {
+		values := make([]string, d.Len())
+		for r := 0; r < d.Len(); r++ {
+			values[r] = d.Categorical[r][i]
+		}
+
+		fmt.Fprintf(writer, "@attribute %s %s\n", quoteARFF(name), nominalARFF(distinctValues(values)))
+	}
+
+	fmt.Fprintf(writer, "@attribute %s %s\n\n@data\n", quoteARFF(d.TargetName), nominalARFF(d.ClassLabels))
+
+	for i := 0; i < d.Len(); i++ {
+		fields := d.fields(i, arffMissing)
+
+		for j := len(d.FeatureNames); j < len(fields); j++ {
+			fields[j] = quoteARFF(fields[j])
+		}
+
+		writer.WriteString(strings.Join(fields, ","))
+		writer.WriteString("\n")
+	}
+
+	return writer.Flush()
+}
+
+// Declares nominal ARFF values.
+func nominalARFF(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = quoteARFF(value)
+	}
+
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+// Quotes an ARFF name or value if it holds any character
+// which the format treats specially.
+func quoteARFF(value string) string {
+	if value != "" && value != arffMissing && !strings.ContainsAny(value, " \t,'\"{}%\\") {
+		return value
+	}
+
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+
+	return "'" + strings.ReplaceAll(value, "'", "\\'") + "'"
+}
+
+// Reads a dataset in the JSON Lines format, where every line
+// is a flat object of named fields. The columns are the fields
+// of the first object, in order. A null or an absent field is
+// a missing value, and the string fields should be declared
+// as categorical columns.
+// -Input r: The reader of the text.
+// -Input options: The target, the ignored and the categorical
+// columns. The delimiter and the header options do not apply.
+// -Output: The dataset.
+func ReadJSONLines(r io.Reader, options DatasetOptions) (*Dataset, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64 * 1024), math.MaxInt32)
+
+	columns := make([]string, 0)
+	records := make([][]string, 0)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		keys, values, err := parseJSONObject(text)
+		if err != nil {
+			return nil, fmt.Errorf("bp7: line %d: %v", line, err)
+		}
+
+		if len(records) == 0 {
+			columns = keys
+		}
+
+		record := make([]string, len(columns))
+
+		for i, key := range keys {
+			index := indexOf(columns, key)
+			if index < 0 {
+				return nil, fmt.Errorf("bp7: line %d: unknown field %q", line, key)
+			}
+
+			record[index] = values[i]
+		}
+
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("bp7: the dataset is empty")
+	}
+
+	// The null and the absent fields are read as empty.
+	options.MissingTokens = append(append([]string(nil), options.MissingTokens...), "")
+
+	return buildDataset(columns, records, options)
+}
+
+// Parses a flat JSON object into its keys, in order, and
+// their values as text.
+func parseJSONObject(text []byte) ([]string, []string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(text))
+	decoder.UseNumber()
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, nil, errors.New("the line is not a JSON object")
+	}
+
+	keys := make([]string, 0)
+	values := make([]string, 0)
+
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, _ := token.(string)
+
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var value string
+
+		switch v := token.(type) {
+		case nil:
+			value = ""
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			return nil, nil, fmt.Errorf("the field %q is not a flat value", key)
+		}
+
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	return keys, values, nil
+}
+
+// Writes a dataset in the JSON Lines format, one object per
+// entry. The missing features are null and the target label
+// is written as a string.
+// -Input w: The writer of the text.
+// -Input d: The dataset.
+func WriteJSONLines(w io.Writer, d *Dataset) error {
+	writer := bufio.NewWriter(w)
+	columns := d.writtenColumns()
+
+	for i := 0; i < d.Len(); i++ {
+		fields := d.fields(i, "null")
+
+		writer.WriteString("{")
+
+		for j, column := range columns {
+			if j > 0 {
+				writer.WriteString(",")
+			}
+
+			key, _ := json.Marshal(column)
+			writer.Write(key)
+			writer.WriteString(":")
+
+			if j < len(d.FeatureNames) {
+				value := float64(d.Features[i][j])
+
+				// JSON has neither NaN nor infinities.
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					writer.WriteString("null")
+				} else {
+					writer.WriteString(fields[j])
+				}
+			} else {
+				value, _ := json.Marshal(fields[j])
+				writer.Write(value)
+			}
+		}
+
+		writer.WriteString("}\n")
+	}
+
+	return writer.Flush()
+}
@@ -23,6 +23,9 @@ import (
 // contains an array of neurons.
 type HiddenLayer struct {
 	Neurons []Neuron
+	// The activation of the neurons. The empty activation
+	// is the sigmoid.
+	Activation Activation
 }
 
 // The structure function implementation of the
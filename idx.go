@@ -0,0 +1,279 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+)
+
+// The IDX data types and their sizes in bytes.
+var idxTypeSizes = map[byte]int{
+	0x08: 1, // unsigned byte
+	0x09: 1, // signed byte
+	0x0B: 2, // short
+	0x0C: 4, // int
+	0x0D: 4, // float
+	0x0E: 8, // double
+}
+
+// The options of the IDX loader.
+type IDXOptions struct {
+	// How many classes the dataset has, like 10 for MNIST, so
+	// that a test file gets the classes of its training file.
+	// A label out of the classes is an error. Zero means that
+	// the classes go up to the highest label of the file.
+	ClassCount int
+}
+
+// The largest value count of an IDX array, which keeps a
+// malformed header from allocating unbounded memory.
+const maxIDXValues = 1 << 30
+
+// An array read from an IDX file, like the MNIST images or
+// labels. The values are stored flattened, in row-major order.
+type idxArray struct {
+	dataType   byte
+	dimensions []int
+	values     []float32
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Loads an IDX dataset, like MNIST or Fashion-MNIST, from an
+// images file and a labels file. Gzipped files are read too.
+// -Input imagesPath: The path of the images file.
+// -Input labelsPath: The path of the labels file.
+// -Output: The dataset. See ReadIDX.
+func LoadIDX(imagesPath string, labelsPath string) (*Dataset, error) {
+	return LoadIDXWithOptions(imagesPath, labelsPath, IDXOptions{})
+}
+
+// Loads an IDX dataset from an images file and a labels file.
+// -Input imagesPath: The path of the images file.
+// -Input labelsPath: The path of the labels file.
+// -Input options: The class count of the dataset.
+// -Output: The dataset. See ReadIDX.
+func LoadIDXWithOptions(imagesPath string, labelsPath string, options IDXOptions) (*Dataset, error) {
+	images, err := os.Open(imagesPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer images.Close()
+
+	labels, err := os.Open(labelsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer labels.Close()
+
+	return ReadIDXWithOptions(images, labels, options)
+}
+
+// Reads an IDX dataset from an images stream and a labels
+// stream. Every image is flattened into a feature row, whose
+// features are named pixel0, pixel1, etc. The unsigned byte
+// images are normalized to [0, 1]. The class of an entry is
+// its label, so the class labels are "0", "1", etc. up to the
+// highest label.
+// -Input images: The images stream, optionally gzipped.
+// -Input labels: The labels stream, optionally gzipped.
+// -Output: The dataset.
+func ReadIDX(images io.Reader, labels io.Reader) (*Dataset, error) {
+	return ReadIDXWithOptions(images, labels, IDXOptions{})
+}
+
+// Reads an IDX dataset from an images stream and a labels
+// stream, like ReadIDX.
+// -Input images: The images stream, optionally gzipped.
+// -Input labels: The labels stream, optionally gzipped.
+// -Input options: The class count of the dataset.
+// -Output: The dataset.
+func ReadIDXWithOptions(images io.Reader, labels io.Reader, options IDXOptions) (*Dataset, error) {
+	if options.ClassCount < 0 {
+		return nil, fmt.Errorf("bp7: invalid class count %d", options.ClassCount)
+	}
+
+	imageArray, err := readIDX(images)
+	if err != nil {
+		return nil, err
+	}
+
+	labelArray, err := readIDX(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(imageArray.dimensions) < 2 {
+		return nil, errors.New("bp7: the IDX images have no dimensions per image")
+	}
+
+	if len(labelArray.dimensions) != 1 {
+		return nil, errors.New("bp7: the IDX labels must have one dimension")
+	}
+
+	count := imageArray.dimensions[0]
+	if count != labelArray.dimensions[0] {
+		return nil, fmt.Errorf("bp7: %d IDX images but %d labels", count, labelArray.dimensions[0])
+	}
+
+	size := len(imageArray.values) / count
+
+	dataset := &Dataset{
+		FeatureNames: make([]string, size),
+		TargetName:   "label",
+		Features:     make([][]float32, count),
+		Targets:      make([]int, count),
+	}
+
+	for i := 0; i < size; i++ {
+		dataset.FeatureNames[i] = "pixel" + strconv.Itoa(i)
+	}
+
+	dataset.Columns = append(append([]string(nil), dataset.FeatureNames...), dataset.TargetName)
+
+	highest := 0
+
+	for i := 0; i < count; i++ {
+		dataset.Features[i] = imageArray.values[i * size:(i + 1) * size:(i + 1) * size]
+
+		if imageArray.dataType == 0x08 {
+			for j := range dataset.Features[i] {
+				dataset.Features[i][j] /= 255
+			}
+		}
+
+		label := labelArray.values[i]
+		if label < 0 || label != float32(math.Trunc(float64(label))) {
+			return nil, fmt.Errorf("bp7: the IDX label %v of image %d is not a class", label, i)
+		}
+
+		if options.ClassCount > 0 && int(label) >= options.ClassCount {
+			return nil, fmt.Errorf("bp7: the IDX label %v of image %d is out of %d classes", label, i, options.ClassCount)
+		}
+
+		dataset.Targets[i] = int(label)
+		if dataset.Targets[i] > highest {
+			highest = dataset.Targets[i]
+		}
+	}
+
+	if options.ClassCount > 0 {
+		highest = options.ClassCount - 1
+	}
+
+	dataset.ClassLabels = make([]string, highest + 1)
+	for i := 0; i <= highest; i++ {
+		dataset.ClassLabels[i] = strconv.Itoa(i)
+	}
+
+	return dataset, nil
+}
+
+// Reads an IDX array. The stream is decompressed first if
+// it starts with the gzip magic number.
+func readIDX(r io.Reader) (*idxArray, error) {
+	reader := bufio.NewReader(r)
+
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		decompressed, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		defer decompressed.Close()
+
+		reader = bufio.NewReader(decompressed)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	size, known := idxTypeSizes[header[2]]
+	if header[0] != 0 || header[1] != 0 || !known {
+		return nil, errors.New("bp7: the stream is not in the IDX format")
+	}
+
+	array := &idxArray{dataType: header[2], dimensions: make([]int, header[3])}
+	count := 1
+
+	for i := range array.dimensions {
+		var dimension uint32
+		if err := binary.Read(reader, binary.BigEndian, &dimension); err != nil {
+			return nil, err
+		}
+
+		array.dimensions[i] = int(dimension)
+
+		if dimension > 0 && count > maxIDXValues / int(dimension) {
+			return nil, fmt.Errorf("bp7: the IDX array is larger than %d values", maxIDXValues)
+		}
+
+		count *= int(dimension)
+	}
+
+	if len(array.dimensions) == 0 || count == 0 {
+		return nil, errors.New("bp7: the IDX array is empty")
+	}
+
+	// The data is read before it is allocated in full, so that
+	// a header which claims more values than the stream has
+	// fails without allocating them.
+	data, err := ioutil.ReadAll(io.LimitReader(reader, int64(count * size)))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) != count * size {
+		return nil, fmt.Errorf("bp7: the IDX header claims %d values but the stream has %d bytes of %d", count, len(data), count * size)
+	}
+
+	array.values = make([]float32, count)
+
+	for i := 0; i < count; i++ {
+		value := data[i * size:(i + 1) * size]
+
+		switch array.dataType {
+		case 0x08:
+			array.values[i] = float32(value[0])
+		case 0x09:
+			array.values[i] = float32(int8(value[0]))
+		case 0x0B:
+			array.values[i] = float32(int16(binary.BigEndian.Uint16(value)))
+		case 0x0C:
+			array.values[i] = float32(int32(binary.BigEndian.Uint32(value)))
+		case 0x0D:
+			array.values[i] = math.Float32frombits(binary.BigEndian.Uint32(value))
+		case 0x0E:
+			array.values[i] = float32(math.Float64frombits(binary.BigEndian.Uint64(value)))
+		}
+	}
+
+	return array, nil
+}
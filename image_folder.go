@@ -0,0 +1,155 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The options of the image folder loader.
+type ImageOptions struct {
+	// The size which every image is resized to, so that every
+	// image has the same features.
+	Width  int
+	Height int
+	// Whether the images are converted to their luminance, with
+	// one feature per pixel, instead of three RGB features.
+	Grayscale bool
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Loads a labelled image dataset from a folder which has one
+// directory per class, like root/cat/1.png and root/dog/2.jpg.
+// The class labels are the directory names, in alphabetical
+// order. Every PNG and JPEG image is resized to the configured
+// size with the nearest neighbour, flattened in row-major order
+// and normalized to [0, 1]. The other files are skipped.
+// -Input root: The path of the folder.
+// -Input options: The image size and the color mode.
+// -Output: The dataset.
+func LoadImageFolder(root string, options ImageOptions) (*Dataset, error) {
+	if options.Width < 1 || options.Height < 1 {
+		return nil, errors.New("bp7: the image size must be positive")
+	}
+
+	directories, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := 3
+	if options.Grayscale {
+		channels = 1
+	}
+
+	dataset := &Dataset{
+		FeatureNames: make([]string, options.Width * options.Height * channels),
+		TargetName:   "label",
+	}
+
+	for i := range dataset.FeatureNames {
+		dataset.FeatureNames[i] = "pixel" + strconv.Itoa(i)
+	}
+
+	dataset.Columns = append(append([]string(nil), dataset.FeatureNames...), dataset.TargetName)
+
+	for _, directory := range directories {
+		if !directory.IsDir() {
+			continue
+		}
+
+		class := len(dataset.ClassLabels)
+		dataset.ClassLabels = append(dataset.ClassLabels, directory.Name())
+
+		files, err := ioutil.ReadDir(filepath.Join(root, directory.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			switch strings.ToLower(filepath.Ext(file.Name())) {
+			case ".png", ".jpg", ".jpeg":
+			default:
+				continue
+			}
+
+			path := filepath.Join(root, directory.Name(), file.Name())
+
+			features, err := loadImage(path, options)
+			if err != nil {
+				return nil, fmt.Errorf("bp7: %s: %v", path, err)
+			}
+
+			dataset.Features = append(dataset.Features, features)
+			dataset.Targets = append(dataset.Targets, class)
+		}
+	}
+
+	if dataset.Len() == 0 {
+		return nil, errors.New("bp7: there are no images in the class directories")
+	}
+
+	return dataset, nil
+}
+
+// Decodes an image, resizes it with the nearest neighbour
+// and flattens its pixels into normalized features.
+func loadImage(path string, options ImageOptions) ([]float32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	features := make([]float32, 0, options.Width * options.Height * 3)
+
+	for y := 0; y < options.Height; y++ {
+		for x := 0; x < options.Width; x++ {
+			sourceX := bounds.Min.X + x * bounds.Dx() / options.Width
+			sourceY := bounds.Min.Y + y * bounds.Dy() / options.Height
+
+			// The channels are 16-bit, pre-multiplied by alpha.
+			r, g, b, _ := img.At(sourceX, sourceY).RGBA()
+
+			if options.Grayscale {
+				luminance := 0.299 * float32(r) + 0.587 * float32(g) + 0.114 * float32(b)
+				features = append(features, luminance / 0xffff)
+			} else {
+				features = append(features, float32(r) / 0xffff, float32(g) / 0xffff, float32(b) / 0xffff)
+			}
+		}
+	}
+
+	return features, nil
+}
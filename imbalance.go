@@ -0,0 +1,436 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// The neighbour count of SMOTE and ADASYN when the
+// caller does not set one.
+const defaultSampleNeighbours = 5
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Returns the loss weight of a class, which is one when
+// the network has no class weights.
+func (n *Network) classWeight(class int) float32 {
+	if class < 0 || class >= len(n.ClassWeights) {
+		return 1
+	}
+
+	return n.ClassWeights[class]
+}
+
+// Trains a network with a weight per training row. The update
+// of a row is scaled by its weight and by the weight of its
+// class, if the network has class weights.
+// -Input trainSet: The array of the training data set.
+// -Input sampleWeights: The loss weight of each row.
+// -Input learningRate: The weight learning adaptation.
+// -Input epochs: How many iterations does the training have.
+// -Input outputCount: How many classification categories exist
+// for the given training set (this should match the output neurons).
+func (n *Network) TrainWeighted(trainSet [][]float32, sampleWeights []float32, learningRate float32, epochs int, outputCount int) error {
+	if len(sampleWeights) != len(trainSet) {
+		return fmt.Errorf("bp7: %d rows but %d sample weights", len(trainSet), len(sampleWeights))
+	}
+
+	source := CreateSliceSource(trainSet, len(trainSet))
+	weight := func(index int) float32 {
+		return sampleWeights[index]
+	}
+
+	return n.trainLoop(source, learningRate, epochs, outputCount, trainingLoop{rowWeight: weight})
+}
+
+// Calculates the balanced class weights, which are inversely
+// proportional to the class frequencies: n / (classes * count).
+// -Output: The weight of each class.
+func (d *Dataset) BalancedClassWeights() []float32 {
+	counts := make([]int, d.ClassCount())
+	for _, target := range d.Targets {
+		counts[target]++
+	}
+
+	weights := make([]float32, len(counts))
+
+	for i, count := range counts {
+		weights[i] = 1
+		if count > 0 {
+			weights[i] = float32(d.Len()) / float32(len(counts) * count)
+		}
+	}
+
+	return weights
+}
+
+// Duplicates random entries of every minority class until
+// each class is as large as the majority class. Resample
+// only the training split, never the validation or the
+// test split.
+// -Input seed: The seed of the sampling.
+// -Output: The resampled dataset.
+func (d *Dataset) RandomOversample(seed int64) *Dataset {
+	random := rand.New(rand.NewSource(seed))
+	classes := d.classIndexes()
+	majority := largestClass(classes)
+
+	indexes := make([]int, 0, majority * len(classes))
+
+	for _, class := range classes {
+		indexes = append(indexes, class...)
+
+		for i := len(class); i < majority && len(class) > 0; i++ {
+			indexes = append(indexes, class[random.Intn(len(class))])
+		}
+	}
+
+	return d.shuffledSubset(indexes, random)
+}
+
+// Drops random entries of every majority class until each
+// class is as small as the minority class. Resample only
+// the training split.
+// -Input seed: The seed of the sampling.
+// -Output: The resampled dataset.
+func (d *Dataset) RandomUndersample(seed int64) *Dataset {
+	random := rand.New(rand.NewSource(seed))
+	classes := d.classIndexes()
+
+	minority := -1
+	for _, class := range classes {
+		if len(class) > 0 && (minority < 0 || len(class) < minority) {
+			minority = len(class)
+		}
+	}
+
+	indexes := make([]int, 0)
+
+	for _, class := range classes {
+		random.Shuffle(len(class), func(a, b int) {
+			class[a], class[b] = class[b], class[a]
+		})
+
+		if len(class) > minority {
+			class = class[:minority]
+		}
+
+		indexes = append(indexes, class...)
+	}
+
+	return d.shuffledSubset(indexes, random)
+}
+
+// Oversamples every minority class with SMOTE: each synthetic
+// entry lies at a random point between an entry and one of its
+// k nearest neighbours of the same class. The categorical values
+// are copied from the original entry. Resample only the training
+// split.
+// -Input k: The neighbour count. A value lower than one means 5.
+// -Input seed: The seed of the sampling.
+// -Output: The resampled dataset.
+func (d *Dataset) SMOTE(k int, seed int64) (*Dataset, error) {
+	return d.synthesize(k, seed, false)
+}
+
+// Oversamples every minority class with ADASYN, which is SMOTE
+// generating more synthetic entries around the entries whose
+// nearest neighbours belong to other classes, the ones which
+// are harder to learn. Resample only the training split.
+// -Input k: The neighbour count. A value lower than one means 5.
+// -Input seed: The seed of the sampling.
+// -Output: The resampled dataset.
+func (d *Dataset) ADASYN(k int, seed int64) (*Dataset, error) {
+	return d.synthesize(k, seed, true)
+}
+
+// Oversamples the minority classes with synthetic entries.
+// -Input k: The neighbour count.
+// -Input seed: The seed of the sampling.
+// -Input adaptive: Whether the entries are distributed like
+// ADASYN instead of uniformly like SMOTE.
+// -Output: The resampled dataset.
+func (d *Dataset) synthesize(k int, seed int64, adaptive bool) (*Dataset, error) {
+	for i, features := range d.Features {
+		for _, value := range features {
+			if isMissing(value) {
+				return nil, fmt.Errorf("bp7: entry %d has missing values, impute them first", i)
+			}
+		}
+	}
+
+	if k < 1 {
+		k = defaultSampleNeighbours
+	}
+
+	random := rand.New(rand.NewSource(seed))
+	classes := d.classIndexes()
+	majority := largestClass(classes)
+
+	all := make([]int, d.Len())
+	for i := range all {
+		all[i] = i
+	}
+
+	resampled := d.Subset(all)
+
+	for c, class := range classes {
+		needed := majority - len(class)
+		if needed == 0 || len(class) == 0 {
+			continue
+		}
+
+		if len(class) < 2 {
+			return nil, fmt.Errorf("bp7: class %d has a single entry and no neighbours", c)
+		}
+
+		// How many synthetic entries each entry of the class gets.
+		shares := make([]float64, len(class))
+
+		for i := range shares {
+			shares[i] = 1
+		}
+
+		if adaptive {
+			var sum float64 = 0.0
+
+			for i, index := range class {
+				others := 0
+				for _, neighbour := range d.nearest(index, all, k) {
+					if d.Targets[neighbour] != c {
+						others++
+					}
+				}
+
+				shares[i] = float64(others)
+				sum += shares[i]
+			}
+
+			// Without any hard entries ADASYN is plain SMOTE.
+			if sum == 0 {
+				for i := range shares {
+					shares[i] = 1
+				}
+			}
+		}
+
+		for i, count := range distributeSamples(shares, needed, random) {
+			if count == 0 {
+				continue
+			}
+
+			neighbours := d.nearest(class[i], class, k)
+
+			for s := 0; s < count; s++ {
+				resampled.appendBetween(d, class[i], neighbours[random.Intn(len(neighbours))], random.Float32())
+			}
+		}
+	}
+
+	return resampled.shuffledSubset(nil, random), nil
+}
+
+// Appends a synthetic entry between two entries of a dataset.
+// -Input source: The dataset of the two entries.
+// -Input a: The entry which the synthetic entry is based on.
+// -Input b: The neighbour of the entry.
+// -Input gap: The position between them, in [0, 1).
+func (d *Dataset) appendBetween(source *Dataset, a int, b int, gap float32) {
+	features := make([]float32, len(source.Features[a]))
+	for i := range features {
+		features[i] = source.Features[a][i] + gap * (source.Features[b][i] - source.Features[a][i])
+	}
+
+	d.Features = append(d.Features, features)
+	d.Targets = append(d.Targets, source.Targets[a])
+
+	if len(source.Categorical) > 0 {
+		d.Categorical = append(d.Categorical, source.Categorical[a])
+	}
+}
+
+// Returns the k nearest candidates of an entry by the
+// euclidean distance, excluding the entry itself.
+func (d *Dataset) nearest(index int, candidates []int, k int) []int {
+	type neighbour struct {
+		index    int
+		distance float64
+	}
+
+	neighbours := make([]neighbour, 0, len(candidates))
+
+	for _, candidate := range candidates {
+		if candidate == index {
+			continue
+		}
+
+		var sum float64 = 0.0
+		for i, value := range d.Features[index] {
+			difference := float64(value - d.Features[candidate][i])
+			sum += difference * difference
+		}
+
+		neighbours = append(neighbours, neighbour{candidate, sum})
+	}
+
+	sort.SliceStable(neighbours, func(a, b int) bool {
+		return neighbours[a].distance < neighbours[b].distance
+	})
+
+	if len(neighbours) > k {
+		neighbours = neighbours[:k]
+	}
+
+	indexes := make([]int, len(neighbours))
+	for i, n := range neighbours {
+		indexes[i] = n.index
+	}
+
+	return indexes
+}
+
+// Returns the entry indexes of every class.
+func (d *Dataset) classIndexes() [][]int {
+	classes := make([][]int, d.ClassCount())
+
+	for i, target := range d.Targets {
+		classes[target] = append(classes[target], i)
+	}
+
+	return classes
+}
+
+// Returns the given entries in a random order. With no
+// indexes, it shuffles every entry of the dataset.
+func (d *Dataset) shuffledSubset(indexes []int, random *rand.Rand) *Dataset {
+	if len(indexes) == 0 {
+		indexes = random.Perm(d.Len())
+	} else {
+		random.Shuffle(len(indexes), func(a, b int) {
+			indexes[a], indexes[b] = indexes[b], indexes[a]
+		})
+	}
+
+	return d.Subset(indexes)
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Trains a network with a weight per training row.
+// -Input n: A network.
+// -Input trainSet: The array of the training data set.
+// -Input sampleWeights: The loss weight of each row.
+// -Input learningRate: The weight learning adaptation.
+// -Input epochs: How many iterations does the training have.
+// -Input outputCount: How many classification categories exist
+// for the given training set (this should match the output neurons).
+func TrainWeighted(n *Network, trainSet [][]float32, sampleWeights []float32, learningRate float32, epochs int, outputCount int) error {
+	return n.TrainWeighted(trainSet, sampleWeights, learningRate, epochs, outputCount)
+}
+
+// Calculates the balanced class weights of a dataset, which
+// can be set as the class weights of a network.
+// -Input d: The training dataset.
+// -Output: The weight of each class.
+func BalancedClassWeights(d *Dataset) []float32 {
+	return d.BalancedClassWeights()
+}
+
+// Duplicates random minority entries of a dataset.
+// -Input d: The training dataset.
+// -Input seed: The seed of the sampling.
+// -Output: The resampled dataset.
+func RandomOversample(d *Dataset, seed int64) *Dataset {
+	return d.RandomOversample(seed)
+}
+
+// Drops random majority entries of a dataset.
+// -Input d: The training dataset.
+// -Input seed: The seed of the sampling.
+// -Output: The resampled dataset.
+func RandomUndersample(d *Dataset, seed int64) *Dataset {
+	return d.RandomUndersample(seed)
+}
+
+// Oversamples the minority classes of a dataset with SMOTE.
+// -Input d: The training dataset.
+// -Input k: The neighbour count.
+// -Input seed: The seed of the sampling.
+// -Output: The resampled dataset.
+func SMOTE(d *Dataset, k int, seed int64) (*Dataset, error) {
+	return d.SMOTE(k, seed)
+}
+
+// Oversamples the minority classes of a dataset with ADASYN.
+// -Input d: The training dataset.
+// -Input k: The neighbour count.
+// -Input seed: The seed of the sampling.
+// -Output: The resampled dataset.
+func ADASYN(d *Dataset, k int, seed int64) (*Dataset, error) {
+	return d.ADASYN(k, seed)
+}
+
+// Returns the size of the largest class.
+func largestClass(classes [][]int) int {
+	largest := 0
+
+	for _, class := range classes {
+		if len(class) > largest {
+			largest = len(class)
+		}
+	}
+
+	return largest
+}
+
+// Distributes a number of samples proportionally to the
+// shares, rounding with the largest remainders.
+func distributeSamples(shares []float64, total int, random *rand.Rand) []int {
+	var sum float64 = 0.0
+	for _, share := range shares {
+		sum += share
+	}
+
+	counts := make([]int, len(shares))
+	remainders := make([]float64, len(shares))
+	assigned := 0
+
+	for i, share := range shares {
+		exact := share / sum * float64(total)
+		counts[i] = int(math.Floor(exact))
+		remainders[i] = exact - float64(counts[i])
+		assigned += counts[i]
+	}
+
+	order := random.Perm(len(shares))
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+
+	for i := 0; assigned < total; i++ {
+		counts[order[i % len(order)]]++
+		assigned++
+	}
+
+	return counts
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Returns a copy of a network whose layers do not share the
+// weights of the original.
+func copyNetwork(n *Network) *Network {
+	copied := *n
+
+	for _, neurons := range []*[]Neuron{&copied.HiddenLayer.Neurons, &copied.OutputLayer.Neurons} {
+		layer := make([]Neuron, len(*neurons))
+
+		for i, neuron := range *neurons {
+			layer[i].Weights = append([]float32(nil), neuron.Weights...)
+		}
+
+		*neurons = layer
+	}
+
+	return &copied
+}
+
+// Returns the weights of every neuron of a network, without
+// the outputs and the deltas of the last training row.
+func networkWeights(n *Network) [][]float32 {
+	weights := make([][]float32, 0)
+
+	for _, neurons := range [][]Neuron{n.HiddenLayer.Neurons, n.OutputLayer.Neurons} {
+		for _, neuron := range neurons {
+			weights = append(weights, neuron.Weights)
+		}
+	}
+
+	return weights
+}
+
+func TestTrainWeighted(t *testing.T) {
+	rows := [][]float32{{0.1, 0.9, 0}, {0.8, 0.2, 1}, {0.3, 0.7, 0}, {0.9, 0.4, 1}}
+
+	n := CreateNetwork(2, 3, 2)
+	original := copyNetwork(&n)
+
+	unweighted := copyNetwork(original)
+	if err := unweighted.TrainSource(CreateSliceSource(rows, 2), 0.5, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	weighted := copyNetwork(original)
+	if err := weighted.TrainWeighted(rows, []float32{1, 1, 1, 1}, 0.5, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(weighted.HiddenLayer, unweighted.HiddenLayer) || !reflect.DeepEqual(weighted.OutputLayer, unweighted.OutputLayer) {
+		t.Error("unit sample weights train differently from TrainSource")
+	}
+
+	if weighted.Metadata["samples"] != unweighted.Metadata["samples"] {
+		t.Errorf("the recorded samples %q, want %q", weighted.Metadata["samples"], unweighted.Metadata["samples"])
+	}
+
+	// Rows with a zero weight do not change the network.
+	frozen := copyNetwork(original)
+	if err := frozen.TrainWeighted(rows, []float32{0, 0, 0, 0}, 0.5, 3, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(networkWeights(frozen), networkWeights(original)) {
+		t.Error("zero sample weights changed the network")
+	}
+
+	if err := frozen.TrainWeighted(rows, []float32{1}, 0.5, 1, 2); err == nil {
+		t.Error("TrainWeighted() accepted fewer sample weights than rows")
+	}
+}
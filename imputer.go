@@ -0,0 +1,332 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// How an imputer fills in the missing values. Missing
+// values are represented as NaN. In order to drop the
+// entries with missing values instead, use DropMissing.
+type ImputeStrategy string
+
+const (
+	// Fills in the mean of the feature.
+	ImputeMean ImputeStrategy = "mean"
+	// Fills in the median of the feature.
+	ImputeMedian ImputeStrategy = "median"
+	// Fills in the most frequent value of the feature.
+	ImputeMostFrequent ImputeStrategy = "most_frequent"
+	// Fills in a constant value.
+	ImputeConstant ImputeStrategy = "constant"
+	// Fills in the mean of the feature over the nearest
+	// training entries which have the feature.
+	ImputeKNN ImputeStrategy = "knn"
+)
+
+// The file name of the imputer when it is extracted
+// together with the layer weights.
+const imputerFileName = "imputer.csv"
+
+// The neighbour count of the k-NN imputation when the
+// configuration does not set one.
+const defaultImputeNeighbours = 5
+
+// The configuration of an imputer.
+type ImputerConfig struct {
+	Strategy ImputeStrategy
+	// The fill value of the ImputeConstant strategy.
+	Constant float32
+	// How many neighbours the ImputeKNN strategy averages.
+	// A value lower than one means 5.
+	Neighbours int
+}
+
+// An imputer fitted on training features. It fills in
+// the missing (NaN) values of a feature vector.
+type Imputer struct {
+	Strategy ImputeStrategy
+	// The fill value of each feature. The k-NN strategy
+	// falls back to it when no neighbour has the feature.
+	Fill []float32
+	// The neighbour count of the k-NN strategy.
+	Neighbours int
+	// The training features the k-NN strategy searches.
+	Reference [][]float32
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Fills in the missing values of a feature vector. If the
+// vector is longer than the fitted features, like a row
+// which ends with its class index, the extra values are
+// copied unchanged.
+// -Input features: The feature vector.
+// -Output: A new vector without missing values.
+func (im *Imputer) Transform(features []float32) []float32 {
+	filled := make([]float32, len(features))
+	copy(filled, features)
+
+	missing := false
+
+	for i := 0; i < len(im.Fill) && i < len(filled); i++ {
+		if isMissing(filled[i]) {
+			missing = true
+			filled[i] = im.Fill[i]
+		}
+	}
+
+	if missing && im.Strategy == ImputeKNN {
+		im.fillFromNeighbours(features, filled)
+	}
+
+	return filled
+}
+
+// Fills in the missing values with the mean of the nearest
+// reference entries which have the feature. The distance
+// only counts the features present in both entries and is
+// scaled up by the share of the features that are missing
+// (the nan-euclidean distance).
+// -Input features: The original feature vector.
+// -Input filled: The vector to fill in.
+func (im *Imputer) fillFromNeighbours(features []float32, filled []float32) {
+	type neighbour struct {
+		index    int
+		distance float64
+	}
+
+	count := len(im.Fill)
+	if len(features) < count {
+		count = len(features)
+	}
+
+	neighbours := make([]neighbour, 0, len(im.Reference))
+
+	for r, reference := range im.Reference {
+		var sum float64 = 0.0
+		present := 0
+
+		for i := 0; i < count; i++ {
+			if isMissing(features[i]) || isMissing(reference[i]) {
+				continue
+			}
+
+			difference := float64(features[i] - reference[i])
+			sum += difference * difference
+			present++
+		}
+
+		if present > 0 {
+			distance := math.Sqrt(sum * float64(count) / float64(present))
+			neighbours = append(neighbours, neighbour{r, distance})
+		}
+	}
+
+	sort.SliceStable(neighbours, func(a, b int) bool {
+		return neighbours[a].distance < neighbours[b].distance
+	})
+
+	for i := 0; i < count; i++ {
+		if !isMissing(features[i]) {
+			continue
+		}
+
+		var sum float64 = 0.0
+		used := 0
+
+		for _, n := range neighbours {
+			if used == im.Neighbours {
+				break
+			}
+
+			value := im.Reference[n.index][i]
+			if !isMissing(value) {
+				sum += float64(value)
+				used++
+			}
+		}
+
+		if used > 0 {
+			filled[i] = float32(sum / float64(used))
+		}
+	}
+}
+
+// Converts the imputer into csv records.
+func (im *Imputer) records() [][]string {
+	records := [][]string{
+		{"strategy", string(im.Strategy)},
+		{"neighbours", strconv.Itoa(im.Neighbours)},
+		append([]string{"fill"}, formatFloats(im.Fill)...),
+	}
+
+	for _, reference := range im.Reference {
+		records = append(records, append([]string{"reference"}, formatFloats(reference)...))
+	}
+
+	return records
+}
+
+// Checks that every reference entry of the k-NN imputer has
+// the fitted features.
+func (im *Imputer) check() error {
+	for i, reference := range im.Reference {
+		if len(reference) < len(im.Fill) {
+			return fmt.Errorf("bp7: the imputer reference %d has %d features, expected %d", i, len(reference), len(im.Fill))
+		}
+	}
+
+	return nil
+}
+
+// Restores the imputer from its csv records.
+func (im *Imputer) parseRecords(records [][]string) error {
+	for _, record := range records {
+		if len(record) < 2 {
+			return fmt.Errorf("bp7: invalid imputer record %v", record)
+		}
+
+		var err error
+
+		switch record[0] {
+		case "strategy":
+			im.Strategy = ImputeStrategy(record[1])
+		case "neighbours":
+			im.Neighbours, err = strconv.Atoi(record[1])
+		case "fill":
+			im.Fill, err = parseFloats(record[1:])
+		case "reference":
+			var reference []float32
+			reference, err = parseFloats(record[1:])
+			im.Reference = append(im.Reference, reference)
+		default:
+			err = fmt.Errorf("bp7: unknown imputer record %q", record[0])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Fits an imputer on training features. The missing values
+// of the features must be NaN.
+// -Input features: The training feature vectors.
+// -Input config: The imputation strategy and its parameters.
+// -Output: The fitted imputer.
+func FitImputer(features [][]float32, config ImputerConfig) (*Imputer, error) {
+	if len(features) == 0 {
+		return nil, errors.New("bp7: there are no features to fit the imputer on")
+	}
+
+	imputer := &Imputer{Strategy: config.Strategy}
+	columns := len(features[0])
+
+	imputer.Fill = make([]float32, columns)
+
+	for i := 0; i < columns; i++ {
+		values := make([]float32, 0, len(features))
+
+		for _, entry := range features {
+			if !isMissing(entry[i]) {
+				values = append(values, entry[i])
+			}
+		}
+
+		if len(values) == 0 && config.Strategy != ImputeConstant {
+			return nil, fmt.Errorf("bp7: feature %d has no values to fit the imputer on", i)
+		}
+
+		switch config.Strategy {
+		case ImputeMean, ImputeKNN:
+			imputer.Fill[i] = mean(values)
+		case ImputeMedian:
+			imputer.Fill[i] = quantile(sortedCopy(values), 0.5)
+		case ImputeMostFrequent:
+			imputer.Fill[i] = mostFrequent(values)
+		case ImputeConstant:
+			imputer.Fill[i] = config.Constant
+		default:
+			return nil, fmt.Errorf("bp7: unknown imputation strategy %q", config.Strategy)
+		}
+	}
+
+	if config.Strategy == ImputeKNN {
+		imputer.Neighbours = config.Neighbours
+		if imputer.Neighbours < 1 {
+			imputer.Neighbours = defaultImputeNeighbours
+		}
+
+		imputer.Reference = make([][]float32, len(features))
+		for i := 0; i < len(features); i++ {
+			imputer.Reference[i] = append([]float32(nil), features[i]...)
+		}
+	}
+
+	return imputer, nil
+}
+
+// Whether a value is missing.
+func isMissing(value float32) bool {
+	return value != value
+}
+
+// Calculates the mean of a vector.
+func mean(values []float32) float32 {
+	var sum float64 = 0.0
+
+	for _, value := range values {
+		sum += float64(value)
+	}
+
+	return float32(sum / float64(len(values)))
+}
+
+// Returns the most frequent value of a vector. If more than
+// one values are equally frequent, the lowest is returned.
+func mostFrequent(values []float32) float32 {
+	sorted := sortedCopy(values)
+
+	best, bestCount := sorted[0], 0
+
+	for start := 0; start < len(sorted); {
+		end := start
+		for end < len(sorted) && sorted[end] == sorted[start] {
+			end++
+		}
+
+		if end - start > bestCount {
+			best, bestCount = sorted[start], end - start
+		}
+
+		start = end
+	}
+
+	return best
+}
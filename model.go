@@ -0,0 +1,399 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The version of the model format which Save writes. Load
+// reads every earlier version too, by migrating it.
+//
+// Version 1 is the plain sectioned csv stream of the weights
+// and the components, without any header. Version 2 adds the
+// header record, the architecture, the metadata and the
+// checksum.
+const ModelFormatVersion = 2
+
+// The first field of the header record of a model file.
+const modelMagic = "bp7-model"
+
+// The loss of the training, which is the only one the
+// network implements.
+const squaredErrorLoss = "squared_error"
+
+// Upgrades the sections of a model file from a version to
+// the next one.
+type modelMigration func(sections []section) ([]section, error)
+
+// The migration of each older format version.
+var modelMigrations = map[int]modelMigration{
+	1: migrateModelV1,
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Saves the network as a single self-describing model file:
+// the format version, the architecture, the metadata, the
+// weights, the attached components, like the scaler and the
+// class labels, and a CRC-32 checksum.
+// -Input w: The writer of the model.
+func (n *Network) Save(w io.Writer) error {
+	return writeModel(w, n, nil)
+}
+
+// Loads a model file written by Save, of the current or of
+// an older format version, into the network. The attached
+// components are replaced by the ones of the file.
+// -Input r: The reader of the model.
+func (n *Network) Load(r io.Reader) error {
+	sections, err := readModel(r)
+	if err != nil {
+		return err
+	}
+
+	rest, err := n.parseModel(sections)
+	if err != nil {
+		return err
+	}
+
+	if len(rest) > 0 {
+		return fmt.Errorf("bp7: unknown model section %q", rest[0].name)
+	}
+
+	return nil
+}
+
+// Saves the network into a model file.
+// -Input filePath: The path of the file.
+func (n *Network) SaveFile(filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := n.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Loads a model file into the network.
+// -Input filePath: The path of the file.
+func (n *Network) LoadFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return n.Load(file)
+}
+
+// Records the parameters of a training in the metadata.
+func (n *Network) recordTraining(samples int, learningRate float32, epochs int) {
+	if n.Metadata == nil {
+		n.Metadata = make(map[string]string)
+	}
+
+	n.Metadata["samples"] = strconv.Itoa(samples)
+	n.Metadata["learning_rate"] = strconv.FormatFloat(float64(learningRate), 'g', -1, 32)
+	n.Metadata["epochs"] = strconv.Itoa(epochs)
+
+	if n.QuantizationTraining != nil {
+		n.Metadata["quantization"] = string(n.QuantizationTraining.Config.Granularity)
+	}
+}
+
+// Returns the architecture section of the network.
+func (n *Network) architecture() section {
+	return section{"architecture", [][]string{
+		{"inputs", strconv.Itoa(n.inputCount())},
+		{"hidden", strconv.Itoa(len(n.HiddenLayer.Neurons))},
+		{"outputs", strconv.Itoa(len(n.OutputLayer.Neurons))},
+		{"hidden_activation", string(n.HiddenLayer.Activation.name())},
+		{"output_activation", string(n.OutputLayer.Activation.name())},
+		{"loss", squaredErrorLoss},
+	}}
+}
+
+// Restores the network from the sections of a model file,
+// after it checks the weights against the architecture.
+// -Input sections: The sections of the model.
+// -Output: The sections which do not belong to the network.
+func (n *Network) parseModel(sections []section) ([]section, error) {
+	n.Calibration = nil
+	n.Threshold = nil
+	n.Imputer = nil
+	n.Scaler = nil
+	n.Encoding = nil
+	n.Labels = nil
+	n.Metadata = nil
+
+	sizes := make(map[string]int)
+	activations := make(map[string]Activation)
+	rest := make([]section, 0)
+
+	for _, s := range sections {
+		switch s.name {
+		case "architecture":
+			for _, record := range s.records {
+				if len(record) != 2 {
+					return nil, fmt.Errorf("bp7: invalid architecture record %v", record)
+				}
+
+				switch record[0] {
+				case "inputs", "hidden", "outputs":
+					size, err := strconv.Atoi(record[1])
+					if err != nil {
+						return nil, fmt.Errorf("bp7: invalid architecture record %v", record)
+					}
+
+					sizes[record[0]] = size
+				case "hidden_activation", "output_activation":
+					if err := Activation(record[1]).check(); err != nil {
+						return nil, err
+					}
+
+					activations[record[0]] = Activation(record[1])
+				case "loss":
+					if record[1] != squaredErrorLoss {
+						return nil, fmt.Errorf("bp7: unsupported loss %q", record[1])
+					}
+				default:
+					return nil, fmt.Errorf("bp7: unknown architecture record %q", record[0])
+				}
+			}
+		default:
+			known, err := n.parseSection(s)
+			if err != nil {
+				return nil, err
+			}
+
+			if !known {
+				rest = append(rest, s)
+			}
+		}
+	}
+
+	n.HiddenLayer.Activation = activations["hidden_activation"]
+	n.OutputLayer.Activation = activations["output_activation"]
+
+	if err := checkLayer(n.HiddenLayer.Neurons, sizes["hidden"], sizes["inputs"]); err != nil {
+		return nil, fmt.Errorf("bp7: the hidden layer %v", err)
+	}
+
+	if err := checkLayer(n.OutputLayer.Neurons, sizes["outputs"], sizes["hidden"]); err != nil {
+		return nil, fmt.Errorf("bp7: the output layer %v", err)
+	}
+
+	if err := n.checkComponents(); err != nil {
+		return nil, err
+	}
+
+	return rest, nil
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Saves a network as a single self-describing model file.
+// -Input n: A network.
+// -Input w: The writer of the model.
+func Save(n *Network, w io.Writer) error {
+	return n.Save(w)
+}
+
+// Loads a model file of any format version into a network.
+// -Input n: A network.
+// -Input r: The reader of the model.
+func Load(n *Network, r io.Reader) error {
+	return n.Load(r)
+}
+
+// Saves a network into a model file.
+// -Input n: A network.
+// -Input filePath: The path of the file.
+func SaveFile(n *Network, filePath string) error {
+	return n.SaveFile(filePath)
+}
+
+// Loads a model file into a network.
+// -Input n: A network.
+// -Input filePath: The path of the file.
+func LoadFile(n *Network, filePath string) error {
+	return n.LoadFile(filePath)
+}
+
+// Writes a network and any extra sections, like the ones of
+// a pipeline, in the current model format.
+// -Input w: The writer of the model.
+// -Input n: The network.
+// -Input extra: The sections which follow the network.
+func writeModel(w io.Writer, n *Network, extra []section) error {
+	if len(n.HiddenLayer.Neurons) == 0 || len(n.OutputLayer.Neurons) == 0 {
+		return ErrUninitializedNetwork
+	}
+
+	var buffer bytes.Buffer
+
+	fmt.Fprintf(&buffer, "%s,%d\n", modelMagic, ModelFormatVersion)
+
+	sections := append([]section{n.architecture()}, n.sections()...)
+
+	if err := writeSections(&buffer, append(sections, extra...)); err != nil {
+		return err
+	}
+
+	checksum := fmt.Sprintf("%08x", crc32.ChecksumIEEE(buffer.Bytes()))
+
+	if err := writeSections(&buffer, []section{{"checksum", [][]string{{"crc32", checksum}}}}); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buffer.Bytes())
+
+	return err
+}
+
+// Reads the sections of a model file and migrates them to
+// the current format version. The checksum is verified and
+// dropped.
+// -Input r: The reader of the model.
+// -Output: The sections of the current format version.
+func readModel(r io.Reader) ([]section, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	version := 1
+
+	if !bytes.HasPrefix(data, []byte("section,")) {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return nil, errors.New("bp7: the model has no header")
+		}
+
+		header := strings.Split(strings.TrimRight(string(data[:end]), "\r"), ",")
+		if len(header) != 2 || header[0] != modelMagic {
+			return nil, errors.New("bp7: the stream is not a bp7 model")
+		}
+
+		version, err = strconv.Atoi(header[1])
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("bp7: invalid model version %q", header[1])
+		}
+
+		if version > ModelFormatVersion {
+			return nil, fmt.Errorf("bp7: the model version %d is newer than the supported version %d", version, ModelFormatVersion)
+		}
+
+		footer := bytes.LastIndex(data, []byte("section,checksum\n"))
+		if footer < 0 {
+			return nil, errors.New("bp7: the model has no checksum")
+		}
+
+		checksums, err := readSections(bytes.NewReader(data[footer:]))
+		if err != nil {
+			return nil, err
+		}
+
+		records := checksums[0].records
+		if len(records) != 1 || len(records[0]) != 2 || records[0][0] != "crc32" {
+			return nil, errors.New("bp7: invalid model checksum")
+		}
+
+		if expected := fmt.Sprintf("%08x", crc32.ChecksumIEEE(data[:footer])); records[0][1] != expected {
+			return nil, fmt.Errorf("bp7: the model checksum %s does not match %s, the file is corrupted", records[0][1], expected)
+		}
+
+		data = data[end + 1:footer]
+	}
+
+	sections, err := readSections(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	for ; version < ModelFormatVersion; version++ {
+		sections, err = modelMigrations[version](sections)
+		if err != nil {
+			return nil, fmt.Errorf("bp7: migrating the model version %d: %v", version, err)
+		}
+	}
+
+	return sections, nil
+}
+
+// Migrates a version 1 model, which has no architecture, by
+// inferring the architecture from the layer weights.
+func migrateModelV1(sections []section) ([]section, error) {
+	var hidden, output [][]string
+
+	for _, s := range sections {
+		switch s.name {
+		case "hidden":
+			hidden = s.records
+		case "output":
+			output = s.records
+		}
+	}
+
+	if len(hidden) == 0 || len(output) == 0 {
+		return nil, errors.New("the model has no layer weights")
+	}
+
+	architecture := section{"architecture", [][]string{
+		{"inputs", strconv.Itoa(len(hidden[0]) - 1)},
+		{"hidden", strconv.Itoa(len(hidden))},
+		{"outputs", strconv.Itoa(len(output))},
+		{"hidden_activation", string(SigmoidActivation)},
+		{"output_activation", string(SigmoidActivation)},
+		{"loss", squaredErrorLoss},
+	}}
+
+	return append([]section{architecture}, sections...), nil
+}
+
+// Checks that a layer has the declared neurons and that
+// each neuron has a weight per input plus the bias.
+func checkLayer(neurons []Neuron, size int, inputs int) error {
+	if len(neurons) == 0 || len(neurons) != size {
+		return fmt.Errorf("has %d neurons, expected %d", len(neurons), size)
+	}
+
+	for i, neuron := range neurons {
+		if len(neuron.Weights) != inputs + 1 {
+			return fmt.Errorf("neuron %d has %d weights, expected %d", i, len(neuron.Weights), inputs + 1)
+		}
+	}
+
+	return nil
+}
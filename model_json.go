@@ -0,0 +1,424 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The version of the JSON model schema, which is published
+// in model.schema.json.
+const JSONModelVersion = 1
+
+// The JSON model. Unlike the neuron weights, every layer
+// keeps its bias apart from its weight matrix.
+type jsonModel struct {
+	Format        string             `json:"format"`
+	Version       int                `json:"version"`
+	Inputs        int                `json:"inputs"`
+	Loss          string             `json:"loss"`
+	Layers        []jsonLayer        `json:"layers"`
+	ClassLabels   []string           `json:"class_labels,omitempty"`
+	Metadata      map[string]string  `json:"metadata,omitempty"`
+	Preprocessing *jsonPreprocessing `json:"preprocessing,omitempty"`
+	Calibration   *jsonCalibration   `json:"calibration,omitempty"`
+	Threshold     *jsonThreshold     `json:"threshold,omitempty"`
+}
+
+// A dense layer: output = activation(weights · input + bias).
+// The weight matrix has one row per unit and one column per
+// input of the layer.
+type jsonLayer struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Activation string      `json:"activation"`
+	Inputs     int         `json:"inputs"`
+	Units      int         `json:"units"`
+	Weights    [][]float32 `json:"weights"`
+	Bias       []float32   `json:"bias"`
+}
+
+// The preprocessing of the raw inputs: the encoders of the
+// categorical columns, whose features follow the numeric
+// ones, then the imputer and then the scaler.
+type jsonPreprocessing struct {
+	Encoders []jsonEncoder `json:"encoders,omitempty"`
+	Imputer  *jsonImputer  `json:"imputer,omitempty"`
+	Scaler   *jsonScaler   `json:"scaler,omitempty"`
+}
+
+// A categorical column encoder. Only the fields of its type
+// are set.
+type jsonEncoder struct {
+	Column      string      `json:"column"`
+	Type        string      `json:"type"`
+	Categories  []string    `json:"categories,omitempty"`
+	Unknown     string      `json:"unknown,omitempty"`
+	Frequencies []float32   `json:"frequencies,omitempty"`
+	Smoothing   float32     `json:"smoothing,omitempty"`
+	Prior       []float32   `json:"prior,omitempty"`
+	Means       [][]float32 `json:"means,omitempty"`
+	Features    int         `json:"features,omitempty"`
+}
+
+type jsonImputer struct {
+	Strategy   string       `json:"strategy"`
+	Fill       []float32    `json:"fill"`
+	Neighbours int          `json:"neighbours,omitempty"`
+	Reference  []jsonFloats `json:"reference,omitempty"`
+}
+
+type jsonScaler struct {
+	Method string    `json:"method"`
+	Offset []float32 `json:"offset"`
+	Scale  []float32 `json:"scale"`
+}
+
+type jsonCalibration struct {
+	Method      string      `json:"method"`
+	A           []float32   `json:"a,omitempty"`
+	B           []float32   `json:"b,omitempty"`
+	Temperature float32     `json:"temperature,omitempty"`
+	Thresholds  [][]float32 `json:"thresholds,omitempty"`
+	Values      [][]float32 `json:"values,omitempty"`
+}
+
+type jsonThreshold struct {
+	Value     float32 `json:"value"`
+	Criterion string  `json:"criterion"`
+	Target    float32 `json:"target,omitempty"`
+}
+
+// A vector whose missing (NaN) values are null in JSON.
+type jsonFloats []float32
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Writes the missing values as null.
+func (f jsonFloats) MarshalJSON() ([]byte, error) {
+	values := make([]*float32, len(f))
+
+	for i := range f {
+		if !isMissing(f[i]) {
+			values[i] = &f[i]
+		}
+	}
+
+	return json.Marshal(values)
+}
+
+// Reads the null values as missing.
+func (f *jsonFloats) UnmarshalJSON(data []byte) error {
+	var values []*float32
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	*f = make(jsonFloats, len(values))
+
+	for i, value := range values {
+		(*f)[i] = float32(math.NaN())
+		if value != nil {
+			(*f)[i] = *value
+		}
+	}
+
+	return nil
+}
+
+// Saves the network as a human readable JSON model, which
+// follows the schema of model.schema.json.
+// -Input w: The writer of the model.
+func (n *Network) SaveJSON(w io.Writer) error {
+	if len(n.HiddenLayer.Neurons) == 0 || len(n.OutputLayer.Neurons) == 0 {
+		return ErrUninitializedNetwork
+	}
+
+	model := jsonModel{
+		Format:   modelMagic,
+		Version:  JSONModelVersion,
+		Inputs:   n.inputCount(),
+		Loss:     squaredErrorLoss,
+		Metadata: n.Metadata,
+		Layers: []jsonLayer{
+			denseLayer("hidden", n.HiddenLayer.Neurons, n.HiddenLayer.Activation),
+			denseLayer("output", n.OutputLayer.Neurons, n.OutputLayer.Activation),
+		},
+	}
+
+	if n.Labels != nil {
+		model.ClassLabels = n.Labels.Labels
+	}
+
+	if n.Imputer != nil || n.Scaler != nil || n.Encoding != nil {
+		model.Preprocessing = &jsonPreprocessing{}
+	}
+
+	if n.Encoding != nil {
+		for _, column := range n.Encoding.Columns {
+			model.Preprocessing.Encoders = append(model.Preprocessing.Encoders, encoderJSON(column))
+		}
+	}
+
+	if im := n.Imputer; im != nil {
+		model.Preprocessing.Imputer = &jsonImputer{string(im.Strategy), im.Fill, im.Neighbours, nil}
+
+		for _, reference := range im.Reference {
+			model.Preprocessing.Imputer.Reference = append(model.Preprocessing.Imputer.Reference, reference)
+		}
+	}
+
+	if s := n.Scaler; s != nil {
+		model.Preprocessing.Scaler = &jsonScaler{string(s.Method), s.Offset, s.Scale}
+	}
+
+	if c := n.Calibration; c != nil {
+		model.Calibration = &jsonCalibration{string(c.Method), c.A, c.B, c.Temperature, c.Thresholds, c.Values}
+	}
+
+	if t := n.Threshold; t != nil {
+		model.Threshold = &jsonThreshold{t.Value, string(t.Criterion), t.Target}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(model)
+}
+
+// Loads a JSON model written by SaveJSON, or by any tool
+// which follows model.schema.json, into the network.
+// -Input r: The reader of the model.
+func (n *Network) LoadJSON(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+
+	model := jsonModel{}
+	if err := decoder.Decode(&model); err != nil {
+		return err
+	}
+
+	if model.Format != modelMagic {
+		return errors.New("bp7: the JSON is not a bp7 model")
+	}
+
+	if model.Version < 1 || model.Version > JSONModelVersion {
+		return fmt.Errorf("bp7: unsupported JSON model version %d", model.Version)
+	}
+
+	if model.Loss != squaredErrorLoss {
+		return fmt.Errorf("bp7: unsupported loss %q", model.Loss)
+	}
+
+	if len(model.Layers) != 2 {
+		return fmt.Errorf("bp7: the model has %d layers, expected a hidden and an output layer", len(model.Layers))
+	}
+
+	hidden, err := model.Layers[0].neurons()
+	if err != nil {
+		return err
+	}
+
+	output, err := model.Layers[1].neurons()
+	if err != nil {
+		return err
+	}
+
+	if err := checkLayer(hidden, model.Layers[0].Units, model.Inputs); err != nil {
+		return fmt.Errorf("bp7: the hidden layer %v", err)
+	}
+
+	if err := checkLayer(output, model.Layers[1].Units, model.Layers[0].Units); err != nil {
+		return fmt.Errorf("bp7: the output layer %v", err)
+	}
+
+	loaded := Network{Metadata: model.Metadata}
+	loaded.HiddenLayer = HiddenLayer{hidden, Activation(model.Layers[0].Activation)}
+	loaded.OutputLayer = OutputLayer{output, Activation(model.Layers[1].Activation)}
+
+	if model.ClassLabels != nil {
+		loaded.Labels = &LabelEncoder{Labels: model.ClassLabels}
+	}
+
+	if p := model.Preprocessing; p != nil {
+		if len(p.Encoders) > 0 {
+			loaded.Encoding = &CategoricalEncoding{}
+
+			for _, e := range p.Encoders {
+				column, err := e.columnEncoder()
+				if err != nil {
+					return err
+				}
+
+				loaded.Encoding.Columns = append(loaded.Encoding.Columns, column)
+			}
+		}
+
+		if im := p.Imputer; im != nil {
+			loaded.Imputer = &Imputer{Strategy: ImputeStrategy(im.Strategy), Fill: im.Fill, Neighbours: im.Neighbours}
+
+			for _, reference := range im.Reference {
+				loaded.Imputer.Reference = append(loaded.Imputer.Reference, reference)
+			}
+		}
+
+		if s := p.Scaler; s != nil {
+			if len(s.Offset) != len(s.Scale) {
+				return errors.New("bp7: the scaler offsets and scales differ in length")
+			}
+
+			loaded.Scaler = &Scaler{ScaleMethod(s.Method), s.Offset, s.Scale}
+		}
+	}
+
+	if c := model.Calibration; c != nil {
+		loaded.Calibration = &Calibrator{CalibrationMethod(c.Method), c.A, c.B, c.Temperature, c.Thresholds, c.Values}
+	}
+
+	if t := model.Threshold; t != nil {
+		loaded.Threshold = &DecisionThreshold{t.Value, ThresholdCriterion(t.Criterion), t.Target}
+	}
+
+	if err := loaded.checkComponents(); err != nil {
+		return err
+	}
+
+	*n = loaded
+
+	return nil
+}
+
+// Converts a dense JSON layer into neurons, whose last
+// weight is the bias.
+func (l jsonLayer) neurons() ([]Neuron, error) {
+	if l.Type != "dense" {
+		return nil, fmt.Errorf("bp7: unsupported layer type %q", l.Type)
+	}
+
+	if err := Activation(l.Activation).check(); err != nil {
+		return nil, err
+	}
+
+	if len(l.Weights) != len(l.Bias) {
+		return nil, fmt.Errorf("bp7: the layer %q has %d weight rows but %d biases", l.Name, len(l.Weights), len(l.Bias))
+	}
+
+	neurons := make([]Neuron, len(l.Weights))
+
+	for i, row := range l.Weights {
+		neurons[i].Weights = append(append([]float32(nil), row...), l.Bias[i])
+	}
+
+	return neurons, nil
+}
+
+// Restores a column encoder from its JSON form.
+func (e jsonEncoder) columnEncoder() (ColumnEncoder, error) {
+	encoder, err := createEncoder(e.Type)
+	if err != nil {
+		return ColumnEncoder{}, err
+	}
+
+	switch encoder := encoder.(type) {
+	case *OneHotEncoder:
+		encoder.Categories = e.Categories
+		encoder.Unknown = UnknownCategory(e.Unknown)
+	case *OrdinalEncoder:
+		encoder.Categories = e.Categories
+	case *FrequencyEncoder:
+		encoder.Categories = e.Categories
+		encoder.Frequencies = e.Frequencies
+	case *TargetEncoder:
+		encoder.Smoothing = e.Smoothing
+		encoder.Prior = e.Prior
+		encoder.Categories = e.Categories
+		encoder.Means = e.Means
+	case *HashingEncoder:
+		encoder.Features = e.Features
+	}
+
+	return ColumnEncoder{Column: e.Column, Encoder: encoder}, nil
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Saves a network as a human readable JSON model.
+// -Input n: A network.
+// -Input w: The writer of the model.
+func SaveJSON(n *Network, w io.Writer) error {
+	return n.SaveJSON(w)
+}
+
+// Loads a JSON model into a network.
+// -Input n: A network.
+// -Input r: The reader of the model.
+func LoadJSON(n *Network, r io.Reader) error {
+	return n.LoadJSON(r)
+}
+
+// Converts neurons into a dense JSON layer, splitting the
+// bias from the weights.
+func denseLayer(name string, neurons []Neuron, activation Activation) jsonLayer {
+	layer := jsonLayer{
+		Name:       name,
+		Type:       "dense",
+		Activation: string(activation.name()),
+		Units:      len(neurons),
+		Weights:    make([][]float32, len(neurons)),
+		Bias:       make([]float32, len(neurons)),
+	}
+
+	for i, neuron := range neurons {
+		last := len(neuron.Weights) - 1
+
+		layer.Inputs = last
+		layer.Weights[i] = neuron.Weights[:last]
+		layer.Bias[i] = neuron.Weights[last]
+	}
+
+	return layer
+}
+
+// Converts a column encoder into its JSON form.
+func encoderJSON(column ColumnEncoder) jsonEncoder {
+	e := jsonEncoder{Column: column.Column, Type: encoderKind(column.Encoder)}
+
+	switch encoder := column.Encoder.(type) {
+	case *OneHotEncoder:
+		e.Categories = encoder.Categories
+		e.Unknown = string(encoder.Unknown)
+	case *OrdinalEncoder:
+		e.Categories = encoder.Categories
+	case *FrequencyEncoder:
+		e.Categories = encoder.Categories
+		e.Frequencies = encoder.Frequencies
+	case *TargetEncoder:
+		e.Smoothing = encoder.Smoothing
+		e.Prior = encoder.Prior
+		e.Categories = encoder.Categories
+		e.Means = encoder.Means
+	case *HashingEncoder:
+		e.Features = encoder.Features
+	}
+
+	return e
+}
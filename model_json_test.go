@@ -0,0 +1,96 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestLoadJSONInconsistentComponents(t *testing.T) {
+	n, _ := codegenTestNetwork(t)
+
+	var saved bytes.Buffer
+	if err := n.SaveJSON(&saved); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := Network{}
+	if err := loaded.LoadJSON(bytes.NewReader(saved.Bytes())); err != nil {
+		t.Fatalf("LoadJSON() of the saved model = %v", err)
+	}
+
+	cases := map[string]func(m *jsonModel){
+		"calibration": func(m *jsonModel) {
+			m.Calibration = &jsonCalibration{Method: string(PlattScaling), A: []float32{1, 1}, B: []float32{0, 0}}
+		},
+		"isotonic": func(m *jsonModel) {
+			m.Calibration = &jsonCalibration{
+				Method:     string(IsotonicRegression),
+				Thresholds: [][]float32{{0.5}, {0.5}, {0.2, 0.8}},
+				Values:     [][]float32{{0.5}, {0.5}, {0.5}},
+			}
+		},
+		"target": func(m *jsonModel) {
+			m.Preprocessing.Encoders = append(m.Preprocessing.Encoders, jsonEncoder{
+				Column:     "shape",
+				Type:       "target",
+				Categories: []string{"round", "square"},
+				Prior:      []float32{0.5, 0.5},
+				Means:      [][]float32{{0.2, 0.8}},
+			})
+		},
+		"frequency": func(m *jsonModel) {
+			m.Preprocessing.Encoders = append(m.Preprocessing.Encoders, jsonEncoder{
+				Column:      "shape",
+				Type:        "frequency",
+				Categories:  []string{"round", "square"},
+				Frequencies: []float32{1},
+			})
+		},
+		"hashing": func(m *jsonModel) {
+			m.Preprocessing.Encoders = append(m.Preprocessing.Encoders, jsonEncoder{Column: "shape", Type: "hashing"})
+		},
+		"imputer": func(m *jsonModel) {
+			m.Preprocessing.Imputer.Strategy = string(ImputeKNN)
+			m.Preprocessing.Imputer.Neighbours = 1
+			m.Preprocessing.Imputer.Reference = []jsonFloats{{1, 2}}
+		},
+	}
+
+	for name, corrupt := range cases {
+		model := jsonModel{}
+		if err := json.Unmarshal(saved.Bytes(), &model); err != nil {
+			t.Fatal(err)
+		}
+
+		corrupt(&model)
+
+		data, err := json.Marshal(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		target := Network{}
+		if err := target.LoadJSON(bytes.NewReader(data)); err == nil {
+			t.Errorf("LoadJSON() accepted the inconsistent %s", name)
+		}
+
+		if target.Calibration != nil || len(target.OutputLayer.Neurons) > 0 {
+			t.Errorf("LoadJSON() changed the network after the inconsistent %s", name)
+		}
+	}
+}
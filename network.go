@@ -16,7 +16,6 @@ package bp7
 
 import(
 	"fmt"
-	"math"
 	"encoding/csv"
 	"io"
 	"log"
@@ -30,6 +29,34 @@ import(
 type Network struct {
 	HiddenLayer HiddenLayer
 	OutputLayer OutputLayer
+	// An optional calibrator of the output probabilities.
+	Calibration *Calibrator
+	// An optional decision threshold of a binary network.
+	Threshold *DecisionThreshold
+	// An optional imputer of the missing input values. It is
+	// applied on every row before it enters the network.
+	Imputer *Imputer
+	// An optional scaler of the input values. It is applied
+	// on every row after the imputer.
+	Scaler *Scaler
+	// The optional encoding of the categorical input columns.
+	// It is applied on the raw records before they become
+	// rows, so the network only keeps it in order to extract
+	// it together with the weights.
+	Encoding *CategoricalEncoding
+	// The optional labels of the output classes.
+	Labels *LabelEncoder
+	// The optional loss weight of each class. The update of a
+	// training row is scaled by the weight of its class, so
+	// that a rare class is not outweighed. See Dataset.BalancedClassWeights.
+	ClassWeights []float32
+	// The optional state of quantization-aware training. While
+	// it is set, the training simulates the int8 network of
+	// Quantize. See EnableQuantizationTraining.
+	QuantizationTraining *QuantizationTraining
+	// Free-form metadata which is saved with the model, like
+	// the parameters of the last training.
+	Metadata map[string]string
 }
 
 // ======================= //
@@ -71,11 +98,13 @@ func (n *Network) forwardPropagate(row []float32) []float32 {
 	hiddenNeurons := hiddenLayer.Neurons
 
 	for i := 0; i < len(hiddenNeurons); i++ {
-		output := hiddenNeurons[i].Transfer(inputs)
+		newHiddenLayerInputs = append(newHiddenLayerInputs, hiddenNeurons[i].activate(inputs))
+	}
 
-		hiddenNeurons[i].Output = output
+	newHiddenLayerInputs = hiddenLayer.Activation.apply(newHiddenLayerInputs)
 
-		newHiddenLayerInputs = append(newHiddenLayerInputs, output)
+	for i := 0; i < len(hiddenNeurons); i++ {
+		hiddenNeurons[i].Output = newHiddenLayerInputs[i]
 	}
 
 	inputs = newHiddenLayerInputs
@@ -85,11 +114,13 @@ func (n *Network) forwardPropagate(row []float32) []float32 {
 	outputNeurons := outputLayer.Neurons
 
 	for j := 0; j < len(outputNeurons); j++ {
-		output := outputNeurons[j].Transfer(inputs)
+		newOutputLayerInputs = append(newOutputLayerInputs, outputNeurons[j].activate(inputs))
+	}
 
-		outputNeurons[j].Output = output
+	newOutputLayerInputs = outputLayer.Activation.apply(newOutputLayerInputs)
 
-		newOutputLayerInputs = append(newOutputLayerInputs, output)
+	for j := 0; j < len(outputNeurons); j++ {
+		outputNeurons[j].Output = newOutputLayerInputs[j]
 	}
 
 	finalOutput := newOutputLayerInputs
@@ -110,8 +141,10 @@ func (n *Network) backPropagate(expected []float32) {
 	}
 
 	// We assign each error to the delta variable of each output neuron.
+	outputDeltas := n.OutputLayer.Activation.deltas(layerOutputs(n.OutputLayer.Neurons), outputLayerError)
+
 	for i := 0; i < len(n.OutputLayer.Neurons); i++ {
-		n.OutputLayer.Neurons[i].Delta = outputLayerError[i] * outputDerivative(n.OutputLayer.Neurons[i].Output)
+		n.OutputLayer.Neurons[i].Delta = outputDeltas[i]
 	}
 
 	// We propagate the error to the hidden layer.
@@ -129,8 +162,10 @@ func (n *Network) backPropagate(expected []float32) {
 	}
 
 	// We assign each error to the delta variable of each hidden layer neuron.
+	hiddenDeltas := n.HiddenLayer.Activation.deltas(layerOutputs(n.HiddenLayer.Neurons), hiddenLayerErrors[:len(n.HiddenLayer.Neurons)])
+
 	for i := 0; i < len(n.HiddenLayer.Neurons); i++ {
-		n.HiddenLayer.Neurons[i].Delta = hiddenLayerErrors[i] * outputDerivative(n.HiddenLayer.Neurons[i].Output)
+		n.HiddenLayer.Neurons[i].Delta = hiddenDeltas[i]
 	}
 }
 
@@ -173,53 +208,19 @@ func (n *Network) updateWeights(row []float32, learningRate float32) {
 // -Input outputCount: How many classification categories exist
 // for the given training set (this should match the output neurons).
 func (n *Network) Train(trainSet [][]float32, learningRate float32, epochs int, outputCount int) {
-	for i := 0; i < epochs; i++ {
-		var sumError float32 = 0.0
-
-		for j := 0; j < len(trainSet); j++ {
-			row := trainSet[j]
-			// Forward propagating the output.
-			outputs := n.forwardPropagate(row)
-
-			// The expected array contains only zeros.
-			expected := make([]float32, 0)
-			for k := 0; k < outputCount; k++ {
-				expected = append(expected, 0)
-			}
-
-			//fmt.Print("Outputs: ")
-			//fmt.Println(outputs)
-
-			// We assign '1' to the index of the classification value.
-			// In example if the classification array is [0, 1, 2, 3]
-			// and the class of the row is 2, we want to modify the
-			// expected array in order to make it [0, 0, 1, 0].
-			expected[int(row[len(row) - 1])] = 1
+	// The rows are one mini-batch of an in-memory source, so
+	// that every way of training shares the loop of TrainSource.
+	source := CreateSliceSource(trainSet, len(trainSet))
 
-			//fmt.Print("Expected: ")
-			//fmt.Println(expected)
-
-			var error float32 = 0.0
-			for k := 0; k < len(expected); k++ {
-				error += float32(math.Pow(float64(expected[k] - outputs[k]), 2))
-			}
-			sumError += error
-
-			// Backwards propagating the error.
-			n.backPropagate(expected)
-			// Updating the weight of each neuron of each layer.
-			n.updateWeights(row, learningRate)
-		}
-
-		fmt.Printf("+Epoch: %d, Learning rate: %.2f, Error: %.2f", i, learningRate, sumError)
-		fmt.Println()
+	if err := n.TrainSource(source, learningRate, epochs, outputCount); err != nil {
+		panic(err)
 	}
 }
 
 // Given an input row, it predicts the output categorization.
 // -Input row: An entry to predict the category.
 func (n *Network) Predict(row []float32) int {
-	outputs := n.forwardPropagate(row)
+	outputs := n.forwardPropagate(n.preprocess(row))
 	fmt.Print("Predicted outputs: ")
 	fmt.Println(outputs)
 
@@ -247,7 +248,91 @@ func (n *Network) Predict(row []float32) int {
 	return 0
 }
 
+// Given an input row, it returns the output of each output
+// neuron normalized so that the values sum up to one. The
+// value at index i can be read as the confidence of the
+// network that the entry belongs to the class i. If the
+// network is calibrated, the calibrated probabilities are
+// returned instead.
+// -Input row: An entry to predict the category.
+// -Output: The normalized output vector of the network, or an
+// error if the calibrator does not match the network.
+func (n *Network) PredictProbabilities(row []float32) ([]float32, error) {
+	return n.probabilities(n.infer(row))
+}
+
+// Turns the raw output vector of the network into
+// probabilities. If the network has a calibrator the
+// probabilities are calibrated, otherwise the outputs
+// are just normalized.
+// -Input outputs: The output vector of the network.
+// -Output: The probability of each class.
+func (n *Network) probabilities(outputs []float32) ([]float32, error) {
+	if n.Calibration != nil {
+		return n.Calibration.Apply(outputs)
+	}
+
+	return normalize(outputs), nil
+}
+
+// Propagates an input row through the network without
+// storing the neuron outputs. Unlike forwardPropagate it
+// does not modify the network, so it is safe to call it
+// from many goroutines at the same time.
+// -Input row: An entry row of the dataset array.
+// -Output: The final output of the network.
+func (n *Network) infer(row []float32) []float32 {
+	row = n.preprocess(row)
+
+	hiddenOutputs := make([]float32, len(n.HiddenLayer.Neurons))
+
+	for i := 0; i < len(n.HiddenLayer.Neurons); i++ {
+		hiddenOutputs[i] = n.HiddenLayer.Neurons[i].activate(row)
+	}
+
+	hiddenOutputs = n.HiddenLayer.Activation.apply(hiddenOutputs)
+
+	outputs := make([]float32, len(n.OutputLayer.Neurons))
+
+	for i := 0; i < len(n.OutputLayer.Neurons); i++ {
+		outputs[i] = n.OutputLayer.Neurons[i].activate(hiddenOutputs)
+	}
+
+	return n.OutputLayer.Activation.apply(outputs)
+}
+
+// Applies the preprocessing steps attached to the network,
+// the imputer and then the scaler, on an input row. Without
+// any steps the row itself is returned.
+// -Input row: An entry row of the dataset array.
+// -Output: The preprocessed row.
+func (n *Network) preprocess(row []float32) []float32 {
+	if n.Imputer != nil {
+		row = n.Imputer.Transform(row)
+	}
+
+	if n.Scaler != nil {
+		row = n.Scaler.Transform(row)
+	}
+
+	return row
+}
+
+// Returns how many inputs the network expects, which is
+// the weight count of a hidden neuron minus the bias.
+// If the network is not initialized it returns zero.
+func (n *Network) inputCount() int {
+	if len(n.HiddenLayer.Neurons) == 0 {
+		return 0
+	}
+
+	return len(n.HiddenLayer.Neurons[0].Weights) - 1
+}
+
 // Extracts the hidden layer and the output layer neuron weights.
+// The layer activations and the attached components, like the
+// calibrator and the decision threshold, are extracted into
+// their own csv files.
 func (n *Network) Extract() {
 	hiddenLayer := n.HiddenLayer
 	outputLayer := n.OutputLayer
@@ -303,9 +388,12 @@ func (n *Network) Extract() {
 			panic("Fail to write hidden layer weight to file")
 		}
 	}
+
+	n.extractComponents()
 }
 
 // Imports the hidden and the output layer neuron weights into the network.
+// The extracted components are imported by ImportComponents.
 // -Input hiddenFilePath: The hidden layer neuron weights file path.
 // -Inout outputFilePath: The output layer neuron weights file path.
 func (n *Network) Import(hiddenFilePath string, outputFilePath string) {
@@ -431,11 +519,13 @@ func forwardPropagate(n *Network, row []float32) []float32 {
 	hiddenNeurons := hiddenLayer.Neurons
 
 	for i := 0; i < len(hiddenNeurons); i++ {
-		output := Transfer(&hiddenNeurons[i], inputs)
+		newHiddenLayerInputs = append(newHiddenLayerInputs, hiddenNeurons[i].activate(inputs))
+	}
 
-		hiddenNeurons[i].Output = output
+	newHiddenLayerInputs = hiddenLayer.Activation.apply(newHiddenLayerInputs)
 
-		newHiddenLayerInputs = append(newHiddenLayerInputs, output)
+	for i := 0; i < len(hiddenNeurons); i++ {
+		hiddenNeurons[i].Output = newHiddenLayerInputs[i]
 	}
 
 	inputs = newHiddenLayerInputs
@@ -445,11 +535,13 @@ func forwardPropagate(n *Network, row []float32) []float32 {
 	outputNeurons := outputLayer.Neurons
 
 	for j := 0; j < len(outputNeurons); j++ {
-		output := Transfer(&outputNeurons[j], inputs)
+		newOutputLayerInputs = append(newOutputLayerInputs, outputNeurons[j].activate(inputs))
+	}
 
-		outputNeurons[j].Output = output
+	newOutputLayerInputs = outputLayer.Activation.apply(newOutputLayerInputs)
 
-		newOutputLayerInputs = append(newOutputLayerInputs, output)
+	for j := 0; j < len(outputNeurons); j++ {
+		outputNeurons[j].Output = newOutputLayerInputs[j]
 	}
 
 	inputs = newOutputLayerInputs
@@ -469,8 +561,10 @@ func backPropagate(n *Network, expected []float32) {
 		outputLayerError = append(outputLayerError, error)
 	}
 
+	outputDeltas := n.OutputLayer.Activation.deltas(layerOutputs(n.OutputLayer.Neurons), outputLayerError)
+
 	for i := 0; i < len(n.OutputLayer.Neurons); i++ {
-		n.OutputLayer.Neurons[i].Delta = outputLayerError[i] * outputDerivative(n.OutputLayer.Neurons[i].Output)
+		n.OutputLayer.Neurons[i].Delta = outputDeltas[i]
 	}
 
 	hiddenLayerErrors := make([]float32, 0)
@@ -484,8 +578,10 @@ func backPropagate(n *Network, expected []float32) {
 		}
 	}
 
+	hiddenDeltas := n.HiddenLayer.Activation.deltas(layerOutputs(n.HiddenLayer.Neurons), hiddenLayerErrors[:len(n.HiddenLayer.Neurons)])
+
 	for i := 0; i < len(n.HiddenLayer.Neurons); i++ {
-		n.HiddenLayer.Neurons[i].Delta = hiddenLayerErrors[i] * outputDerivative(n.HiddenLayer.Neurons[i].Output)
+		n.HiddenLayer.Neurons[i].Delta = hiddenDeltas[i]
 	}
 }
 
@@ -529,46 +625,14 @@ func updateWeights(n *Network, row []float32, learningRate float32) {
 // -Input outputCount: How many classification categories exist
 // for the given training set (this should match the output neurons).
 func Train(n *Network, trainSet [][]float32, learningRate float32, epochs int, outputCount int) {
-	for i := 0; i < epochs; i++ {
-		var sumError float32 = 0.0
-
-		for j := 0; j < len(trainSet); j++ {
-			row := trainSet[j]
-			outputs := forwardPropagate(n, row)
-
-			expected := make([]float32, 0)
-			for k := 0; k < outputCount; k++ {
-				expected = append(expected, 0)
-			}
-
-			//fmt.Print("Outputs: ")
-			//fmt.Println(outputs)
-
-			expected[int(row[len(row) - 1])] = 1
-
-			//fmt.Print("Expected: ")
-			//fmt.Println(expected)
-
-			var error float32 = 0.0
-			for k := 0; k < len(expected); k++ {
-				error += float32(math.Pow(float64(expected[k] - outputs[k]), 2))
-			}
-			sumError += error
-
-			backPropagate(n, expected)
-			updateWeights(n, row, learningRate)
-		}
-
-		fmt.Printf("+Epoch: %d, Learning rate: %.2f, Error: %.2f", i, learningRate, sumError)
-		fmt.Println()
-	}
+	n.Train(trainSet, learningRate, epochs, outputCount)
 }
 
 // Given an input row, it predicts the output categorization.
 // -Input n: A network.
 // -Input row: An entry to predict the category.
 func Predict(n *Network, row []float32) int {
-	outputs := forwardPropagate(n, row)
+	outputs := forwardPropagate(n, n.preprocess(row))
 	fmt.Print("Predicted outputs: ")
 	fmt.Println(outputs)
 
@@ -589,7 +653,19 @@ func Predict(n *Network, row []float32) int {
 	return 0
 }
 
+// Given an input row, it returns the output of each output
+// neuron normalized so that the values sum up to one.
+// -Input n: A network.
+// -Input row: An entry to predict the category.
+// -Output: The normalized output vector of the network, or an
+// error if the calibrator does not match the network.
+func PredictProbabilities(n *Network, row []float32) ([]float32, error) {
+	return n.PredictProbabilities(row)
+}
+
 // Extracts the hidden layer and the output layer neuron weights.
+// The attached components, like the calibrator and the decision
+// threshold, are extracted into their own csv files.
 // -Input n: A network.
 func Extract(n *Network) {
 	hiddenLayer := n.HiddenLayer
@@ -646,9 +722,12 @@ func Extract(n *Network) {
 			panic("Fail to write hidden layer weight to file")
 		}
 	}
+
+	n.extractComponents()
 }
 
 // Imports the hidden and the output layer neuron weights into the network.
+// The extracted components are imported by ImportComponents.
 // -Input n: A network.
 // -Input hiddenFilePath: The hidden layer neuron weights file path.
 // -Inout outputFilePath: The output layer neuron weights file path.
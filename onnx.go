@@ -0,0 +1,937 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The ONNX IR version and the opset of the exported models,
+// which every ONNX Runtime release since 1.6 supports.
+const (
+	onnxIRVersion = 7
+	onnxOpset     = 13
+)
+
+// The names of the graph input and output of an exported
+// model.
+const (
+	ONNXInputName  = "input"
+	ONNXOutputName = "output"
+)
+
+// The ONNX operator of each activation.
+var onnxActivations = map[Activation]string{
+	SigmoidActivation:  "Sigmoid",
+	ReLUActivation:     "Relu",
+	TanhActivation:     "Tanh",
+	SoftmaxActivation:  "Softmax",
+	IdentityActivation: "Identity",
+}
+
+// The ONNX element type of float32 tensors.
+const onnxFloat = 1
+
+// The ONNX attribute type of integers.
+const onnxAttributeInt = 2
+
+// The field numbers of the ONNX messages, from onnx.proto.
+const (
+	onnxModelIRVersion     = 1
+	onnxModelProducerName  = 2
+	onnxModelProducerVer   = 3
+	onnxModelGraph         = 7
+	onnxModelOpsetImport   = 8
+	onnxModelMetadataProps = 14
+
+	onnxOpsetVersion = 2
+
+	onnxEntryKey   = 1
+	onnxEntryValue = 2
+
+	onnxGraphNode        = 1
+	onnxGraphName        = 2
+	onnxGraphInitializer = 5
+	onnxGraphInput       = 11
+	onnxGraphOutput      = 12
+
+	onnxNodeInput     = 1
+	onnxNodeOutput    = 2
+	onnxNodeName      = 3
+	onnxNodeOpType    = 4
+	onnxNodeAttribute = 5
+	onnxNodeDomain    = 7
+
+	onnxAttributeName = 1
+	onnxAttributeF    = 2
+	onnxAttributeI    = 3
+	onnxAttributeType = 20
+
+	onnxTensorDims      = 1
+	onnxTensorDataType  = 2
+	onnxTensorFloatData = 4
+	onnxTensorName      = 8
+	onnxTensorRawData   = 9
+	onnxTensorLocation  = 14
+
+	onnxValueName = 1
+	onnxValueType = 2
+
+	onnxTypeTensor     = 1
+	onnxTensorElemType = 1
+	onnxTensorShape    = 2
+	onnxShapeDim       = 1
+	onnxDimValue       = 1
+	onnxDimParam       = 2
+)
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Exports the network as an ONNX model, which ONNX Runtime
+// and the other ONNX tools can run. Each layer becomes a Gemm
+// node, with the weights and the biases as initializers,
+// followed by the node of its activation, like Sigmoid. The
+// graph takes a float tensor "input" of shape [N, inputs] and
+// returns the raw outputs of the output neurons as "output",
+// of shape [N, outputs]. A
+// scaler, other than the log scaler, is exported too, as Sub
+// and Div nodes before the first layer. The imputer, the
+// calibrator and the threshold are not part of the graph, so
+// the rows must not have missing values. The metadata of the
+// network is saved as the metadata properties of the model.
+// -Input w: The writer of the model.
+func (n *Network) SaveONNX(w io.Writer) error {
+	if len(n.HiddenLayer.Neurons) == 0 || len(n.OutputLayer.Neurons) == 0 {
+		return ErrUninitializedNetwork
+	}
+
+	inputs := n.inputCount()
+	outputs := len(n.OutputLayer.Neurons)
+
+	graph := &protoEncoder{}
+	graph.string(onnxGraphName, "bp7")
+
+	x := ONNXInputName
+
+	if n.Scaler != nil {
+		if n.Scaler.Method == LogScaling {
+			return errors.New("bp7: the log scaler cannot be exported to ONNX")
+		}
+
+		if len(n.Scaler.Offset) != inputs || len(n.Scaler.Scale) != inputs {
+			return errors.New("bp7: the scaler does not match the network inputs")
+		}
+
+		graph.message(onnxGraphInitializer, onnxTensor("scaler_offset", []int{inputs}, n.Scaler.Offset))
+		graph.message(onnxGraphInitializer, onnxTensor("scaler_scale", []int{inputs}, n.Scaler.Scale))
+		graph.message(onnxGraphNode, onnxNode("scaler_sub", "Sub", []string{x, "scaler_offset"}, "scaler_centered", nil))
+		graph.message(onnxGraphNode, onnxNode("scaler_div", "Div", []string{"scaler_centered", "scaler_scale"}, "scaled", nil))
+
+		x = "scaled"
+	}
+
+	layers := []struct {
+		name       string
+		neurons    []Neuron
+		activation Activation
+		output     string
+	}{
+		{"hidden", n.HiddenLayer.Neurons, n.HiddenLayer.Activation, "hidden"},
+		{"output", n.OutputLayer.Neurons, n.OutputLayer.Activation, ONNXOutputName},
+	}
+
+	for _, layer := range layers {
+		if err := layer.activation.check(); err != nil {
+			return err
+		}
+
+		size := len(layer.neurons[0].Weights) - 1
+		weights := make([]float32, 0, len(layer.neurons) * size)
+		biases := make([]float32, 0, len(layer.neurons))
+
+		for _, neuron := range layer.neurons {
+			weights = append(weights, neuron.Weights[:size]...)
+			biases = append(biases, neuron.Weights[size])
+		}
+
+		// The weights are stored as [neurons, inputs], the way
+		// the neurons keep them, so the Gemm transposes them.
+		graph.message(onnxGraphInitializer, onnxTensor(layer.name + "_weight", []int{len(layer.neurons), size}, weights))
+		graph.message(onnxGraphInitializer, onnxTensor(layer.name + "_bias", []int{len(layer.neurons)}, biases))
+
+		gemm := []string{x, layer.name + "_weight", layer.name + "_bias"}
+		graph.message(onnxGraphNode, onnxNode(layer.name + "_gemm", "Gemm", gemm, layer.name + "_linear", map[string]int64{"transB": 1}))
+		activation := onnxActivations[layer.activation.name()]
+		graph.message(onnxGraphNode, onnxNode(layer.name + "_" + string(layer.activation.name()), activation, []string{layer.name + "_linear"}, layer.output, nil))
+
+		x = layer.output
+	}
+
+	graph.message(onnxGraphInput, onnxValue(ONNXInputName, inputs))
+	graph.message(onnxGraphOutput, onnxValue(ONNXOutputName, outputs))
+
+	opset := &protoEncoder{}
+	opset.int(onnxOpsetVersion, onnxOpset)
+
+	model := &protoEncoder{}
+	model.int(onnxModelIRVersion, onnxIRVersion)
+	model.string(onnxModelProducerName, "bp7")
+	model.string(onnxModelProducerVer, "1")
+	model.message(onnxModelGraph, graph)
+	model.message(onnxModelOpsetImport, opset)
+
+	keys := make([]string, 0, len(n.Metadata))
+	for key := range n.Metadata {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		entry := &protoEncoder{}
+		entry.string(onnxEntryKey, key)
+		entry.string(onnxEntryValue, n.Metadata[key])
+
+		model.message(onnxModelMetadataProps, entry)
+	}
+
+	_, err := w.Write(model.data)
+
+	return err
+}
+
+// Exports the network into an ONNX model file.
+// -Input filePath: The path of the file, usually *.onnx.
+func (n *Network) SaveONNXFile(filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := n.SaveONNX(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Imports an ONNX model of a multilayer perceptron into the
+// network, so that a model trained elsewhere can be served or
+// trained further by bp7. The graph must be a chain from its
+// input to its output of exactly two dense layers, the hidden
+// and the output layer. A dense layer is a Gemm node, or a
+// MatMul node followed by an optional Add of the bias, and its
+// activation is the Sigmoid, Relu, Tanh or Softmax node which
+// follows it, or the identity without one. Sub and Div nodes
+// before the first layer become the scaler of the network and
+// Identity nodes are skipped. The weights must be float
+// initializers of the graph. The metadata properties become
+// the metadata of the network and the other components are
+// detached.
+// -Input r: The reader of the model.
+func (n *Network) LoadONNX(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	graph, metadata, err := decodeONNXModel(data)
+	if err != nil {
+		return err
+	}
+
+	unsupported := make([]string, 0)
+
+	for i, node := range graph.nodes {
+		if _, ok := onnxImportedOps[node.opType]; !ok || node.domain != "" && node.domain != "ai.onnx" {
+			unsupported = append(unsupported, fmt.Sprintf("%s (%s)", node.label(i), node.opType))
+		}
+	}
+
+	if len(unsupported) > 0 {
+		return fmt.Errorf("bp7: unsupported ONNX nodes: %s", strings.Join(unsupported, ", "))
+	}
+
+	// The tensors are checked after the nodes, so that a graph
+	// with the integer shape of a Reshape reports the Reshape.
+	names := make([]string, 0, len(graph.initializers))
+	for name := range graph.initializers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if graph.initializers[name].dataType != onnxFloat {
+			return fmt.Errorf("bp7: the ONNX initializer %q is not a float tensor", name)
+		}
+	}
+
+	current := ""
+	for _, input := range graph.inputs {
+		if _, ok := graph.initializers[input]; !ok {
+			current = input
+			break
+		}
+	}
+
+	if current == "" {
+		return errors.New("bp7: the ONNX graph has no input")
+	}
+
+	layers := make([]*onnxDenseLayer, 0)
+	scaler := &Scaler{Method: StandardScaling}
+
+	for i, node := range graph.nodes {
+		if len(node.inputs) == 0 || node.inputs[0] != current || len(node.outputs) != 1 {
+			return fmt.Errorf("bp7: the ONNX node %s does not continue the chain of %q", node.label(i), current)
+		}
+
+		var last *onnxDenseLayer
+		if len(layers) > 0 {
+			last = layers[len(layers) - 1]
+		}
+
+		switch node.opType {
+		case "Gemm", "MatMul":
+			layer, err := graph.denseLayer(node)
+			if err != nil {
+				return fmt.Errorf("bp7: the ONNX node %s: %v", node.label(i), err)
+			}
+
+			layers = append(layers, layer)
+		case "Add":
+			bias, err := graph.constant(node)
+			if err != nil || last == nil || last.activation != "" || last.biased {
+				return fmt.Errorf("bp7: the ONNX node %s is not the bias of a dense layer", node.label(i))
+			}
+
+			if err := last.addBias(bias); err != nil {
+				return fmt.Errorf("bp7: the ONNX node %s: %v", node.label(i), err)
+			}
+		case "Sub", "Div":
+			// The scaler maps x to (x - offset) / scale, so the
+			// subtraction cannot follow the division.
+			values, err := graph.constant(node)
+			if err != nil || last != nil || scaler.Scale != nil || node.opType == "Sub" && scaler.Offset != nil {
+				return fmt.Errorf("bp7: the ONNX node %s is not a scaling of the input", node.label(i))
+			}
+
+			if node.opType == "Sub" {
+				scaler.Offset = values
+			} else {
+				scaler.Scale = values
+			}
+		case "Identity":
+		default:
+			if last == nil || last.activation != "" {
+				return fmt.Errorf("bp7: the ONNX node %s does not follow a dense layer", node.label(i))
+			}
+
+			if axis, ok := node.ints["axis"]; ok && node.opType == "Softmax" && axis != 1 && axis != -1 {
+				return fmt.Errorf("bp7: the ONNX node %s applies the softmax on the axis %d", node.label(i), axis)
+			}
+
+			last.activation = onnxImportedOps[node.opType]
+		}
+
+		current = node.outputs[0]
+	}
+
+	if len(graph.outputs) == 0 || current != graph.outputs[0] {
+		return errors.New("bp7: the chain of the ONNX graph does not end at its output")
+	}
+
+	if len(layers) != 2 {
+		return fmt.Errorf("bp7: the ONNX graph has %d dense layers, but a network has a hidden and an output layer", len(layers))
+	}
+
+	for i, layer := range layers {
+		if len(layer.weights) == 0 {
+			return fmt.Errorf("bp7: the dense layer %d of the ONNX graph has no units", i)
+		}
+	}
+
+	if len(layers[1].weights[0]) != len(layers[0].weights) {
+		return fmt.Errorf("bp7: the output layer has %d inputs, but the hidden layer has %d neurons", len(layers[1].weights[0]), len(layers[0].weights))
+	}
+
+	// A dense layer without an activation node is linear.
+	for _, layer := range layers {
+		if layer.activation == "" {
+			layer.activation = IdentityActivation
+		}
+	}
+
+	loaded := Network{}
+	loaded.HiddenLayer = HiddenLayer{layers[0].neurons(), layers[0].activation}
+	loaded.OutputLayer = OutputLayer{layers[1].neurons(), layers[1].activation}
+
+	if len(metadata) > 0 {
+		loaded.Metadata = metadata
+	}
+
+	if scaler.Offset != nil || scaler.Scale != nil {
+		inputs := loaded.inputCount()
+
+		if scaler.Offset, err = broadcast(scaler.Offset, inputs, 0); err != nil {
+			return fmt.Errorf("bp7: the input offset %v", err)
+		}
+
+		if scaler.Scale, err = broadcast(scaler.Scale, inputs, 1); err != nil {
+			return fmt.Errorf("bp7: the input scale %v", err)
+		}
+
+		loaded.Scaler = scaler
+	}
+
+	*n = loaded
+
+	return nil
+}
+
+// Imports an ONNX model file into the network.
+// -Input filePath: The path of the file.
+func (n *Network) LoadONNXFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return n.LoadONNX(file)
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Exports a network as an ONNX model.
+// -Input n: A network.
+// -Input w: The writer of the model.
+func SaveONNX(n *Network, w io.Writer) error {
+	return n.SaveONNX(w)
+}
+
+// Exports a network into an ONNX model file.
+// -Input n: A network.
+// -Input filePath: The path of the file.
+func SaveONNXFile(n *Network, filePath string) error {
+	return n.SaveONNXFile(filePath)
+}
+
+// Imports an ONNX model of a multilayer perceptron into a
+// network.
+// -Input n: A network.
+// -Input r: The reader of the model.
+func LoadONNX(n *Network, r io.Reader) error {
+	return n.LoadONNX(r)
+}
+
+// Imports an ONNX model file into a network.
+// -Input n: A network.
+// -Input filePath: The path of the file.
+func LoadONNXFile(n *Network, filePath string) error {
+	return n.LoadONNXFile(filePath)
+}
+
+// Encodes a float tensor initializer.
+func onnxTensor(name string, dims []int, values []float32) *protoEncoder {
+	tensor := &protoEncoder{}
+
+	for _, dim := range dims {
+		tensor.int(onnxTensorDims, int64(dim))
+	}
+
+	tensor.int(onnxTensorDataType, onnxFloat)
+	tensor.floats(onnxTensorFloatData, values)
+	tensor.string(onnxTensorName, name)
+
+	return tensor
+}
+
+// Encodes a node with integer attributes.
+func onnxNode(name string, opType string, inputs []string, output string, attributes map[string]int64) *protoEncoder {
+	node := &protoEncoder{}
+
+	for _, input := range inputs {
+		node.string(onnxNodeInput, input)
+	}
+
+	node.string(onnxNodeOutput, output)
+	node.string(onnxNodeName, name)
+	node.string(onnxNodeOpType, opType)
+
+	names := make([]string, 0, len(attributes))
+	for attribute := range attributes {
+		names = append(names, attribute)
+	}
+
+	sort.Strings(names)
+
+	for _, attribute := range names {
+		encoded := &protoEncoder{}
+		encoded.string(onnxAttributeName, attribute)
+		encoded.int(onnxAttributeI, attributes[attribute])
+		encoded.int(onnxAttributeType, onnxAttributeInt)
+
+		node.message(onnxNodeAttribute, encoded)
+	}
+
+	return node
+}
+
+// Encodes the description of a graph input or output, a
+// float tensor of shape [N, size] with a dynamic batch size.
+func onnxValue(name string, size int) *protoEncoder {
+	batch := &protoEncoder{}
+	batch.string(onnxDimParam, "N")
+
+	features := &protoEncoder{}
+	features.int(onnxDimValue, int64(size))
+
+	shape := &protoEncoder{}
+	shape.message(onnxShapeDim, batch)
+	shape.message(onnxShapeDim, features)
+
+	tensor := &protoEncoder{}
+	tensor.int(onnxTensorElemType, onnxFloat)
+	tensor.message(onnxTensorShape, shape)
+
+	valueType := &protoEncoder{}
+	valueType.message(onnxTypeTensor, tensor)
+
+	value := &protoEncoder{}
+	value.string(onnxValueName, name)
+	value.message(onnxValueType, valueType)
+
+	return value
+}
+
+// The ONNX operators which the import understands, with the
+// activation of the activation operators.
+var onnxImportedOps = map[string]Activation{
+	"Gemm":     "",
+	"MatMul":   "",
+	"Add":      "",
+	"Sub":      "",
+	"Div":      "",
+	"Identity": "",
+	"Sigmoid":  SigmoidActivation,
+	"Relu":     ReLUActivation,
+	"Tanh":     TanhActivation,
+	"Softmax":  SoftmaxActivation,
+}
+
+// The parts of an ONNX graph which the import uses.
+type onnxGraph struct {
+	nodes        []onnxOperation
+	initializers map[string]onnxInitializer
+	inputs       []string
+	outputs      []string
+}
+
+// A decoded ONNX node.
+type onnxOperation struct {
+	name    string
+	opType  string
+	domain  string
+	inputs  []string
+	outputs []string
+	ints    map[string]int64
+	floats  map[string]float32
+}
+
+// A decoded initializer of an ONNX graph. The values are
+// only decoded for the float tensors.
+type onnxInitializer struct {
+	dims     []int
+	values   []float32
+	dataType int64
+}
+
+// A dense layer of an imported graph, with a weight row per
+// neuron.
+type onnxDenseLayer struct {
+	weights    [][]float32
+	bias       []float32
+	biased     bool
+	activation Activation
+}
+
+// Returns the name of a node, or its position if it has no
+// name, for the error messages.
+func (o onnxOperation) label(index int) string {
+	if o.name == "" {
+		return fmt.Sprintf("#%d", index)
+	}
+
+	return fmt.Sprintf("%q", o.name)
+}
+
+// Builds the dense layer of a Gemm or a MatMul node.
+func (g *onnxGraph) denseLayer(node onnxOperation) (*onnxDenseLayer, error) {
+	if len(node.inputs) < 2 {
+		return nil, errors.New("has no weights")
+	}
+
+	weights, ok := g.initializers[node.inputs[1]]
+	if !ok || len(weights.dims) != 2 {
+		return nil, errors.New("the weights are not a matrix initializer")
+	}
+
+	alpha, beta := float32(1), float32(1)
+	transposed := false
+
+	if node.opType == "Gemm" {
+		if node.ints["transA"] != 0 {
+			return nil, errors.New("transposes the input")
+		}
+
+		transposed = node.ints["transB"] != 0
+
+		if value, ok := node.floats["alpha"]; ok {
+			alpha = value
+		}
+
+		if value, ok := node.floats["beta"]; ok {
+			beta = value
+		}
+	}
+
+	// The weights of MatMul and of Gemm without transB are
+	// [inputs, neurons], so they are transposed into rows.
+	neurons, inputs := weights.dims[1], weights.dims[0]
+	if transposed {
+		neurons, inputs = inputs, neurons
+	}
+
+	layer := &onnxDenseLayer{weights: make([][]float32, neurons), bias: make([]float32, neurons)}
+
+	for i := 0; i < neurons; i++ {
+		layer.weights[i] = make([]float32, inputs)
+
+		for j := 0; j < inputs; j++ {
+			if transposed {
+				layer.weights[i][j] = alpha * weights.values[i * inputs + j]
+			} else {
+				layer.weights[i][j] = alpha * weights.values[j * neurons + i]
+			}
+		}
+	}
+
+	if node.opType == "Gemm" && len(node.inputs) > 2 && node.inputs[2] != "" {
+		bias, ok := g.initializers[node.inputs[2]]
+		if !ok {
+			return nil, errors.New("the bias is not an initializer")
+		}
+
+		scaled := make([]float32, len(bias.values))
+		for i, value := range bias.values {
+			scaled[i] = beta * value
+		}
+
+		if err := layer.addBias(scaled); err != nil {
+			return nil, err
+		}
+	}
+
+	return layer, nil
+}
+
+// Returns the initializer which a binary node applies on
+// the chain.
+func (g *onnxGraph) constant(node onnxOperation) ([]float32, error) {
+	if len(node.inputs) != 2 {
+		return nil, errors.New("is not a binary node")
+	}
+
+	initializer, ok := g.initializers[node.inputs[1]]
+	if !ok {
+		return nil, errors.New("is not applied with an initializer")
+	}
+
+	return initializer.values, nil
+}
+
+// Adds the bias of the layer, which may be broadcast from a
+// single value.
+func (l *onnxDenseLayer) addBias(bias []float32) error {
+	values, err := broadcast(bias, len(l.weights), 0)
+	if err != nil {
+		return fmt.Errorf("the bias %v", err)
+	}
+
+	for i := range l.bias {
+		l.bias[i] += values[i]
+	}
+
+	l.biased = true
+
+	return nil
+}
+
+// Returns the neurons of the layer, with the bias as their
+// last weight.
+func (l *onnxDenseLayer) neurons() []Neuron {
+	neurons := make([]Neuron, len(l.weights))
+
+	for i := range neurons {
+		neurons[i].Weights = append(append([]float32(nil), l.weights[i]...), l.bias[i])
+	}
+
+	return neurons
+}
+
+// Broadcasts the values of a binary node to a size. A
+// missing vector becomes the fill value.
+func broadcast(values []float32, size int, fill float32) ([]float32, error) {
+	switch len(values) {
+	case size:
+		return values, nil
+	case 0, 1:
+		if len(values) == 1 {
+			fill = values[0]
+		}
+
+		broadcast := make([]float32, size)
+		for i := range broadcast {
+			broadcast[i] = fill
+		}
+
+		return broadcast, nil
+	}
+
+	return nil, fmt.Errorf("has %d values, expected %d", len(values), size)
+}
+
+// Decodes the graph and the metadata properties of an ONNX
+// model.
+func decodeONNXModel(data []byte) (*onnxGraph, map[string]string, error) {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var graph *onnxGraph
+	metadata := make(map[string]string)
+
+	for _, field := range fields {
+		switch field.number {
+		case onnxModelGraph:
+			if graph, err = decodeONNXGraph(field.data); err != nil {
+				return nil, nil, err
+			}
+		case onnxModelMetadataProps:
+			entry, err := decodeProto(field.data)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			var key, value string
+			for _, f := range entry {
+				switch f.number {
+				case onnxEntryKey:
+					key = string(f.data)
+				case onnxEntryValue:
+					value = string(f.data)
+				}
+			}
+
+			metadata[key] = value
+		}
+	}
+
+	if graph == nil {
+		return nil, nil, errors.New("bp7: the stream is not an ONNX model")
+	}
+
+	return graph, metadata, nil
+}
+
+// Decodes the nodes, the initializers, the inputs and the
+// outputs of an ONNX graph.
+func decodeONNXGraph(data []byte) (*onnxGraph, error) {
+	fields, err := decodeProto(data)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &onnxGraph{initializers: make(map[string]onnxInitializer)}
+
+	for _, field := range fields {
+		switch field.number {
+		case onnxGraphNode:
+			node, err := decodeONNXNode(field.data)
+			if err != nil {
+				return nil, err
+			}
+
+			graph.nodes = append(graph.nodes, node)
+		case onnxGraphInitializer:
+			name, initializer, err := decodeONNXTensor(field.data)
+			if err != nil {
+				return nil, err
+			}
+
+			graph.initializers[name] = initializer
+		case onnxGraphInput, onnxGraphOutput:
+			value, err := decodeProto(field.data)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, f := range value {
+				if f.number != onnxValueName {
+					continue
+				}
+
+				if field.number == onnxGraphInput {
+					graph.inputs = append(graph.inputs, string(f.data))
+				} else {
+					graph.outputs = append(graph.outputs, string(f.data))
+				}
+			}
+		}
+	}
+
+	return graph, nil
+}
+
+// Decodes an ONNX node with its integer and float attributes.
+func decodeONNXNode(data []byte) (onnxOperation, error) {
+	node := onnxOperation{ints: make(map[string]int64), floats: make(map[string]float32)}
+
+	fields, err := decodeProto(data)
+	if err != nil {
+		return node, err
+	}
+
+	for _, field := range fields {
+		switch field.number {
+		case onnxNodeInput:
+			node.inputs = append(node.inputs, string(field.data))
+		case onnxNodeOutput:
+			node.outputs = append(node.outputs, string(field.data))
+		case onnxNodeName:
+			node.name = string(field.data)
+		case onnxNodeOpType:
+			node.opType = string(field.data)
+		case onnxNodeDomain:
+			node.domain = string(field.data)
+		case onnxNodeAttribute:
+			attribute, err := decodeProto(field.data)
+			if err != nil {
+				return node, err
+			}
+
+			var name string
+			for _, f := range attribute {
+				if f.number == onnxAttributeName {
+					name = string(f.data)
+				}
+			}
+
+			for _, f := range attribute {
+				switch f.number {
+				case onnxAttributeF:
+					node.floats[name] = f.float()
+				case onnxAttributeI:
+					node.ints[name] = int64(f.value)
+				}
+			}
+		}
+	}
+
+	return node, nil
+}
+
+// Decodes an initializer. The values of a float tensor are
+// decoded and checked against its shape, and the other tensors
+// keep only their shape and their data type.
+// -Output: The name and the initializer.
+func decodeONNXTensor(data []byte) (string, onnxInitializer, error) {
+	var name string
+	initializer := onnxInitializer{dataType: onnxFloat}
+
+	fields, err := decodeProto(data)
+	if err != nil {
+		return "", initializer, err
+	}
+
+	// The data type may follow the data, so the data is only
+	// decoded after every field is read.
+	values := make([]protoField, 0)
+
+	for _, field := range fields {
+		switch field.number {
+		case onnxTensorDims:
+			dims, err := field.ints()
+			if err != nil {
+				return "", initializer, err
+			}
+
+			for _, dim := range dims {
+				initializer.dims = append(initializer.dims, int(dim))
+			}
+		case onnxTensorDataType:
+			initializer.dataType = int64(field.value)
+		case onnxTensorFloatData:
+			values = append(values, field)
+		case onnxTensorRawData:
+			values = append(values, protoField{wireType: protoBytes, data: field.data})
+		case onnxTensorName:
+			name = string(field.data)
+		case onnxTensorLocation:
+			if field.value != 0 {
+				return "", initializer, fmt.Errorf("bp7: the ONNX initializer %q is stored outside of the model", name)
+			}
+		}
+	}
+
+	if initializer.dataType != onnxFloat {
+		return name, initializer, nil
+	}
+
+	for _, field := range values {
+		floats, err := field.floats()
+		if err != nil {
+			return "", initializer, err
+		}
+
+		initializer.values = append(initializer.values, floats...)
+	}
+
+	size := 1
+	for _, dim := range initializer.dims {
+		size *= dim
+	}
+
+	if size != len(initializer.values) {
+		return "", initializer, fmt.Errorf("bp7: the ONNX initializer %q has %d values, expected %d", name, len(initializer.values), size)
+	}
+
+	return name, initializer, nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// Returns a 3x4x2 network with distinct weights, a ReLU hidden
+// layer, a softmax output layer and a standard scaler.
+func onnxTestNetwork() *Network {
+	n := CreateNetwork(3, 4, 2)
+
+	for i := range n.HiddenLayer.Neurons {
+		n.HiddenLayer.Neurons[i].Weights = []float32{float32(i) + 0.25, -0.5, float32(i) * 0.125, 0.75 - float32(i)}
+	}
+
+	for i := range n.OutputLayer.Neurons {
+		n.OutputLayer.Neurons[i].Weights = []float32{1.5, float32(i) - 0.25, -2, 0.0625, float32(i) * 3}
+	}
+
+	n.HiddenLayer.Activation = ReLUActivation
+	n.OutputLayer.Activation = SoftmaxActivation
+	n.Scaler = &Scaler{Method: StandardScaling, Offset: []float32{1, 2, 3}, Scale: []float32{0.5, 4, 8}}
+	n.Metadata = map[string]string{"epochs": "10"}
+
+	return &n
+}
+
+func TestSaveONNXGraph(t *testing.T) {
+	n := onnxTestNetwork()
+
+	var model bytes.Buffer
+	if err := n.SaveONNX(&model); err != nil {
+		t.Fatal(err)
+	}
+
+	graph, metadata, err := decodeONNXModel(model.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(metadata, n.Metadata) {
+		t.Errorf("metadata = %v, want %v", metadata, n.Metadata)
+	}
+
+	if !reflect.DeepEqual(graph.inputs, []string{ONNXInputName}) || !reflect.DeepEqual(graph.outputs, []string{ONNXOutputName}) {
+		t.Errorf("graph inputs %v and outputs %v", graph.inputs, graph.outputs)
+	}
+
+	nodes := []struct {
+		name   string
+		opType string
+		inputs []string
+		output string
+	}{
+		{"scaler_sub", "Sub", []string{"input", "scaler_offset"}, "scaler_centered"},
+		{"scaler_div", "Div", []string{"scaler_centered", "scaler_scale"}, "scaled"},
+		{"hidden_gemm", "Gemm", []string{"scaled", "hidden_weight", "hidden_bias"}, "hidden_linear"},
+		{"hidden_relu", "Relu", []string{"hidden_linear"}, "hidden"},
+		{"output_gemm", "Gemm", []string{"hidden", "output_weight", "output_bias"}, "output_linear"},
+		{"output_softmax", "Softmax", []string{"output_linear"}, "output"},
+	}
+
+	if len(graph.nodes) != len(nodes) {
+		t.Fatalf("the graph has %d nodes, want %d", len(graph.nodes), len(nodes))
+	}
+
+	for i, want := range nodes {
+		node := graph.nodes[i]
+
+		if node.name != want.name || node.opType != want.opType {
+			t.Errorf("node %d is %s (%s), want %s (%s)", i, node.name, node.opType, want.name, want.opType)
+		}
+
+		if !reflect.DeepEqual(node.inputs, want.inputs) || !reflect.DeepEqual(node.outputs, []string{want.output}) {
+			t.Errorf("node %s has inputs %v and outputs %v", node.name, node.inputs, node.outputs)
+		}
+
+		if node.opType == "Gemm" && node.ints["transB"] != 1 {
+			t.Errorf("node %s does not transpose its weights", node.name)
+		}
+	}
+
+	initializers := map[string]onnxInitializer{
+		"scaler_offset": {dims: []int{3}, values: n.Scaler.Offset},
+		"scaler_scale":  {dims: []int{3}, values: n.Scaler.Scale},
+		"hidden_weight": {dims: []int{4, 3}, values: []float32{
+			0.25, -0.5, 0,
+			1.25, -0.5, 0.125,
+			2.25, -0.5, 0.25,
+			3.25, -0.5, 0.375,
+		}},
+		"hidden_bias":   {dims: []int{4}, values: []float32{0.75, -0.25, -1.25, -2.25}},
+		"output_weight": {dims: []int{2, 4}, values: []float32{
+			1.5, -0.25, -2, 0.0625,
+			1.5, 0.75, -2, 0.0625,
+		}},
+		"output_bias": {dims: []int{2}, values: []float32{0, 3}},
+	}
+
+	if len(graph.initializers) != len(initializers) {
+		t.Errorf("the graph has %d initializers, want %d", len(graph.initializers), len(initializers))
+	}
+
+	for name, want := range initializers {
+		initializer, ok := graph.initializers[name]
+		if !ok {
+			t.Errorf("the initializer %s is missing", name)
+			continue
+		}
+
+		if !reflect.DeepEqual(initializer.dims, want.dims) {
+			t.Errorf("the initializer %s has the shape %v, want %v", name, initializer.dims, want.dims)
+		}
+
+		if !reflect.DeepEqual(initializer.values, want.values) {
+			t.Errorf("the initializer %s has the values %v, want %v", name, initializer.values, want.values)
+		}
+	}
+}
+
+func TestSaveONNXUninitialized(t *testing.T) {
+	var model bytes.Buffer
+
+	if err := (&Network{}).SaveONNX(&model); err != ErrUninitializedNetwork {
+		t.Errorf("SaveONNX() = %v, want ErrUninitializedNetwork", err)
+	}
+}
+
+func TestLoadONNXRoundTrip(t *testing.T) {
+	n := onnxTestNetwork()
+
+	var model bytes.Buffer
+	if err := n.SaveONNX(&model); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := Network{}
+	if err := loaded.LoadONNX(&model); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded.HiddenLayer, n.HiddenLayer) || !reflect.DeepEqual(loaded.OutputLayer, n.OutputLayer) {
+		t.Errorf("the layers changed: %v, %v", loaded.HiddenLayer, loaded.OutputLayer)
+	}
+
+	if !reflect.DeepEqual(loaded.Scaler, n.Scaler) || !reflect.DeepEqual(loaded.Metadata, n.Metadata) {
+		t.Errorf("the scaler %v or the metadata %v changed", loaded.Scaler, loaded.Metadata)
+	}
+}
+
+// Encodes an ONNX model of a graph with the given nodes and
+// initializers, whose input is "input" and output "output".
+func onnxTestModel(nodes []*protoEncoder, initializers []*protoEncoder) []byte {
+	graph := &protoEncoder{}
+
+	for _, node := range nodes {
+		graph.message(onnxGraphNode, node)
+	}
+
+	for _, initializer := range initializers {
+		graph.message(onnxGraphInitializer, initializer)
+	}
+
+	graph.message(onnxGraphInput, onnxValue("input", 2))
+	graph.message(onnxGraphOutput, onnxValue("output", 1))
+
+	model := &protoEncoder{}
+	model.message(onnxModelGraph, graph)
+
+	return model.data
+}
+
+func TestLoadONNXUnsupportedNodes(t *testing.T) {
+	// The shape of a Reshape is an int64 tensor, which must not
+	// hide the unsupported Reshape node.
+	shape := &protoEncoder{}
+	shape.int(onnxTensorDims, 2)
+	shape.int(onnxTensorDataType, 7)
+	shape.bytes(onnxTensorRawData, make([]byte, 16))
+	shape.string(onnxTensorName, "shape")
+
+	nodes := []*protoEncoder{
+		onnxNode("flatten", "Reshape", []string{"input", "shape"}, "flat", nil),
+		onnxNode("", "LSTM", []string{"flat"}, "output", nil),
+	}
+
+	err := (&Network{}).LoadONNX(bytes.NewReader(onnxTestModel(nodes, []*protoEncoder{shape})))
+
+	want := `bp7: unsupported ONNX nodes: "flatten" (Reshape), #1 (LSTM)`
+	if err == nil || err.Error() != want {
+		t.Errorf("LoadONNX() = %v, want %s", err, want)
+	}
+}
+
+func TestLoadONNXEmptyLayer(t *testing.T) {
+	initializers := []*protoEncoder{
+		onnxTensor("hidden_weight", []int{3, 2}, []float32{1, 2, 3, 4, 5, 6}),
+		onnxTensor("output_weight", []int{0, 3}, nil),
+	}
+
+	nodes := []*protoEncoder{
+		onnxNode("hidden", "Gemm", []string{"input", "hidden_weight"}, "hidden", map[string]int64{"transB": 1}),
+		onnxNode("output", "Gemm", []string{"hidden", "output_weight"}, "output", map[string]int64{"transB": 1}),
+	}
+
+	if err := (&Network{}).LoadONNX(bytes.NewReader(onnxTestModel(nodes, initializers))); err == nil {
+		t.Error("LoadONNX() accepted a layer without units")
+	}
+}
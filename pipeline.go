@@ -0,0 +1,340 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The configuration of a pipeline: which columns it reads,
+// how it preprocesses them and how it trains the network.
+type PipelineConfig struct {
+	// The numeric feature columns the pipeline keeps. When it
+	// is empty, every numeric feature of the dataset is kept.
+	Features []string
+	// The field values of a raw record which mean that a
+	// feature is missing. They should match the MissingTokens
+	// the training dataset was loaded with.
+	MissingTokens []string
+	// The optional imputation of the numeric features.
+	Imputer *ImputerConfig
+	// The encoders of the categorical columns. They are
+	// fitted by the pipeline.
+	Encoders []ColumnEncoder
+	// The optional scaling of all the features, applied
+	// after the imputation and the encoding.
+	Scaling ScaleMethod
+
+	HiddenNeurons int
+	LearningRate  float32
+	Epochs        int
+}
+
+// A pipeline chains the column selection, the imputation,
+// the encoding and the scaling with the network, so that
+// raw records go through exactly the preprocessing that
+// the network was trained with. The fitted imputer,
+// encoding and scaler are attached to the network.
+type Pipeline struct {
+	Config PipelineConfig
+	// The columns of a raw record, in order. They are the
+	// columns of the file the training dataset was loaded from.
+	Columns []string
+	// The numeric feature columns of the network, in order.
+	Features []string
+	Network  Network
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Fits every step of the pipeline on a training dataset
+// and trains the network on the preprocessed features.
+// -Input d: The training dataset. Its categorical columns
+// are the ones of the configured encoders.
+func (p *Pipeline) Fit(d *Dataset) error {
+	if d.Len() == 0 {
+		return errors.New("bp7: the training dataset is empty")
+	}
+
+	p.Columns = d.Columns
+	p.Features = p.Config.Features
+
+	if len(p.Features) == 0 {
+		p.Features = d.FeatureNames
+	}
+
+	positions := make([]int, len(p.Features))
+
+	for i, name := range p.Features {
+		positions[i] = d.FeatureIndex(name)
+		if positions[i] < 0 {
+			return fmt.Errorf("bp7: there is no numeric feature %q", name)
+		}
+	}
+
+	selected := &Dataset{
+		FeatureNames:     p.Features,
+		TargetName:       d.TargetName,
+		Features:         make([][]float32, d.Len()),
+		Targets:          d.Targets,
+		ClassLabels:      d.ClassLabels,
+		CategoricalNames: d.CategoricalNames,
+		Categorical:      d.Categorical,
+		Columns:          d.Columns,
+	}
+
+	for r := 0; r < d.Len(); r++ {
+		selected.Features[r] = make([]float32, len(positions))
+		for i, position := range positions {
+			selected.Features[r][i] = d.Features[r][position]
+		}
+	}
+
+	network := Network{Labels: &LabelEncoder{Labels: d.ClassLabels}}
+
+	if len(p.Config.Encoders) > 0 {
+		encoding := &CategoricalEncoding{Columns: p.Config.Encoders}
+
+		if err := encoding.Fit(selected); err != nil {
+			return err
+		}
+
+		encoded, err := encoding.Apply(selected)
+		if err != nil {
+			return err
+		}
+
+		network.Encoding = encoding
+		selected = encoded
+	}
+
+	// The network applies the imputer and then the scaler on
+	// every row, so it is trained on the rows before them. The
+	// imputer only fills in the numeric features, which come
+	// before the encoded ones.
+	prepared := selected
+
+	if p.Config.Imputer != nil {
+		imputer, err := FitImputer(numericPrefix(selected.Features, len(p.Features)), *p.Config.Imputer)
+		if err != nil {
+			return err
+		}
+
+		network.Imputer = imputer
+		prepared = prepared.Transform(imputer)
+	}
+
+	if p.Config.Scaling != "" {
+		scaler, err := FitScaler(prepared.Features, p.Config.Scaling)
+		if err != nil {
+			return err
+		}
+
+		network.Scaler = scaler
+	}
+
+	network.Init(len(selected.FeatureNames), p.Config.HiddenNeurons, d.ClassCount())
+	network.Train(selected.Rows(), p.Config.LearningRate, p.Config.Epochs, d.ClassCount())
+
+	p.Network = network
+
+	return nil
+}
+
+// Converts a raw record into a row of the network: the
+// numeric features followed by the encoded categorical
+// columns. The imputer and the scaler are applied later
+// by the network itself.
+// -Input record: The fields of a raw record, in the order
+// of the pipeline columns.
+// -Output: The row of the network.
+func (p *Pipeline) row(record []string) ([]float32, error) {
+	row := make([]float32, len(p.Features))
+
+	for i, name := range p.Features {
+		field, err := p.field(record, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if indexOf(p.Config.MissingTokens, field) >= 0 {
+			row[i] = float32(math.NaN())
+			continue
+		}
+
+		value, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return nil, fmt.Errorf("bp7: column %q: %v", name, err)
+		}
+
+		row[i] = float32(value)
+	}
+
+	if p.Network.Encoding != nil {
+		values := make([]string, len(p.Network.Encoding.Columns))
+
+		for i, column := range p.Network.Encoding.Columns {
+			field, err := p.field(record, column.Column)
+			if err != nil {
+				return nil, err
+			}
+
+			values[i] = field
+		}
+
+		encoded, err := p.Network.Encoding.Encode(values)
+		if err != nil {
+			return nil, err
+		}
+
+		row = append(row, encoded...)
+	}
+
+	return row, nil
+}
+
+// Returns the trimmed field of a column of a raw record.
+func (p *Pipeline) field(record []string, column string) (string, error) {
+	index := indexOf(p.Columns, column)
+	if index < 0 || index >= len(record) {
+		return "", fmt.Errorf("bp7: the record has no column %q", column)
+	}
+
+	return strings.TrimSpace(record[index]), nil
+}
+
+// Predicts the output categorization of a raw record.
+// -Input record: The fields of a raw record, laid out like
+// the records of the training file. The target field may
+// be left empty.
+// -Output: The predicted class index.
+func (p *Pipeline) Predict(record []string) (int, error) {
+	row, err := p.row(record)
+	if err != nil {
+		return 0, err
+	}
+
+	return argmax(p.Network.infer(row)), nil
+}
+
+// Predicts the class label of a raw record.
+// -Input record: The fields of a raw record, laid out like
+// the records of the training file.
+// -Output: The predicted class label.
+func (p *Pipeline) PredictLabel(record []string) (string, error) {
+	row, err := p.row(record)
+	if err != nil {
+		return "", err
+	}
+
+	return p.Network.PredictLabel(row), nil
+}
+
+// Calculates the class probabilities of a raw record.
+// -Input record: The fields of a raw record, laid out like
+// the records of the training file.
+// -Output: The probability of each class.
+func (p *Pipeline) PredictProbabilities(record []string) ([]float32, error) {
+	row, err := p.row(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Network.PredictProbabilities(row)
+}
+
+// Saves the whole pipeline, its configuration, the fitted
+// steps and the network weights, as a single model file.
+// -Input w: The writer of the stream.
+func (p *Pipeline) Save(w io.Writer) error {
+	sections := []section{{"pipeline", [][]string{
+		append([]string{"columns"}, p.Columns...),
+		append([]string{"features"}, p.Features...),
+		append([]string{"missing"}, p.Config.MissingTokens...),
+	}}}
+
+	return writeModel(w, &p.Network, sections)
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Creates an unfitted pipeline.
+// -Input config: The configuration of the pipeline.
+// -Output: The pipeline.
+func CreatePipeline(config PipelineConfig) *Pipeline {
+	return &Pipeline{Config: config}
+}
+
+// Loads a pipeline saved by Pipeline.Save. The loaded pipeline
+// can predict, but it keeps only the configuration which is
+// needed for the prediction.
+// -Input r: The reader of the stream.
+// -Output: The pipeline.
+func LoadPipeline(r io.Reader) (*Pipeline, error) {
+	sections, err := readModel(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pipeline{}
+
+	rest, err := p.Network.parseModel(sections)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range rest {
+		if s.name != "pipeline" {
+			return nil, fmt.Errorf("bp7: unknown pipeline section %q", s.name)
+		}
+
+		for _, record := range s.records {
+			switch record[0] {
+			case "columns":
+				p.Columns = record[1:]
+			case "features":
+				p.Features = record[1:]
+			case "missing":
+				p.Config.MissingTokens = record[1:]
+			default:
+				return nil, fmt.Errorf("bp7: unknown pipeline record %q", record[0])
+			}
+		}
+	}
+
+	p.Config.Features = p.Features
+
+	return p, nil
+}
+
+// Returns the first count values of each feature vector.
+func numericPrefix(features [][]float32, count int) [][]float32 {
+	prefix := make([][]float32, len(features))
+
+	for i := 0; i < len(features); i++ {
+		prefix[i] = features[i][:count]
+	}
+
+	return prefix
+}
@@ -0,0 +1,212 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The wire types of the protocol buffers encoding.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// A minimal encoder of the protocol buffers wire format,
+// which is enough to write the messages of an ONNX model.
+// A nested message is encoded into its own encoder and then
+// appended with message.
+type protoEncoder struct {
+	data []byte
+}
+
+// Appends the key of a field.
+func (p *protoEncoder) key(field int, wireType int) {
+	p.varint(uint64(field) << 3 | uint64(wireType))
+}
+
+// Appends a base 128 varint.
+func (p *protoEncoder) varint(value uint64) {
+	for value >= 0x80 {
+		p.data = append(p.data, byte(value) | 0x80)
+		value >>= 7
+	}
+
+	p.data = append(p.data, byte(value))
+}
+
+// Appends an integer field. The negative values take ten
+// bytes, like every int32 and int64 field of protobuf.
+func (p *protoEncoder) int(field int, value int64) {
+	p.key(field, protoVarint)
+	p.varint(uint64(value))
+}
+
+// Appends a float field.
+func (p *protoEncoder) float(field int, value float32) {
+	p.key(field, protoFixed32)
+	p.data = append(p.data, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(p.data[len(p.data) - 4:], math.Float32bits(value))
+}
+
+// Appends a length-delimited field.
+func (p *protoEncoder) bytes(field int, value []byte) {
+	p.key(field, protoBytes)
+	p.varint(uint64(len(value)))
+	p.data = append(p.data, value...)
+}
+
+// Appends a string field.
+func (p *protoEncoder) string(field int, value string) {
+	p.bytes(field, []byte(value))
+}
+
+// Appends a nested message field.
+func (p *protoEncoder) message(field int, message *protoEncoder) {
+	p.bytes(field, message.data)
+}
+
+// Appends a packed repeated float field.
+func (p *protoEncoder) floats(field int, values []float32) {
+	packed := make([]byte, 4 * len(values))
+
+	for i, value := range values {
+		binary.LittleEndian.PutUint32(packed[i * 4:], math.Float32bits(value))
+	}
+
+	p.bytes(field, packed)
+}
+
+// A field of a decoded protocol buffers message. The varint
+// and the fixed size fields keep their bits in value and the
+// length-delimited fields keep their bytes in data.
+type protoField struct {
+	number   int
+	wireType int
+	value    uint64
+	data     []byte
+}
+
+// Decodes the fields of a protocol buffers message, in the
+// order of the message. The nested messages stay encoded in
+// the data of their fields.
+// -Input data: The encoded message.
+// -Output: The fields of the message.
+func decodeProto(data []byte) ([]protoField, error) {
+	fields := make([]protoField, 0)
+
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("bp7: invalid protobuf field key")
+		}
+
+		data = data[n:]
+		field := protoField{number: int(key >> 3), wireType: int(key & 7)}
+
+		switch field.wireType {
+		case protoVarint:
+			field.value, n = binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errors.New("bp7: invalid protobuf varint")
+			}
+
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return nil, errors.New("bp7: truncated protobuf field")
+			}
+
+			field.value = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return nil, errors.New("bp7: truncated protobuf field")
+			}
+
+			field.value = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case protoBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data) - n) {
+				return nil, errors.New("bp7: truncated protobuf field")
+			}
+
+			field.data = data[n:n + int(length)]
+			data = data[n + int(length):]
+		default:
+			return nil, fmt.Errorf("bp7: unsupported protobuf wire type %d", field.wireType)
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// Returns the value of a float field.
+func (f protoField) float() float32 {
+	return math.Float32frombits(uint32(f.value))
+}
+
+// Returns the values of a repeated float field, which is
+// either packed or a single value.
+func (f protoField) floats() ([]float32, error) {
+	if f.wireType == protoFixed32 {
+		return []float32{f.float()}, nil
+	}
+
+	if f.wireType != protoBytes || len(f.data) % 4 != 0 {
+		return nil, errors.New("bp7: invalid packed protobuf floats")
+	}
+
+	values := make([]float32, len(f.data) / 4)
+	for i := range values {
+		values[i] = math.Float32frombits(binary.LittleEndian.Uint32(f.data[i * 4:]))
+	}
+
+	return values, nil
+}
+
+// Returns the values of a repeated integer field, which is
+// either packed or a single value.
+func (f protoField) ints() ([]int64, error) {
+	if f.wireType == protoVarint {
+		return []int64{int64(f.value)}, nil
+	}
+
+	if f.wireType != protoBytes {
+		return nil, errors.New("bp7: invalid packed protobuf integers")
+	}
+
+	values := make([]int64, 0)
+
+	for data := f.data; len(data) > 0; {
+		value, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("bp7: invalid protobuf varint")
+		}
+
+		values = append(values, int64(value))
+		data = data[n:]
+	}
+
+	return values, nil
+}
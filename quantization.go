@@ -0,0 +1,502 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// How many weights share a quantization scale.
+type QuantizationGranularity string
+
+const (
+	// Every weight of a layer shares one scale.
+	PerLayerQuantization QuantizationGranularity = "layer"
+	// The weights of each neuron have their own scale, which
+	// keeps the precision of the neurons with small weights.
+	PerNeuronQuantization QuantizationGranularity = "neuron"
+)
+
+// The configuration of the quantization.
+type QuantizationConfig struct {
+	Granularity QuantizationGranularity
+}
+
+// A layer with int8 weights. The inputs of the layer are
+// quantized to int8 with the calibrated scale and zero point,
+// the products are accumulated in int32 together with the
+// quantized bias, and the sum of each neuron is scaled back
+// to a float before the activation.
+type QuantizedLayer struct {
+	// The weights of each neuron, without the bias.
+	Weights [][]int8
+	// The scale of the weights of each neuron.
+	Scales []float32
+	// The bias of each neuron, in the units of the input scale
+	// times the weight scale of the neuron.
+	Bias []int32
+	// The real value of an input step, and the int8 value of
+	// the input zero.
+	InputScale     float32
+	InputZeroPoint int8
+	Activation     Activation
+}
+
+// A network with int8 layers, which takes a quarter of the
+// memory of the float32 network. The preprocessing and the
+// calibrator stay in float32. Save writes it with the int8
+// weights and the scales unchanged, and Load reads it back.
+type QuantizedNetwork struct {
+	HiddenLayer QuantizedLayer
+	OutputLayer QuantizedLayer
+	Calibration *Calibrator
+	Imputer     *Imputer
+	Scaler      *Scaler
+	Labels      *LabelEncoder
+}
+
+// The accuracy of a quantized network next to the one of the
+// float32 network it was quantized from.
+type QuantizationReport struct {
+	Float     ClassificationReport
+	Quantized ClassificationReport
+	// The quantized accuracy minus the float32 accuracy.
+	AccuracyDelta float32
+	// The share of the rows where both networks predict the
+	// same class.
+	Agreement float32
+	// The largest absolute difference of an output.
+	MaxOutputError float32
+}
+
+// Returns the configuration of per-neuron quantization.
+func DefaultQuantizationConfig() QuantizationConfig {
+	return QuantizationConfig{Granularity: PerNeuronQuantization}
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Quantizes the network to int8 after its training, with
+// per-neuron weight scales.
+// -Input calibration: Sample rows, which set the ranges of the
+// layer inputs. The value after the features, like the class
+// index, is ignored.
+// -Output: The quantized network.
+func (n *Network) Quantize(calibration [][]float32) (*QuantizedNetwork, error) {
+	return n.QuantizeWithConfig(calibration, DefaultQuantizationConfig())
+}
+
+// Quantizes the network to int8 after its training. The rows
+// of the calibration set go through the float32 network, and
+// the range of the preprocessed inputs and of the hidden
+// outputs sets the scale and the zero point of the inputs of
+// each layer. The weights are quantized symmetrically, with a
+// scale per layer or per neuron.
+// -Input calibration: Sample rows, which set the ranges of the
+// layer inputs.
+// -Input config: The granularity of the weight scales.
+// -Output: The quantized network.
+func (n *Network) QuantizeWithConfig(calibration [][]float32, config QuantizationConfig) (*QuantizedNetwork, error) {
+	if len(n.HiddenLayer.Neurons) == 0 || len(n.OutputLayer.Neurons) == 0 {
+		return nil, ErrUninitializedNetwork
+	}
+
+	if len(calibration) == 0 {
+		return nil, errors.New("bp7: the quantization needs calibration rows")
+	}
+
+	inputRange := [2]float32{0, 0}
+	hiddenRange := [2]float32{0, 0}
+
+	for _, row := range calibration {
+		inputs, hidden := n.layerInputs(row)
+
+		extendRange(&inputRange, inputs)
+		extendRange(&hiddenRange, hidden)
+	}
+
+	return n.quantizeRanges(inputRange, hiddenRange, config)
+}
+
+// Quantizes the layers of the network, whose inputs are in
+// the given ranges.
+// -Input inputRange: The range of the preprocessed inputs.
+// -Input hiddenRange: The range of the hidden outputs.
+// -Input config: The granularity of the weight scales.
+// -Output: The quantized network.
+func (n *Network) quantizeRanges(inputRange [2]float32, hiddenRange [2]float32, config QuantizationConfig) (*QuantizedNetwork, error) {
+	hiddenLayer, err := quantizeLayer(n.HiddenLayer.Neurons, n.HiddenLayer.Activation, inputRange, config)
+	if err != nil {
+		return nil, err
+	}
+
+	outputLayer, err := quantizeLayer(n.OutputLayer.Neurons, n.OutputLayer.Activation, hiddenRange, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &QuantizedNetwork{
+		HiddenLayer: hiddenLayer,
+		OutputLayer: outputLayer,
+		Calibration: n.Calibration,
+		Imputer:     n.Imputer,
+		Scaler:      n.Scaler,
+		Labels:      n.Labels,
+	}, nil
+}
+
+// Compares a quantized network with the network over a
+// labelled dataset, whose rows end with the class index.
+// -Input q: The quantized network.
+// -Input dataSet: The rows to evaluate.
+// -Output: The report of both networks.
+func (n *Network) CompareQuantized(q *QuantizedNetwork, dataSet [][]float32) (QuantizationReport, error) {
+	report := QuantizationReport{}
+
+	if err := q.check(); err != nil {
+		return report, err
+	}
+
+	floatReport, err := n.Evaluate(dataSet)
+	if err != nil {
+		return report, err
+	}
+
+	labels := make([]int, len(dataSet))
+	probabilities := make([][]float32, len(dataSet))
+	agreements := 0
+
+	for i, row := range dataSet {
+		if len(row) == 0 {
+			return report, fmt.Errorf("bp7: row %d is empty", i)
+		}
+
+		labels[i] = int(row[len(row) - 1])
+
+		expected := n.infer(row)
+		outputs := q.Outputs(row)
+
+		for j := range outputs {
+			if difference := float32(math.Abs(float64(outputs[j] - expected[j]))); difference > report.MaxOutputError {
+				report.MaxOutputError = difference
+			}
+		}
+
+		if argmax(outputs) == argmax(expected) {
+			agreements++
+		}
+
+		if probabilities[i], err = q.probabilities(outputs); err != nil {
+			return report, err
+		}
+	}
+
+	quantized, err := EvaluatePredictions(labels, probabilities)
+	if err != nil {
+		return report, err
+	}
+
+	report.Float = floatReport
+	report.Quantized = quantized
+	report.AccuracyDelta = quantized.Accuracy - floatReport.Accuracy
+	report.Agreement = float32(agreements) / float32(len(dataSet))
+
+	return report, nil
+}
+
+// Returns the preprocessed inputs of a row and the outputs
+// of the hidden layer, which are the inputs of the layers.
+func (n *Network) layerInputs(row []float32) ([]float32, []float32) {
+	inputs := n.preprocess(row)
+	if len(inputs) > n.inputCount() {
+		inputs = inputs[:n.inputCount()]
+	}
+
+	hidden := make([]float32, len(n.HiddenLayer.Neurons))
+
+	for i := range n.HiddenLayer.Neurons {
+		hidden[i] = n.HiddenLayer.Neurons[i].activate(inputs)
+	}
+
+	return inputs, n.HiddenLayer.Activation.apply(hidden)
+}
+
+// Propagates a row through the quantized network.
+// -Input row: An entry row of the dataset array.
+// -Output: The output of each output neuron, or nil if a
+// layer of the network is empty.
+func (q *QuantizedNetwork) Outputs(row []float32) []float32 {
+	if len(q.HiddenLayer.Weights) == 0 || len(q.OutputLayer.Weights) == 0 {
+		return nil
+	}
+
+	if q.Imputer != nil {
+		row = q.Imputer.Transform(row)
+	}
+
+	if q.Scaler != nil {
+		row = q.Scaler.Transform(row)
+	}
+
+	return q.OutputLayer.forward(q.HiddenLayer.forward(row))
+}
+
+// Predicts the class of a row, which is the output neuron
+// with the largest output.
+// -Input row: An entry to predict the category.
+func (q *QuantizedNetwork) Predict(row []float32) int {
+	return argmax(q.Outputs(row))
+}
+
+// Returns the probability of each class for a row, like
+// Network.PredictProbabilities.
+// -Input row: An entry to predict the category.
+func (q *QuantizedNetwork) PredictProbabilities(row []float32) ([]float32, error) {
+	return q.probabilities(q.Outputs(row))
+}
+
+// Predicts the class of each row of a batch using one
+// goroutine per available CPU.
+// -Input rows: The entries to predict the category.
+// -Output: The predicted category of each row, in the order
+// of the rows.
+func (q *QuantizedNetwork) PredictBatch(rows [][]float32) ([]int, error) {
+	return q.PredictBatchWithConfig(rows, DefaultBatchConfig())
+}
+
+// Predicts the class of each row of a batch.
+// -Input rows: The entries to predict the category.
+// -Input config: The worker count and the optional context.
+// -Output: The predicted category of each row, in the order
+// of the rows.
+func (q *QuantizedNetwork) PredictBatchWithConfig(rows [][]float32, config BatchConfig) ([]int, error) {
+	if err := q.check(); err != nil {
+		return nil, err
+	}
+
+	inputCount := len(q.HiddenLayer.Weights[0])
+
+	for i := 0; i < len(rows); i++ {
+		if len(rows[i]) < inputCount {
+			return nil, fmt.Errorf("bp7: row %d has %d values but the network expects %d inputs", i, len(rows[i]), inputCount)
+		}
+	}
+
+	predictions := make([]int, len(rows))
+
+	err := scoreRows(rows, config, q.Outputs, func(i int, outputs []float32) {
+		predictions[i] = argmax(outputs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return predictions, nil
+}
+
+// Turns the outputs into probabilities, with the calibrator
+// if there is one.
+func (q *QuantizedNetwork) probabilities(outputs []float32) ([]float32, error) {
+	if q.Calibration != nil {
+		return q.Calibration.Apply(outputs)
+	}
+
+	return normalize(outputs), nil
+}
+
+// Propagates the inputs through the layer with int32
+// accumulation.
+// -Input inputs: The float inputs of the layer. The values
+// after the weights of the layer are ignored.
+// -Output: The outputs of the neurons.
+func (l *QuantizedLayer) forward(inputs []float32) []float32 {
+	if len(l.Weights) == 0 {
+		return nil
+	}
+
+	size := len(l.Weights[0])
+	quantized := make([]int32, size)
+
+	for j := 0; j < size && j < len(inputs); j++ {
+		quantized[j] = int32(quantizeValue(inputs[j], l.InputScale, l.InputZeroPoint)) - int32(l.InputZeroPoint)
+	}
+
+	outputs := make([]float32, len(l.Weights))
+
+	for i, weights := range l.Weights {
+		sum := l.Bias[i]
+		inputs := quantized[:len(weights)]
+
+		for j, weight := range weights {
+			sum += int32(weight) * inputs[j]
+		}
+
+		outputs[i] = float32(sum) * l.InputScale * l.Scales[i]
+	}
+
+	return l.Activation.apply(outputs)
+}
+
+// Renders the report as the accuracy of both networks
+// followed by their agreement.
+func (r QuantizationReport) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Float32 accuracy: %.4f\n", r.Float.Accuracy)
+	fmt.Fprintf(&b, "Int8 accuracy: %.4f\n", r.Quantized.Accuracy)
+	fmt.Fprintf(&b, "Accuracy delta: %+.4f\n", r.AccuracyDelta)
+	fmt.Fprintf(&b, "Agreement: %.4f\n", r.Agreement)
+	fmt.Fprintf(&b, "Max output error: %.6f", r.MaxOutputError)
+
+	return b.String()
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Quantizes a network to int8 with per-neuron weight scales.
+// -Input n: A network.
+// -Input calibration: Sample rows, which set the ranges of the
+// layer inputs.
+// -Output: The quantized network.
+func Quantize(n *Network, calibration [][]float32) (*QuantizedNetwork, error) {
+	return n.Quantize(calibration)
+}
+
+// Quantizes a network to int8.
+// -Input n: A network.
+// -Input calibration: Sample rows, which set the ranges of the
+// layer inputs.
+// -Input config: The granularity of the weight scales.
+// -Output: The quantized network.
+func QuantizeWithConfig(n *Network, calibration [][]float32, config QuantizationConfig) (*QuantizedNetwork, error) {
+	return n.QuantizeWithConfig(calibration, config)
+}
+
+// Compares a quantized network with a network over a
+// labelled dataset.
+// -Input n: A network.
+// -Input q: The quantized network.
+// -Input dataSet: The rows to evaluate.
+// -Output: The report of both networks.
+func CompareQuantized(n *Network, q *QuantizedNetwork, dataSet [][]float32) (QuantizationReport, error) {
+	return n.CompareQuantized(q, dataSet)
+}
+
+// Quantizes the weights of a layer, whose inputs are in a
+// range.
+// -Input neurons: The neurons of the layer.
+// -Input activation: The activation of the layer.
+// -Input inputRange: The smallest and the largest input.
+// -Input config: The granularity of the weight scales.
+func quantizeLayer(neurons []Neuron, activation Activation, inputRange [2]float32, config QuantizationConfig) (QuantizedLayer, error) {
+	if config.Granularity != PerLayerQuantization && config.Granularity != PerNeuronQuantization {
+		return QuantizedLayer{}, fmt.Errorf("bp7: unknown quantization granularity %q", config.Granularity)
+	}
+
+	inputScale, zeroPoint := affineQuantization(inputRange)
+
+	layer := QuantizedLayer{
+		Weights:        make([][]int8, len(neurons)),
+		Scales:         make([]float32, len(neurons)),
+		Bias:           make([]int32, len(neurons)),
+		InputScale:     inputScale,
+		InputZeroPoint: zeroPoint,
+		Activation:     activation,
+	}
+
+	size := len(neurons[0].Weights) - 1
+
+	if config.Granularity == PerLayerQuantization {
+		weights := make([]float32, 0, len(neurons) * size)
+		for _, neuron := range neurons {
+			weights = append(weights, neuron.Weights[:size]...)
+		}
+
+		quantized, scale := quantizeSymmetric(weights)
+
+		for i := range neurons {
+			layer.Weights[i] = quantized[i * size:(i + 1) * size:(i + 1) * size]
+			layer.Scales[i] = scale
+		}
+	} else {
+		for i, neuron := range neurons {
+			layer.Weights[i], layer.Scales[i] = quantizeSymmetric(neuron.Weights[:size])
+		}
+	}
+
+	for i, neuron := range neurons {
+		bias := math.Round(float64(neuron.Weights[size]) / float64(inputScale * layer.Scales[i]))
+
+		if bias > math.MaxInt32 || bias < math.MinInt32 {
+			return QuantizedLayer{}, fmt.Errorf("bp7: the bias of neuron %d overflows int32", i)
+		}
+
+		layer.Bias[i] = int32(bias)
+	}
+
+	return layer, nil
+}
+
+// Extends a range with values. Missing values are skipped.
+func extendRange(r *[2]float32, values []float32) {
+	for _, value := range values {
+		if isMissing(value) {
+			continue
+		}
+
+		if value < r[0] {
+			r[0] = value
+		}
+
+		if value > r[1] {
+			r[1] = value
+		}
+	}
+}
+
+// Returns the scale and the zero point which map a range,
+// which contains zero, onto [-128, 127].
+func affineQuantization(r [2]float32) (float32, int8) {
+	scale := (r[1] - r[0]) / 255
+	if scale == 0 {
+		return 1, 0
+	}
+
+	zeroPoint := math.Round(-128 - float64(r[0] / scale))
+
+	return scale, int8(math.Max(-128, math.Min(127, zeroPoint)))
+}
+
+// Quantizes a value to int8 with a scale and a zero point,
+// saturating the values out of the calibrated range.
+func quantizeValue(value float32, scale float32, zeroPoint int8) int8 {
+	quantized := math.RoundToEven(float64(value / scale)) + float64(zeroPoint)
+
+	switch {
+	case quantized < -128:
+		return -128
+	case quantized > 127:
+		return 127
+	}
+
+	return int8(quantized)
+}
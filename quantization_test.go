@@ -0,0 +1,305 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Returns a quantized network of the test network, with
+// per-layer scales, an imputer, a scaler and class labels.
+func quantizationTestNetwork(t *testing.T) (*QuantizedNetwork, [][]float32) {
+	n, rows := codegenTestNetwork(t)
+
+	q, err := n.QuantizeWithConfig(rows, QuantizationConfig{Granularity: PerLayerQuantization})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return q, rows
+}
+
+func TestQuantizedSaveRoundTrip(t *testing.T) {
+	q, rows := quantizationTestNetwork(t)
+
+	var model bytes.Buffer
+	if err := q.Save(&model); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := QuantizedNetwork{}
+	if err := loaded.Load(bytes.NewReader(model.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded.HiddenLayer, q.HiddenLayer) || !reflect.DeepEqual(loaded.OutputLayer, q.OutputLayer) {
+		t.Errorf("the layers changed: %v, %v", loaded.HiddenLayer, loaded.OutputLayer)
+	}
+
+	if !reflect.DeepEqual(loaded.Imputer, q.Imputer) || !reflect.DeepEqual(loaded.Scaler, q.Scaler) || !reflect.DeepEqual(loaded.Labels, q.Labels) {
+		t.Errorf("the components changed: %v, %v, %v", loaded.Imputer, loaded.Scaler, loaded.Labels)
+	}
+
+	for i, row := range rows {
+		if got, want := loaded.Outputs(row), q.Outputs(row); !reflect.DeepEqual(got, want) {
+			t.Errorf("row %d: Outputs() = %v, want %v", i, got, want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "model.bp7q")
+	if err := q.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	fromFile := QuantizedNetwork{}
+	if err := fromFile.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(fromFile.HiddenLayer, q.HiddenLayer) {
+		t.Error("the hidden layer of the file changed")
+	}
+}
+
+func TestQuantizedLoadCorrupted(t *testing.T) {
+	q, _ := quantizationTestNetwork(t)
+
+	var model bytes.Buffer
+	if err := q.Save(&model); err != nil {
+		t.Fatal(err)
+	}
+
+	data := model.Bytes()
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[binaryHeaderSize + 20] ^= 1
+
+	for name, stream := range map[string][]byte{
+		"truncated": data[:len(data) - 10],
+		"corrupted": corrupted,
+		"float":     []byte("BP7B\x01\x00\x00\x00"),
+	} {
+		if err := (&QuantizedNetwork{}).Load(bytes.NewReader(stream)); err == nil {
+			t.Errorf("Load() accepted a %s model", name)
+		}
+	}
+}
+
+func TestQuantizedUninitialized(t *testing.T) {
+	q := &QuantizedNetwork{}
+
+	if outputs := q.Outputs([]float32{1, 2}); outputs != nil {
+		t.Errorf("Outputs() = %v, want nil", outputs)
+	}
+
+	if _, err := q.PredictBatch([][]float32{{1, 2}}); err != ErrUninitializedNetwork {
+		t.Errorf("PredictBatch() = %v, want ErrUninitializedNetwork", err)
+	}
+
+	var model bytes.Buffer
+	if err := q.Save(&model); err != ErrUninitializedNetwork {
+		t.Errorf("Save() = %v, want ErrUninitializedNetwork", err)
+	}
+
+	n := CreateNetwork(2, 2, 2)
+	if _, err := n.CompareQuantized(q, [][]float32{{1, 2, 0}}); err != ErrUninitializedNetwork {
+		t.Errorf("CompareQuantized() = %v, want ErrUninitializedNetwork", err)
+	}
+}
+
+// Returns a network which is trained on the cross validation
+// dataset, without the epoch output, and the rows of the dataset.
+func trainedQuantizationNetwork(t *testing.T) (*Network, [][]float32) {
+	d := crossValidationTestDataset(t)
+	rows := d.Rows()
+
+	n := CreateNetwork(2, 6, d.ClassCount())
+	n.HiddenLayer.Activation = TanhActivation
+	n.OutputLayer.Activation = SoftmaxActivation
+
+	if err := n.trainLoop(CreateSliceSource(rows, len(rows)), 0.3, 1000, d.ClassCount(), trainingLoop{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &n, rows
+}
+
+// Returns the largest absolute difference between the outputs
+// of a quantized network and the ones of its float32 network.
+func maxQuantizationError(n *Network, q *QuantizedNetwork, rows [][]float32) float32 {
+	largest := float32(0)
+
+	for _, row := range rows {
+		expected := n.infer(row)
+
+		for j, output := range q.Outputs(row) {
+			if difference := float32(math.Abs(float64(output - expected[j]))); difference > largest {
+				largest = difference
+			}
+		}
+	}
+
+	return largest
+}
+
+// Returns the rows without the class value after the features,
+// since the float32 neurons also read the value after the
+// features as an input of their bias.
+func quantizationFeatures(rows [][]float32) [][]float32 {
+	features := make([][]float32, len(rows))
+
+	for i, row := range rows {
+		features[i] = row[:len(row) - 1]
+	}
+
+	return features
+}
+
+func TestQuantizeTrainedOutputs(t *testing.T) {
+	n, rows := trainedQuantizationNetwork(t)
+	features := quantizationFeatures(rows)
+
+	perLayer, err := n.QuantizeWithConfig(rows, QuantizationConfig{Granularity: PerLayerQuantization})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	perNeuron, err := n.QuantizeWithConfig(rows, DefaultQuantizationConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		q         *QuantizedNetwork
+		tolerance float32
+	}{
+		{"per-layer", perLayer, 0.1},
+		{"per-neuron", perNeuron, 0.1},
+	} {
+		if largest := maxQuantizationError(n, test.q, features); largest > test.tolerance {
+			t.Errorf("%s: the largest output error is %g, want at most %g", test.name, largest, test.tolerance)
+		}
+	}
+
+	for _, layer := range []struct {
+		name      string
+		neurons   []Neuron
+		perLayer  QuantizedLayer
+		perNeuron QuantizedLayer
+	}{
+		{"hidden", n.HiddenLayer.Neurons, perLayer.HiddenLayer, perNeuron.HiddenLayer},
+		{"output", n.OutputLayer.Neurons, perLayer.OutputLayer, perNeuron.OutputLayer},
+	} {
+		for i, neuron := range layer.neurons {
+			if layer.perLayer.Scales[i] != layer.perLayer.Scales[0] {
+				t.Errorf("%s neuron %d: the per-layer scale %g differs from %g", layer.name, i, layer.perLayer.Scales[i], layer.perLayer.Scales[0])
+			}
+
+			// A neuron never needs a larger scale than the whole
+			// layer, so its weights are at least as precise.
+			if layer.perNeuron.Scales[i] > layer.perLayer.Scales[i] {
+				t.Errorf("%s neuron %d: the per-neuron scale %g is larger than the per-layer scale %g", layer.name, i, layer.perNeuron.Scales[i], layer.perLayer.Scales[i])
+			}
+
+			for _, quantized := range []QuantizedLayer{layer.perLayer, layer.perNeuron} {
+				scale := quantized.Scales[i]
+
+				for j, weight := range quantized.Weights[i] {
+					if difference := math.Abs(float64(neuron.Weights[j] - float32(weight) * scale)); difference > float64(scale) / 2 * 1.001 {
+						t.Errorf("%s neuron %d weight %d: the error %g is more than half of the scale %g", layer.name, i, j, difference, scale)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCompareQuantized(t *testing.T) {
+	n, rows := trainedQuantizationNetwork(t)
+
+	q, err := n.Quantize(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := n.CompareQuantized(q, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Float.Samples != len(rows) || report.Quantized.Samples != len(rows) {
+		t.Errorf("the reports have %d and %d samples, want %d", report.Float.Samples, report.Quantized.Samples, len(rows))
+	}
+
+	if report.AccuracyDelta != report.Quantized.Accuracy - report.Float.Accuracy {
+		t.Errorf("AccuracyDelta = %g, want %g", report.AccuracyDelta, report.Quantized.Accuracy - report.Float.Accuracy)
+	}
+
+	agreements := 0
+	largest := float32(0)
+
+	for _, row := range rows {
+		expected := n.infer(row)
+		outputs := q.Outputs(row)
+
+		if argmax(outputs) == argmax(expected) {
+			agreements++
+		}
+
+		for j := range outputs {
+			if difference := float32(math.Abs(float64(outputs[j] - expected[j]))); difference > largest {
+				largest = difference
+			}
+		}
+	}
+
+	if want := float32(agreements) / float32(len(rows)); report.Agreement != want || report.Agreement < 0 || report.Agreement > 1 {
+		t.Errorf("Agreement = %g, want %g", report.Agreement, want)
+	}
+
+	if report.MaxOutputError != largest {
+		t.Errorf("MaxOutputError = %g, want %g", report.MaxOutputError, largest)
+	}
+
+	// With a zero class value the float32 outputs depend on the
+	// features only, like the quantized ones, so both networks
+	// agree on every row within the quantization error.
+	unlabeled := make([][]float32, len(rows))
+	for i, row := range quantizationFeatures(rows) {
+		unlabeled[i] = append(append([]float32(nil), row...), 0)
+	}
+
+	report, err = n.CompareQuantized(q, unlabeled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.MaxOutputError > 0.1 {
+		t.Errorf("MaxOutputError = %g, want at most 0.1", report.MaxOutputError)
+	}
+
+	if report.Agreement < float32(len(rows) - 1) / float32(len(rows)) {
+		t.Errorf("Agreement = %g, want at most one disagreement", report.Agreement)
+	}
+
+	if report.AccuracyDelta < -0.2 {
+		t.Errorf("AccuracyDelta = %g, want at least -0.2", report.AccuracyDelta)
+	}
+}
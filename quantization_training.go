@@ -0,0 +1,233 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The state of quantization-aware training. While a network
+// has it, the forward pass of the training rounds the weights
+// and the inputs of each layer to int8, the way Quantize does,
+// so that the network learns weights which survive the
+// rounding. The gradients go straight through the rounding
+// to the float32 weights, which keep the small updates.
+type QuantizationTraining struct {
+	Config QuantizationConfig
+	// The smallest and the largest preprocessed input, and
+	// hidden output, which have been seen during the training.
+	// They take the place of the calibration of Quantize.
+	InputRange  [2]float32
+	HiddenRange [2]float32
+
+	// The rounded neurons of each layer, which are reused
+	// from row to row.
+	hidden []Neuron
+	output []Neuron
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Enables quantization-aware training, so that the next
+// trainings simulate the int8 network in the forward pass.
+// The observed ranges start empty. The state is not saved
+// with the float32 model, so QuantizeTrained has to be called
+// before the network is saved and loaded again, and its result
+// is saved with QuantizedNetwork.Save.
+// -Input config: The granularity of the weight scales.
+func (n *Network) EnableQuantizationTraining(config QuantizationConfig) error {
+	if config.Granularity != PerLayerQuantization && config.Granularity != PerNeuronQuantization {
+		return fmt.Errorf("bp7: unknown quantization granularity %q", config.Granularity)
+	}
+
+	n.QuantizationTraining = &QuantizationTraining{Config: config}
+
+	return nil
+}
+
+// Quantizes the network to int8 after quantization-aware
+// training, with the ranges which have been observed during
+// the training instead of calibration rows. The quantized
+// network is saved by QuantizedNetwork.Save and exported to C
+// by QuantizedNetwork.GenerateC, which both keep the observed
+// scales and the int8 weights unchanged.
+// -Output: The quantized network.
+func (n *Network) QuantizeTrained() (*QuantizedNetwork, error) {
+	if n.QuantizationTraining == nil {
+		return nil, errors.New("bp7: the network was not trained with quantization")
+	}
+
+	q := n.QuantizationTraining
+
+	return n.quantizeRanges(q.InputRange, q.HiddenRange, q.Config)
+}
+
+// Propagates a training row forward. With quantization-aware
+// training, the inputs, the weights and the hidden outputs are
+// rounded like in the quantized network. The outputs of the
+// neurons keep the rounded values, so that the back propagation
+// treats the rounding as the identity.
+// -Input row: The preprocessed training row.
+// -Output: The row with the rounded inputs, which updates the
+// hidden weights, and the output of the network.
+func (n *Network) trainingForward(row []float32) ([]float32, []float32) {
+	q := n.QuantizationTraining
+	if q == nil {
+		return row, n.forwardPropagate(row)
+	}
+
+	size := n.inputCount()
+	if size > len(row) {
+		size = len(row)
+	}
+
+	inputs := make([]float32, len(row))
+	copy(inputs, row)
+
+	extendRange(&q.InputRange, inputs[:size])
+	inputScale := fakeQuantize(inputs[:size], q.InputRange)
+
+	q.hidden = fakeQuantizeNeurons(n.HiddenLayer.Neurons, q.hidden, inputScale, q.Config.Granularity)
+
+	// The int8 layer reads the features only, so the rounded
+	// neurons do not see the class value after them.
+	hidden := make([]float32, len(q.hidden))
+	for i := range q.hidden {
+		hidden[i] = q.hidden[i].activate(inputs[:size])
+	}
+
+	hidden = n.HiddenLayer.Activation.apply(hidden)
+
+	extendRange(&q.HiddenRange, hidden)
+	hiddenScale := fakeQuantize(hidden, q.HiddenRange)
+
+	for i := range n.HiddenLayer.Neurons {
+		n.HiddenLayer.Neurons[i].Output = hidden[i]
+	}
+
+	q.output = fakeQuantizeNeurons(n.OutputLayer.Neurons, q.output, hiddenScale, q.Config.Granularity)
+
+	outputs := make([]float32, len(q.output))
+	for i := range q.output {
+		outputs[i] = q.output[i].activate(hidden)
+	}
+
+	outputs = n.OutputLayer.Activation.apply(outputs)
+
+	for i := range n.OutputLayer.Neurons {
+		n.OutputLayer.Neurons[i].Output = outputs[i]
+	}
+
+	return inputs, outputs
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Enables quantization-aware training on a network.
+// -Input n: A network.
+// -Input config: The granularity of the weight scales.
+func EnableQuantizationTraining(n *Network, config QuantizationConfig) error {
+	return n.EnableQuantizationTraining(config)
+}
+
+// Quantizes a network to int8 after quantization-aware
+// training.
+// -Input n: A network.
+// -Output: The quantized network.
+func QuantizeTrained(n *Network) (*QuantizedNetwork, error) {
+	return n.QuantizeTrained()
+}
+
+// Rounds values in place to the int8 steps of a range, like
+// the inputs of a quantized layer, and returns the scale.
+func fakeQuantize(values []float32, r [2]float32) float32 {
+	scale, zeroPoint := affineQuantization(r)
+
+	for i, value := range values {
+		if isMissing(value) {
+			continue
+		}
+
+		values[i] = float32(int32(quantizeValue(value, scale, zeroPoint)) - int32(zeroPoint)) * scale
+	}
+
+	return scale
+}
+
+// Rounds the weights of a layer to the int8 steps of their
+// scale, and the bias to the steps of the input scale times
+// the weight scale, like quantizeLayer.
+// -Input neurons: The neurons of the layer.
+// -Input rounded: The neurons of the previous row, which are
+// reused if they have the same shape.
+// -Input inputScale: The scale of the layer inputs.
+// -Input granularity: How many weights share a scale.
+// -Output: The neurons with the rounded weights.
+func fakeQuantizeNeurons(neurons []Neuron, rounded []Neuron, inputScale float32, granularity QuantizationGranularity) []Neuron {
+	size := len(neurons[0].Weights) - 1
+
+	if len(rounded) != len(neurons) || len(rounded[0].Weights) != size + 1 {
+		rounded = make([]Neuron, len(neurons))
+		for i := range rounded {
+			rounded[i].Weights = make([]float32, size + 1)
+		}
+	}
+
+	var layerMax float32
+	if granularity == PerLayerQuantization {
+		for _, neuron := range neurons {
+			layerMax = maxAbs(neuron.Weights[:size], layerMax)
+		}
+	}
+
+	for i, neuron := range neurons {
+		max := layerMax
+		if granularity != PerLayerQuantization {
+			max = maxAbs(neuron.Weights[:size], 0)
+		}
+
+		scale := max / 127
+		if scale == 0 {
+			scale = 1
+		}
+
+		weights := rounded[i].Weights
+		for j, weight := range neuron.Weights[:size] {
+			weights[j] = float32(math.Round(float64(weight / scale))) * scale
+		}
+
+		biasScale := float64(inputScale * scale)
+		weights[size] = float32(math.Round(float64(neuron.Weights[size]) / biasScale) * biasScale)
+	}
+
+	return rounded
+}
+
+// Returns the largest absolute value of values and max.
+func maxAbs(values []float32, max float32) float32 {
+	for _, value := range values {
+		if abs := float32(math.Abs(float64(value))); abs > max {
+			max = abs
+		}
+	}
+
+	return max
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"math"
+	"testing"
+)
+
+// Trains a network quietly on rows.
+func trainQuietly(t *testing.T, n *Network, rows [][]float32, epochs int) {
+	if err := n.trainLoop(CreateSliceSource(rows, len(rows)), 0.3, epochs, len(n.OutputLayer.Neurons), trainingLoop{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuantizationTrainingForward(t *testing.T) {
+	rows := crossValidationTestDataset(t).Rows()
+
+	n := CreateNetwork(2, 6, 3)
+	if err := n.EnableQuantizationTraining(DefaultQuantizationConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first pass observes the ranges of every row, so that
+	// the second pass rounds with the final scales.
+	for _, row := range rows {
+		n.trainingForward(row)
+	}
+
+	q, err := n.QuantizeTrained()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inputRange := n.QuantizationTraining.InputRange
+	scale, zeroPoint := affineQuantization(inputRange)
+
+	for i, row := range rows {
+		rounded, outputs := n.trainingForward(row)
+
+		for j, value := range row[:2] {
+			want := float32(int32(quantizeValue(value, scale, zeroPoint)) - int32(zeroPoint)) * scale
+			if rounded[j] != want {
+				t.Errorf("row %d: input %d = %g, want the rounded %g", i, j, rounded[j], want)
+			}
+		}
+
+		if rounded[2] != row[2] {
+			t.Errorf("row %d: the class value changed to %g", i, rounded[2])
+		}
+
+		// The training forward pass simulates the int8 network.
+		// The float32 sums of the simulation can round a hidden
+		// output near a half step the other way than the int32
+		// sums, which moves an output by a fraction of a step.
+		quantized := q.Outputs(row)
+		for j := range outputs {
+			if difference := math.Abs(float64(outputs[j] - quantized[j])); difference > 1e-3 {
+				t.Errorf("row %d: output %d = %g, want the quantized %g", i, j, outputs[j], quantized[j])
+			}
+		}
+	}
+
+	if n.QuantizationTraining.InputRange != inputRange {
+		t.Errorf("the input range changed from %v to %v", inputRange, n.QuantizationTraining.InputRange)
+	}
+}
+
+func TestQuantizationTrainingRanges(t *testing.T) {
+	rows := crossValidationTestDataset(t).Rows()
+
+	n := CreateNetwork(2, 6, 3)
+	if err := n.EnableQuantizationTraining(QuantizationConfig{Granularity: PerLayerQuantization}); err != nil {
+		t.Fatal(err)
+	}
+
+	initial := copyNetwork(&n)
+	trainQuietly(t, &n, rows, 10)
+
+	training := n.QuantizationTraining
+
+	// The ranges start at zero and the features of the dataset
+	// are between 0.1 and 0.95.
+	if training.InputRange != [2]float32{0, 0.95} {
+		t.Errorf("InputRange = %v, want [0 0.95]", training.InputRange)
+	}
+
+	if training.HiddenRange[0] > 0 || training.HiddenRange[1] <= 0 {
+		t.Errorf("HiddenRange = %v, want a range around the sigmoid outputs", training.HiddenRange)
+	}
+
+	q, err := n.QuantizeTrained()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, layer := range []struct {
+		name   string
+		layer  QuantizedLayer
+		limits [2]float32
+	}{
+		{"hidden", q.HiddenLayer, training.InputRange},
+		{"output", q.OutputLayer, training.HiddenRange},
+	} {
+		scale, zeroPoint := affineQuantization(layer.limits)
+		if layer.layer.InputScale != scale || layer.layer.InputZeroPoint != zeroPoint {
+			t.Errorf("%s layer: the input scale and zero point are %g, %d, want %g, %d", layer.name, layer.layer.InputScale, layer.layer.InputZeroPoint, scale, zeroPoint)
+		}
+	}
+
+	// The gradients go straight through the rounding, so the
+	// float32 weights keep the small updates instead of being
+	// replaced by their rounded values.
+	changed, offGrid := false, false
+
+	for i, neuron := range n.HiddenLayer.Neurons {
+		for j, weight := range neuron.Weights {
+			if weight != initial.HiddenLayer.Neurons[i].Weights[j] {
+				changed = true
+			}
+
+			if weight != training.hidden[i].Weights[j] {
+				offGrid = true
+			}
+		}
+	}
+
+	if !changed {
+		t.Error("the hidden weights did not change")
+	}
+
+	if !offGrid {
+		t.Error("the float32 hidden weights are the rounded ones")
+	}
+}
+
+func TestQuantizationTrainingAccuracy(t *testing.T) {
+	rows := crossValidationTestDataset(t).Rows()
+
+	n := CreateNetwork(2, 6, 3)
+	aware := copyNetwork(&n)
+
+	if err := aware.EnableQuantizationTraining(DefaultQuantizationConfig()); err != nil {
+		t.Fatal(err)
+	}
+
+	trainQuietly(t, &n, rows, 1000)
+	trainQuietly(t, aware, rows, 1000)
+
+	q, err := n.Quantize(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postTraining, err := n.CompareQuantized(q, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err = aware.QuantizeTrained()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trained, err := aware.CompareQuantized(q, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trained.Quantized.Accuracy < postTraining.Quantized.Accuracy {
+		t.Errorf("the quantization-aware accuracy %g is lower than the post-training one %g", trained.Quantized.Accuracy, postTraining.Quantized.Accuracy)
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// The version of the quantized model format.
+const QuantizedModelVersion = 1
+
+// The first bytes of a quantized model.
+const quantizedModelMagic = "BP7Q"
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Saves the quantized network in the little-endian binary
+// quantized model format, which keeps the int8 weights and the
+// scales unchanged. The layout is an 8 byte header, "BP7Q",
+// the version (uint16) and two zero bytes, followed by the
+// input, hidden and output sizes (uint32), then for the hidden
+// and the output layer the input scale (float32), the input
+// zero point (int8), the weights of each neuron (int8), the
+// weight scale (float32) and the bias (int32) of each neuron,
+// then the length (uint32) and the csv sections of the layer
+// activations and the attached components, and the CRC-32
+// (uint32) of everything after the header.
+// -Input w: The writer of the model.
+func (q *QuantizedNetwork) Save(w io.Writer) error {
+	if err := q.check(); err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+
+	sizes := []uint32{
+		uint32(len(q.HiddenLayer.Weights[0])),
+		uint32(len(q.HiddenLayer.Weights)),
+		uint32(len(q.OutputLayer.Weights)),
+	}
+
+	binary.Write(&payload, binary.LittleEndian, sizes)
+
+	for _, layer := range []*QuantizedLayer{&q.HiddenLayer, &q.OutputLayer} {
+		binary.Write(&payload, binary.LittleEndian, layer.InputScale)
+		binary.Write(&payload, binary.LittleEndian, layer.InputZeroPoint)
+
+		for _, weights := range layer.Weights {
+			binary.Write(&payload, binary.LittleEndian, weights)
+		}
+
+		binary.Write(&payload, binary.LittleEndian, layer.Scales)
+		binary.Write(&payload, binary.LittleEndian, layer.Bias)
+	}
+
+	var components bytes.Buffer
+	if err := writeSections(&components, q.sections()); err != nil {
+		return err
+	}
+
+	binary.Write(&payload, binary.LittleEndian, uint32(components.Len()))
+	payload.Write(components.Bytes())
+	binary.Write(&payload, binary.LittleEndian, crc32.ChecksumIEEE(payload.Bytes()))
+
+	header := make([]byte, binaryHeaderSize)
+	copy(header, quantizedModelMagic)
+	binary.LittleEndian.PutUint16(header[4:], QuantizedModelVersion)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload.Bytes())
+
+	return err
+}
+
+// Loads a quantized model written by Save into the quantized
+// network.
+// -Input r: The reader of the model.
+func (q *QuantizedNetwork) Load(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < binaryHeaderSize || string(data[:4]) != quantizedModelMagic {
+		return errors.New("bp7: the stream is not a quantized bp7 model")
+	}
+
+	version := binary.LittleEndian.Uint16(data[4:])
+	if version < 1 || version > QuantizedModelVersion {
+		return fmt.Errorf("bp7: unsupported quantized model version %d", version)
+	}
+
+	payload := data[binaryHeaderSize:]
+	if len(payload) < 20 {
+		return errors.New("bp7: the quantized model is truncated")
+	}
+
+	body := payload[:len(payload) - 4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(payload[len(body):]) {
+		return errors.New("bp7: the quantized model checksum does not match, the file is corrupted")
+	}
+
+	inputs := int64(binary.LittleEndian.Uint32(body[0:]))
+	hidden := int64(binary.LittleEndian.Uint32(body[4:]))
+	outputs := int64(binary.LittleEndian.Uint32(body[8:]))
+
+	// The sizes come from the file, so they are checked before
+	// anything is sliced or allocated with them.
+	for _, size := range []int64{inputs, hidden, outputs} {
+		if size > maxBinaryLayerSize {
+			return fmt.Errorf("bp7: the quantized model has a layer size %d larger than %d", size, maxBinaryLayerSize)
+		}
+	}
+
+	if inputs < 1 || hidden < 1 || outputs < 1 {
+		return errors.New("bp7: the quantized model has an empty layer")
+	}
+
+	// Each layer has its input scale and zero point, a weight
+	// byte per input of each neuron and the scale and the bias
+	// of each neuron.
+	length := 12 + 5 + hidden * (inputs + 8) + 5 + outputs * (hidden + 8) + 4
+	if length > int64(len(body)) {
+		return errors.New("bp7: the quantized model is truncated")
+	}
+
+	loaded := QuantizedNetwork{}
+	position := 12
+
+	loaded.HiddenLayer, position = readQuantizedLayer(body, position, int(hidden), int(inputs))
+	loaded.OutputLayer, position = readQuantizedLayer(body, position, int(outputs), int(hidden))
+
+	componentsLength := int64(binary.LittleEndian.Uint32(body[position:]))
+	if componentsLength != int64(len(body) - position - 4) {
+		return errors.New("bp7: the quantized model is truncated")
+	}
+
+	sections, err := readSections(bytes.NewReader(body[position + 4:]))
+	if err != nil {
+		return err
+	}
+
+	if err := loaded.parseSections(sections); err != nil {
+		return err
+	}
+
+	if loaded.Calibration != nil {
+		if err := loaded.Calibration.check(int(outputs)); err != nil {
+			return err
+		}
+	}
+
+	*q = loaded
+
+	return nil
+}
+
+// Saves the quantized network into a quantized model file.
+// -Input filePath: The path of the file.
+func (q *QuantizedNetwork) SaveFile(filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := q.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Loads a quantized model file into the quantized network.
+// -Input filePath: The path of the file.
+func (q *QuantizedNetwork) LoadFile(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return q.Load(file)
+}
+
+// Returns the sections of the layer activations followed by
+// the sections of the attached components.
+func (q *QuantizedNetwork) sections() []section {
+	sections := []section{{"activations", [][]string{
+		{"hidden", string(q.HiddenLayer.Activation.name())},
+		{"output", string(q.OutputLayer.Activation.name())},
+	}}}
+
+	if q.Calibration != nil {
+		sections = append(sections, section{"calibration", q.Calibration.records()})
+	}
+
+	if q.Imputer != nil {
+		sections = append(sections, section{"imputer", q.Imputer.records()})
+	}
+
+	if q.Scaler != nil {
+		sections = append(sections, section{"scaler", q.Scaler.records()})
+	}
+
+	if q.Labels != nil {
+		sections = append(sections, section{"labels", q.Labels.records()})
+	}
+
+	return sections
+}
+
+// Restores the layer activations and the attached components
+// from their sections.
+// -Input sections: The sections of the model.
+func (q *QuantizedNetwork) parseSections(sections []section) error {
+	components := Network{}
+
+	for _, s := range sections {
+		switch s.name {
+		case "activations":
+			if err := components.parseActivations(s.records); err != nil {
+				return err
+			}
+		case "calibration", "imputer", "scaler", "labels":
+			if _, err := components.parseSection(s); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("bp7: unknown quantized model section %q", s.name)
+		}
+	}
+
+	q.HiddenLayer.Activation = components.HiddenLayer.Activation
+	q.OutputLayer.Activation = components.OutputLayer.Activation
+	q.Calibration = components.Calibration
+	q.Imputer = components.Imputer
+	q.Scaler = components.Scaler
+	q.Labels = components.Labels
+
+	return nil
+}
+
+// Checks that both layers have neurons and that the weights,
+// the scales and the bias of the layers agree on their sizes.
+// -Output: ErrUninitializedNetwork if a layer is empty.
+func (q *QuantizedNetwork) check() error {
+	if len(q.HiddenLayer.Weights) == 0 || len(q.OutputLayer.Weights) == 0 || len(q.HiddenLayer.Weights[0]) == 0 {
+		return ErrUninitializedNetwork
+	}
+
+	if err := q.HiddenLayer.check(len(q.HiddenLayer.Weights[0])); err != nil {
+		return fmt.Errorf("bp7: the hidden layer %v", err)
+	}
+
+	if err := q.OutputLayer.check(len(q.HiddenLayer.Weights)); err != nil {
+		return fmt.Errorf("bp7: the output layer %v", err)
+	}
+
+	return nil
+}
+
+// Checks the sizes of a quantized layer.
+// -Input inputs: How many inputs each neuron must have.
+func (l *QuantizedLayer) check(inputs int) error {
+	if len(l.Scales) != len(l.Weights) || len(l.Bias) != len(l.Weights) {
+		return fmt.Errorf("has %d neurons but %d scales and %d biases", len(l.Weights), len(l.Scales), len(l.Bias))
+	}
+
+	for i, weights := range l.Weights {
+		if len(weights) != inputs {
+			return fmt.Errorf("neuron %d has %d weights, expected %d", i, len(weights), inputs)
+		}
+	}
+
+	return nil
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Reads a quantized layer of the quantized model format.
+// -Input body: The payload of the model.
+// -Input position: Where the layer starts.
+// -Input neurons: How many neurons the layer has.
+// -Input inputs: How many inputs each neuron has.
+// -Output: The layer and the position after it.
+func readQuantizedLayer(body []byte, position int, neurons int, inputs int) (QuantizedLayer, int) {
+	layer := QuantizedLayer{
+		Weights: make([][]int8, neurons),
+		Scales:  make([]float32, neurons),
+		Bias:    make([]int32, neurons),
+	}
+
+	layer.InputScale = math.Float32frombits(binary.LittleEndian.Uint32(body[position:]))
+	layer.InputZeroPoint = int8(body[position + 4])
+	position += 5
+
+	weights := make([]int8, neurons * inputs)
+	for i := range weights {
+		weights[i] = int8(body[position + i])
+	}
+
+	position += len(weights)
+
+	for i := 0; i < neurons; i++ {
+		layer.Weights[i] = weights[i * inputs:(i + 1) * inputs:(i + 1) * inputs]
+		layer.Scales[i] = math.Float32frombits(binary.LittleEndian.Uint32(body[position + 4 * i:]))
+		layer.Bias[i] = int32(binary.LittleEndian.Uint32(body[position + 4 * (neurons + i):]))
+	}
+
+	return layer, position + 8 * neurons
+}
@@ -0,0 +1,220 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// How a scaler maps each feature.
+type ScaleMethod string
+
+const (
+	// Maps the training range of the feature to [0, 1].
+	MinMaxScaling ScaleMethod = "minmax"
+	// Subtracts the mean and divides by the standard
+	// deviation of the feature (z-score).
+	StandardScaling ScaleMethod = "standard"
+	// Subtracts the median and divides by the interquartile
+	// range of the feature, which ignores the outliers.
+	RobustScaling ScaleMethod = "robust"
+	// Divides by the maximum absolute value of the feature,
+	// which maps it to [-1, 1] and keeps the zeros.
+	MaxAbsScaling ScaleMethod = "maxabs"
+	// Applies sign(x) * log(1 + |x|) on the feature. It has
+	// no fitted parameters and compresses long tails.
+	LogScaling ScaleMethod = "log"
+)
+
+// The file name of the scaler when it is extracted
+// together with the layer weights.
+const scalerFileName = "scaler.csv"
+
+// A scaler fitted on training features. Every method except
+// the log transform maps a feature x to (x - Offset) / Scale.
+type Scaler struct {
+	Method ScaleMethod
+	Offset []float32
+	Scale  []float32
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Scales a feature vector. If the vector is longer than the
+// fitted features, like a row which ends with its class
+// index, the extra values are copied unchanged. Missing
+// (NaN) values stay missing.
+// -Input features: The feature vector.
+// -Output: A new scaled vector.
+func (s *Scaler) Transform(features []float32) []float32 {
+	scaled := make([]float32, len(features))
+	copy(scaled, features)
+
+	for i := 0; i < len(s.Offset) && i < len(scaled); i++ {
+		if s.Method == LogScaling {
+			x := float64(scaled[i])
+			scaled[i] = float32(math.Copysign(math.Log1p(math.Abs(x)), x))
+		} else {
+			scaled[i] = (scaled[i] - s.Offset[i]) / s.Scale[i]
+		}
+	}
+
+	return scaled
+}
+
+// Maps a scaled feature vector back to the original units.
+// -Input scaled: The scaled feature vector.
+// -Output: A new vector in the original units.
+func (s *Scaler) InverseTransform(scaled []float32) []float32 {
+	features := make([]float32, len(scaled))
+	copy(features, scaled)
+
+	for i := 0; i < len(s.Offset) && i < len(features); i++ {
+		if s.Method == LogScaling {
+			x := float64(features[i])
+			features[i] = float32(math.Copysign(math.Expm1(math.Abs(x)), x))
+		} else {
+			features[i] = features[i] * s.Scale[i] + s.Offset[i]
+		}
+	}
+
+	return features
+}
+
+// Converts the scaler into csv records.
+func (s *Scaler) records() [][]string {
+	return [][]string{
+		{"method", string(s.Method)},
+		append([]string{"offset"}, formatFloats(s.Offset)...),
+		append([]string{"scale"}, formatFloats(s.Scale)...),
+	}
+}
+
+// Restores the scaler from its csv records.
+func (s *Scaler) parseRecords(records [][]string) error {
+	for _, record := range records {
+		if len(record) < 2 {
+			return fmt.Errorf("bp7: invalid scaler record %v", record)
+		}
+
+		var err error
+
+		switch record[0] {
+		case "method":
+			s.Method = ScaleMethod(record[1])
+		case "offset":
+			s.Offset, err = parseFloats(record[1:])
+		case "scale":
+			s.Scale, err = parseFloats(record[1:])
+		default:
+			err = fmt.Errorf("bp7: unknown scaler record %q", record[0])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(s.Offset) != len(s.Scale) {
+		return errors.New("bp7: the scaler offsets and scales differ in length")
+	}
+
+	return nil
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Fits a scaler on training features. The missing (NaN)
+// values are ignored.
+// -Input features: The training feature vectors.
+// -Input method: The scaling method.
+// -Output: The fitted scaler.
+func FitScaler(features [][]float32, method ScaleMethod) (*Scaler, error) {
+	if len(features) == 0 {
+		return nil, errors.New("bp7: there are no features to fit the scaler on")
+	}
+
+	columns := len(features[0])
+
+	scaler := &Scaler{
+		Method: method,
+		Offset: make([]float32, columns),
+		Scale:  make([]float32, columns),
+	}
+
+	for i := 0; i < columns; i++ {
+		values := make([]float32, 0, len(features))
+
+		for _, entry := range features {
+			if !isMissing(entry[i]) {
+				values = append(values, entry[i])
+			}
+		}
+
+		if len(values) == 0 {
+			return nil, fmt.Errorf("bp7: feature %d has no values to fit the scaler on", i)
+		}
+
+		sorted := sortedCopy(values)
+
+		var offset, scale float32
+
+		switch method {
+		case MinMaxScaling:
+			offset = sorted[0]
+			scale = sorted[len(sorted) - 1] - sorted[0]
+		case StandardScaling:
+			offset = mean(values)
+			scale = standardDeviation(values, offset)
+		case RobustScaling:
+			offset = quantile(sorted, 0.5)
+			scale = quantile(sorted, 0.75) - quantile(sorted, 0.25)
+		case MaxAbsScaling:
+			scale = float32(math.Max(math.Abs(float64(sorted[0])), math.Abs(float64(sorted[len(sorted) - 1]))))
+		case LogScaling:
+		default:
+			return nil, fmt.Errorf("bp7: unknown scaling method %q", method)
+		}
+
+		// A constant feature is only shifted, so that it does
+		// not divide by zero.
+		if scale == 0 {
+			scale = 1
+		}
+
+		scaler.Offset[i] = offset
+		scaler.Scale[i] = scale
+	}
+
+	return scaler, nil
+}
+
+// Calculates the population standard deviation of a vector.
+func standardDeviation(values []float32, mean float32) float32 {
+	var sum float64 = 0.0
+
+	for _, value := range values {
+		deviation := float64(value - mean)
+		sum += deviation * deviation
+	}
+
+	return float32(math.Sqrt(sum / float64(len(values))))
+}
@@ -0,0 +1,295 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// How a dataset is split.
+type SplitMethod string
+
+const (
+	// Shuffles the entries and splits them by the ratios.
+	RandomSplit SplitMethod = "random"
+	// Splits every class by the ratios, so that every split
+	// keeps the class ratios of the whole dataset.
+	StratifiedSplit SplitMethod = "stratified"
+	// Shuffles the groups of entries sharing the same key and
+	// splits the groups, so that a group is never divided.
+	GroupSplit SplitMethod = "group"
+	// Keeps the entries in time order, so that the earliest
+	// entries go to the first split and the latest to the last.
+	TimeSplit SplitMethod = "time"
+)
+
+// The configuration of a dataset split.
+type SplitConfig struct {
+	Method SplitMethod
+	// The column which holds the group key of the GroupSplit
+	// method. It is a categorical or a numeric feature column,
+	// so an ID column has to be loaded as categorical instead
+	// of ignored.
+	GroupColumn string
+	// The numeric feature column which orders the entries of
+	// the TimeSplit method. When it is empty the entries are
+	// taken in the order of the dataset.
+	TimeColumn string
+}
+
+// Returns the default split configuration, which is
+// stratified by class.
+func DefaultSplitConfig() SplitConfig {
+	return SplitConfig{Method: StratifiedSplit}
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Splits the dataset by class, like into a training, a
+// validation and a test dataset.
+// -Input ratios: The relative size of each split, like
+// [0.7, 0.15, 0.15]. They do not have to sum to one.
+// -Input seed: The seed of the shuffle, so that a split
+// can be repeated.
+// -Output: One dataset per ratio.
+func (d *Dataset) Split(ratios []float64, seed int64) ([]*Dataset, error) {
+	return d.SplitWithConfig(ratios, seed, DefaultSplitConfig())
+}
+
+// Splits the dataset, like into a training, a validation
+// and a test dataset.
+// -Input ratios: The relative size of each split.
+// -Input seed: The seed of the shuffle.
+// -Input config: The split method and its columns.
+// -Output: One dataset per ratio.
+func (d *Dataset) SplitWithConfig(ratios []float64, seed int64, config SplitConfig) ([]*Dataset, error) {
+	if len(ratios) == 0 {
+		return nil, errors.New("bp7: there are no split ratios")
+	}
+
+	var total float64 = 0.0
+
+	for _, ratio := range ratios {
+		if ratio <= 0 || math.IsNaN(ratio) || math.IsInf(ratio, 0) {
+			return nil, fmt.Errorf("bp7: invalid split ratio %v", ratio)
+		}
+
+		total += ratio
+	}
+
+	random := rand.New(rand.NewSource(seed))
+	parts := make([][]int, len(ratios))
+
+	switch config.Method {
+	case RandomSplit:
+		parts = divide(random.Perm(d.Len()), ratios)
+	case StratifiedSplit:
+		classes := make([][]int, d.ClassCount())
+		for i := 0; i < d.Len(); i++ {
+			classes[d.Targets[i]] = append(classes[d.Targets[i]], i)
+		}
+
+		for _, class := range classes {
+			random.Shuffle(len(class), func(a, b int) {
+				class[a], class[b] = class[b], class[a]
+			})
+
+			for i, part := range divide(class, ratios) {
+				parts[i] = append(parts[i], part...)
+			}
+		}
+	case GroupSplit:
+		keys, err := d.columnKeys(config.GroupColumn)
+		if err != nil {
+			return nil, err
+		}
+
+		parts = d.divideGroups(keys, ratios, total, random)
+	case TimeSplit:
+		order := make([]int, d.Len())
+		for i := 0; i < len(order); i++ {
+			order[i] = i
+		}
+
+		if config.TimeColumn != "" {
+			column := d.FeatureIndex(config.TimeColumn)
+			if column < 0 {
+				return nil, fmt.Errorf("bp7: there is no numeric feature %q", config.TimeColumn)
+			}
+
+			sort.SliceStable(order, func(a, b int) bool {
+				return d.Features[order[a]][column] < d.Features[order[b]][column]
+			})
+		}
+
+		parts = divide(order, ratios)
+	default:
+		return nil, fmt.Errorf("bp7: unknown split method %q", config.Method)
+	}
+
+	splits := make([]*Dataset, len(parts))
+
+	for i, part := range parts {
+		// The classes and the groups are appended one after
+		// the other, so they are mixed again for the training.
+		if config.Method == StratifiedSplit || config.Method == GroupSplit {
+			random.Shuffle(len(part), func(a, b int) {
+				part[a], part[b] = part[b], part[a]
+			})
+		}
+
+		splits[i] = d.Subset(part)
+	}
+
+	return splits, nil
+}
+
+// Returns the value of a categorical or a numeric feature
+// column for every entry.
+func (d *Dataset) columnKeys(column string) ([]string, error) {
+	keys := make([]string, d.Len())
+
+	if index := indexOf(d.CategoricalNames, column); index >= 0 {
+		for i := 0; i < d.Len(); i++ {
+			keys[i] = d.Categorical[i][index]
+		}
+
+		return keys, nil
+	}
+
+	if index := d.FeatureIndex(column); index >= 0 {
+		for i := 0; i < d.Len(); i++ {
+			keys[i] = strconv.FormatFloat(float64(d.Features[i][index]), 'g', -1, 32)
+		}
+
+		return keys, nil
+	}
+
+	return nil, fmt.Errorf("bp7: there is no group column %q", column)
+}
+
+// Shuffles the groups and gives each group to the split
+// which is furthest from its target size.
+func (d *Dataset) divideGroups(keys []string, ratios []float64, total float64, random *rand.Rand) [][]int {
+	groups := make([][]int, 0)
+	positions := make(map[string]int)
+
+	for i, key := range keys {
+		position, found := positions[key]
+		if !found {
+			position = len(groups)
+			positions[key] = position
+			groups = append(groups, nil)
+		}
+
+		groups[position] = append(groups[position], i)
+	}
+
+	random.Shuffle(len(groups), func(a, b int) {
+		groups[a], groups[b] = groups[b], groups[a]
+	})
+
+	parts := make([][]int, len(ratios))
+
+	for _, group := range groups {
+		best, bestDeficit := 0, math.Inf(-1)
+
+		for i, ratio := range ratios {
+			deficit := ratio / total * float64(len(keys)) - float64(len(parts[i]))
+			if deficit > bestDeficit {
+				best, bestDeficit = i, deficit
+			}
+		}
+
+		parts[best] = append(parts[best], group...)
+	}
+
+	return parts
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Splits a dataset by class, like into a training, a
+// validation and a test dataset.
+// -Input d: The dataset.
+// -Input ratios: The relative size of each split.
+// -Input seed: The seed of the shuffle.
+// -Output: One dataset per ratio.
+func Split(d *Dataset, ratios []float64, seed int64) ([]*Dataset, error) {
+	return d.Split(ratios, seed)
+}
+
+// Splits a dataset, like into a training, a validation
+// and a test dataset.
+// -Input d: The dataset.
+// -Input ratios: The relative size of each split.
+// -Input seed: The seed of the shuffle.
+// -Input config: The split method and its columns.
+// -Output: One dataset per ratio.
+func SplitWithConfig(d *Dataset, ratios []float64, seed int64, config SplitConfig) ([]*Dataset, error) {
+	return d.SplitWithConfig(ratios, seed, config)
+}
+
+// Divides a list of indexes by the ratios, in order. The
+// sizes are rounded with the largest remainders, so that
+// every index is kept.
+func divide(indexes []int, ratios []float64) [][]int {
+	var total float64 = 0.0
+	for _, ratio := range ratios {
+		total += ratio
+	}
+
+	sizes := make([]int, len(ratios))
+	remainders := make([]int, len(ratios))
+	assigned := 0
+
+	for i, ratio := range ratios {
+		exact := ratio / total * float64(len(indexes))
+		sizes[i] = int(exact)
+		assigned += sizes[i]
+		remainders[i] = i
+	}
+
+	sort.SliceStable(remainders, func(a, b int) bool {
+		x := ratios[remainders[a]] / total * float64(len(indexes))
+		y := ratios[remainders[b]] / total * float64(len(indexes))
+
+		return x - math.Floor(x) > y - math.Floor(y)
+	})
+
+	for i := 0; assigned < len(indexes); i++ {
+		sizes[remainders[i % len(remainders)]]++
+		assigned++
+	}
+
+	parts := make([][]int, len(ratios))
+	start := 0
+
+	for i, size := range sizes {
+		parts[i] = append([]int(nil), indexes[start:start + size]...)
+		start += size
+	}
+
+	return parts
+}
@@ -0,0 +1,314 @@
+// Copyright 2021 Anastasios Daris
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp7
+
+import (
+	"errors"
+	"fmt"
+)
+
+// What a threshold sweep optimizes.
+type ThresholdCriterion string
+
+const (
+	// Picks the threshold with the highest F1 score.
+	MaximizeF1 ThresholdCriterion = "f1"
+	// Picks the threshold with the highest Youden's J
+	// statistic (sensitivity + specificity - 1).
+	MaximizeYoudenJ ThresholdCriterion = "youden"
+	// Picks the highest threshold whose recall reaches a
+	// target, which keeps the false negatives bounded.
+	TargetRecall ThresholdCriterion = "recall"
+)
+
+// The file name of the decision threshold when it is
+// extracted together with the layer weights.
+const thresholdFileName = "threshold.csv"
+
+// The decision threshold of a binary network. An entry is
+// predicted as class 1 (the positive class) when the
+// probability of class 1 is at least Value.
+type DecisionThreshold struct {
+	Value     float32
+	Criterion ThresholdCriterion
+	// The recall the threshold was tuned for. It is only
+	// used by the TargetRecall criterion.
+	Target float32
+}
+
+// The confusion counts and the metrics of a binary
+// classifier at a given threshold.
+type ThresholdPoint struct {
+	Threshold      float32
+	TruePositives  int
+	FalsePositives int
+	TrueNegatives  int
+	FalseNegatives int
+	Precision      float32
+	Recall         float32
+	Specificity    float32
+	F1             float32
+	YoudenJ        float32
+}
+
+// ======================= //
+// The structure functions //
+// ======================= //
+
+// Sweeps the decision thresholds of a binary network over a
+// validation set and attaches the best one to the network.
+// -Input validation: Labelled rows which were not used for training.
+// -Input criterion: What the sweep optimizes.
+// -Input target: The target recall of the TargetRecall
+// criterion. It is ignored by the other criteria.
+func (n *Network) TuneThreshold(validation [][]float32, criterion ThresholdCriterion, target float32) error {
+	threshold, err := FitThreshold(n, validation, criterion, target)
+	if err != nil {
+		return err
+	}
+
+	n.Threshold = threshold
+
+	return nil
+}
+
+// Given an input row of a binary network, it predicts class 1
+// when the probability of class 1 reaches the decision threshold
+// of the network and class 0 otherwise. Without a tuned
+// threshold the probability is compared with 0.5. A network
+// which does not have exactly two outputs predicts the class
+// with the largest probability instead.
+// -Input row: An entry to predict the category.
+// -Output: The predicted category, or an error if the
+// calibrator does not match the network.
+func (n *Network) PredictWithThreshold(row []float32) (int, error) {
+	var threshold float32 = 0.5
+
+	if n.Threshold != nil {
+		threshold = n.Threshold.Value
+	}
+
+	probabilities, err := n.PredictProbabilities(row)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(probabilities) != 2 {
+		return argmax(probabilities), nil
+	}
+
+	if probabilities[1] >= threshold {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+// Converts the threshold into csv records.
+func (t *DecisionThreshold) records() [][]string {
+	return [][]string{
+		{"value", fmt.Sprintf("%g", t.Value)},
+		{"criterion", string(t.Criterion)},
+		{"target", fmt.Sprintf("%g", t.Target)},
+	}
+}
+
+// Restores the threshold from its csv records.
+func (t *DecisionThreshold) parseRecords(records [][]string) error {
+	for _, record := range records {
+		if len(record) != 2 {
+			return fmt.Errorf("bp7: invalid threshold record %v", record)
+		}
+
+		switch record[0] {
+		case "criterion":
+			t.Criterion = ThresholdCriterion(record[1])
+		case "value", "target":
+			values, err := parseFloats(record[1:])
+			if err != nil {
+				return err
+			}
+
+			if record[0] == "value" {
+				t.Value = values[0]
+			} else {
+				t.Target = values[0]
+			}
+		default:
+			return fmt.Errorf("bp7: unknown threshold record %q", record[0])
+		}
+	}
+
+	return nil
+}
+
+// ======================== //
+// The standalone functions //
+// ======================== //
+
+// Sweeps the decision thresholds of a binary network over a
+// validation set and returns the best one. The probability of
+// class 1 is calibrated if the network has a calibrator.
+// -Input n: A trained network with two output neurons.
+// -Input validation: Labelled rows which were not used for training.
+// -Input criterion: What the sweep optimizes.
+// -Input target: The target recall of the TargetRecall
+// criterion. It is ignored by the other criteria.
+// -Output: The decision threshold.
+func FitThreshold(n *Network, validation [][]float32, criterion ThresholdCriterion, target float32) (*DecisionThreshold, error) {
+	if len(n.OutputLayer.Neurons) != 2 {
+		return nil, fmt.Errorf("bp7: threshold tuning needs a binary network, this one has %d outputs", len(n.OutputLayer.Neurons))
+	}
+
+	probabilities, err := n.PredictProbabilitiesBatch(validation)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make([]float32, len(validation))
+	positives := make([]bool, len(validation))
+
+	for i := 0; i < len(validation); i++ {
+		scores[i] = probabilities[i][1]
+		positives[i] = validation[i][len(validation[i]) - 1] == 1
+	}
+
+	curve := ThresholdCurve(scores, positives)
+	if len(curve) == 0 {
+		return nil, errors.New("bp7: the validation set is empty")
+	}
+
+	best := -1
+
+	for i := 0; i < len(curve); i++ {
+		switch criterion {
+		case MaximizeF1:
+			if best < 0 || curve[i].F1 > curve[best].F1 {
+				best = i
+			}
+		case MaximizeYoudenJ:
+			if best < 0 || curve[i].YoudenJ > curve[best].YoudenJ {
+				best = i
+			}
+		case TargetRecall:
+			// The curve goes from the highest to the lowest
+			// threshold, so the first point which reaches the
+			// target has the fewest false positives.
+			if best < 0 && curve[i].Recall >= target {
+				best = i
+			}
+		default:
+			return nil, fmt.Errorf("bp7: unknown threshold criterion %q", criterion)
+		}
+	}
+
+	if best < 0 {
+		return nil, fmt.Errorf("bp7: no threshold reaches a recall of %g", target)
+	}
+
+	threshold := &DecisionThreshold{Value: curve[best].Threshold, Criterion: criterion}
+	if criterion == TargetRecall {
+		threshold.Target = target
+	}
+
+	return threshold, nil
+}
+
+// Sweeps the decision thresholds of a binary network over a
+// validation set and attaches the best one to the network.
+// -Input n: A trained network with two output neurons.
+// -Input validation: Labelled rows which were not used for training.
+// -Input criterion: What the sweep optimizes.
+// -Input target: The target recall of the TargetRecall
+// criterion. It is ignored by the other criteria.
+func TuneThreshold(n *Network, validation [][]float32, criterion ThresholdCriterion, target float32) error {
+	return n.TuneThreshold(validation, criterion, target)
+}
+
+// Given an input row of a binary network, it predicts class 1
+// when the probability of class 1 reaches the decision threshold
+// of the network and class 0 otherwise.
+// -Input n: A network with two output neurons.
+// -Input row: An entry to predict the category.
+// -Output: The predicted category, 0 or 1, or an error if the
+// calibrator does not match the network.
+func PredictWithThreshold(n *Network, row []float32) (int, error) {
+	return n.PredictWithThreshold(row)
+}
+
+// Calculates the metrics of a binary classifier at every
+// distinct score, from the highest to the lowest. At each
+// point the entries scored at least the threshold are
+// predicted as positive.
+// -Input scores: The score of the positive class of each entry.
+// -Input positives: Whether each entry belongs to the positive class.
+// -Output: One point per distinct score.
+func ThresholdCurve(scores []float32, positives []bool) []ThresholdPoint {
+	indexes := sortedByScore(scores)
+
+	positiveCount := 0
+	for i := 0; i < len(positives); i++ {
+		if positives[i] {
+			positiveCount++
+		}
+	}
+
+	negativeCount := len(positives) - positiveCount
+
+	curve := make([]ThresholdPoint, 0)
+	truePositives, falsePositives := 0, 0
+
+	for start := 0; start < len(indexes); {
+		end := start
+		for end < len(indexes) && scores[indexes[end]] == scores[indexes[start]] {
+			if positives[indexes[end]] {
+				truePositives++
+			} else {
+				falsePositives++
+			}
+
+			end++
+		}
+
+		point := ThresholdPoint{
+			Threshold:      scores[indexes[start]],
+			TruePositives:  truePositives,
+			FalsePositives: falsePositives,
+			TrueNegatives:  negativeCount - falsePositives,
+			FalseNegatives: positiveCount - truePositives,
+		}
+
+		point.Precision = ratio(truePositives, truePositives + falsePositives)
+		point.Recall = ratio(truePositives, positiveCount)
+		point.Specificity = ratio(point.TrueNegatives, negativeCount)
+		point.F1 = ratio(2 * truePositives, 2 * truePositives + falsePositives + point.FalseNegatives)
+		point.YoudenJ = point.Recall + point.Specificity - 1
+
+		curve = append(curve, point)
+
+		start = end
+	}
+
+	return curve
+}
+
+// Divides two counts, returning zero when the denominator is zero.
+func ratio(numerator int, denominator int) float32 {
+	if denominator == 0 {
+		return 0
+	}
+
+	return float32(numerator) / float32(denominator)
+}
@@ -16,13 +16,16 @@ package bp7
 
 import(
 	"encoding/csv"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"strconv"
 )
 
-// Converts a .csv file into a two dimensional array.
+// Converts a .csv file into a two dimensional array. For files
+// with a header, named columns or a target which is not the last
+// column, use LoadDataset instead.
 func CreateDataset(filePath string) [][]float32 {
 	csvFile, err := os.Open(filePath)
 	if err != nil {
@@ -59,4 +62,113 @@ func CreateDataset(filePath string) [][]float32 {
 	}
 
 	return dataSet
-}
\ No newline at end of file
+}
+
+// Returns the index of the maximum value of an output
+// vector. If more than one values are equal to the
+// maximum, the first index is returned.
+// -Input outputs: The output vector of the network.
+// -Output: The index of the maximum value.
+func argmax(outputs []float32) int {
+	index := 0
+
+	for i := 1; i < len(outputs); i++ {
+		if outputs[i] > outputs[index] {
+			index = i
+		}
+	}
+
+	return index
+}
+
+// Scales an output vector so that its values sum up to
+// one. If the sum is zero, every value gets the same share.
+// -Input outputs: The output vector of the network.
+// -Output: A new normalized vector.
+func normalize(outputs []float32) []float32 {
+	normalized := make([]float32, len(outputs))
+
+	var sum float32 = 0.0
+
+	for i := 0; i < len(outputs); i++ {
+		sum += outputs[i]
+	}
+
+	for i := 0; i < len(outputs); i++ {
+		if sum == 0 {
+			normalized[i] = 1 / float32(len(outputs))
+		} else {
+			normalized[i] = outputs[i] / sum
+		}
+	}
+
+	return normalized
+}
+
+
+// Formats each value of a vector with the same %g
+// verb used for the extracted weights.
+func formatFloats(values []float32) []string {
+	formatted := make([]string, len(values))
+
+	for i := 0; i < len(values); i++ {
+		formatted[i] = fmt.Sprintf("%g", values[i])
+	}
+
+	return formatted
+}
+
+// Parses each value of a csv record into a float.
+func parseFloats(record []string) ([]float32, error) {
+	values := make([]float32, len(record))
+
+	for i := 0; i < len(record); i++ {
+		value, err := strconv.ParseFloat(record[i], 32)
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = float32(value)
+	}
+
+	return values, nil
+}
+
+// Writes csv records into a file. The records may have a
+// different count of fields.
+// -Input filePath: The path of the file to create.
+// -Input records: The records to write.
+func writeRecords(filePath string, records [][]string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+
+	if err := writer.WriteAll(records); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// Reads all the csv records of a file. The records may
+// have a different count of fields.
+// -Input filePath: The path of the file to read.
+// -Output: The records of the file.
+func readRecords(filePath string) ([][]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	return reader.ReadAll()
+}